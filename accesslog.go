@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"lambdahttpgw/config"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const accessLogTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+var (
+	accessLogFormat = config.GetAccessLogFormat()
+	accessLogger    = newAccessLogger(accessLogFormat, config.GetAccessLogFile())
+)
+
+// newAccessLogger returns the logger for access log lines, or nil if
+// access logging is disabled.
+func newAccessLogger(format string, file string) *log.Logger {
+	if format != "common" && format != "combined" {
+		return nil
+	}
+	var out io.Writer = os.Stdout
+	if file != "" {
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			logrus.Fatalf("error opening access log file %v: %v", file, err)
+		}
+		out = f
+	}
+	return log.New(out, "", 0)
+}
+
+// writeAccessLog writes a line for the request in Common or Combined Log
+// Format, followed by the request duration in milliseconds.
+func writeAccessLog(req *http.Request, recorder *statusRecorder, startTime time.Time) {
+	if accessLogger == nil {
+		return
+	}
+	size := "-"
+	if recorder.bytesWritten > 0 {
+		size = strconv.Itoa(recorder.bytesWritten)
+	}
+	line := fmt.Sprintf("%v - - [%v] \"%v %v %v\" %v %v",
+		getSourceIP(req),
+		startTime.Format(accessLogTimeFormat),
+		req.Method,
+		req.RequestURI,
+		req.Proto,
+		recorder.statusCode,
+		size,
+	)
+	if accessLogFormat == "combined" {
+		line += fmt.Sprintf(" %q %q", valueOrDash(req.Referer()), valueOrDash(req.UserAgent()))
+	}
+	accessLogger.Printf("%v %v", line, time.Since(startTime).Milliseconds())
+}
+
+func valueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	tests := []struct {
+		format  string
+		pattern string
+	}{
+		{format: "common", pattern: `^192\.0\.2\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /fn/items\?a=1 HTTP/1\.1" 201 7 \d+\n$`},
+		{format: "combined", pattern: `^192\.0\.2\.1 - - \[[^]]+\] "GET /fn/items\?a=1 HTTP/1\.1" 201 7 "https://example\.com/" "test-agent" \d+\n$`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var output bytes.Buffer
+			previous := accessLogger
+			accessLogger = log.New(&output, "", 0)
+			t.Cleanup(func() { accessLogger = previous })
+			setString(t, &accessLogFormat, tt.format)
+
+			fake := &fakeInvoker{respond: respondWith(proxyOutput(http.StatusCreated, nil, "created"), nil)}
+			clients := newFakeClients(fake)
+			setReady()
+			req := httptest.NewRequest(http.MethodGet, "/fn/items?a=1", nil)
+			req.Header.Set("Referer", "https://example.com/")
+			req.Header.Set("User-Agent", "test-agent")
+			instrument(func(w http.ResponseWriter, req *http.Request) {
+				handler(w, req, clients)
+			})(httptest.NewRecorder(), req)
+
+			if !regexp.MustCompile(tt.pattern).MatchString(output.String()) {
+				t.Errorf("expected access log line to match %v, got %q", tt.pattern, output.String())
+			}
+		})
+	}
+}
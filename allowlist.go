@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"lambdahttpgw/config"
+	"path"
+)
+
+// allowedFunctions are the patterns of function names that may be invoked,
+// where empty means any function may be invoked.
+var allowedFunctions = config.GetAllowedFunctions()
+
+// checkFunctionAllowed returns an error if the function name, or ARN, does
+// not match any of the allowed function patterns.
+func checkFunctionAllowed(functionName string) error {
+	if len(allowedFunctions) == 0 {
+		return nil
+	}
+	for _, pattern := range allowedFunctions {
+		if matched, _ := path.Match(pattern, functionName); matched {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %v", errFunctionNotAllowed, functionName)
+}
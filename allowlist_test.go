@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAllowedFunctions(t *testing.T) {
+	tests := []struct {
+		name       string
+		allowed    []string
+		path       string
+		statusCode int
+	}{
+		{name: "unset", path: "/any-fn/", statusCode: http.StatusOK},
+		{name: "allowed", allowed: []string{"users", "orders-*"}, path: "/users/", statusCode: http.StatusOK},
+		{name: "denied", allowed: []string{"users", "orders-*"}, path: "/billing/", statusCode: http.StatusForbidden},
+		{name: "glob", allowed: []string{"users", "orders-*"}, path: "/orders-v2/", statusCode: http.StatusOK},
+		{name: "glob prefix only", allowed: []string{"orders-*"}, path: "/orders/", statusCode: http.StatusForbidden},
+		{name: "qualifier", allowed: []string{"users"}, path: "/users:live/", statusCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := allowedFunctions
+			allowedFunctions = tt.allowed
+			t.Cleanup(func() { allowedFunctions = previous })
+			fake := &fakeInvoker{}
+
+			w := serve(newFakeClients(fake), httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if w.Code != tt.statusCode {
+				t.Fatalf("expected %v, got %v: %s", tt.statusCode, w.Code, w.Body)
+			}
+			if invoked := len(fake.invocations()) > 0; invoked != (tt.statusCode == http.StatusOK) {
+				t.Errorf("expected invoked to be %v", !invoked)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+	"lambdahttpgw/config"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// verifiedCredentialsTTL is how long verified Basic auth credentials are
+// accepted without being verified again.
+const verifiedCredentialsTTL = time.Minute
+
+var (
+	apiKeys      = config.GetApiKeys()
+	apiKeyHeader = config.GetApiKeyHeader()
+
+	basicAuthUsers   = config.GetBasicAuthUsers()
+	basicAuthForward = config.GetBasicAuthForward()
+
+	// basicAuthRealm is sent in the WWW-Authenticate header of 401 responses
+	basicAuthRealm = `Basic realm="lambda-http-gateway", charset="UTF-8"`
+
+	// unknownUserHash is compared for unknown users, so timing does not reveal which users exist
+	unknownUserHash = newUnknownUserHash()
+
+	// verifiedCredentials holds recently verified Basic auth credentials, as
+	// bcrypt is deliberately slow to verify
+	verifiedCredentials = newCredentialCache(verifiedCredentialsTTL)
+)
+
+// credentialCache holds the hashes of credentials that have been verified,
+// until they expire, so the credentials themselves are not held in memory.
+// It is safe for concurrent use.
+type credentialCache struct {
+	lock      sync.Mutex
+	ttl       time.Duration
+	expiries  map[[sha256.Size]byte]time.Time
+	lastSweep time.Time
+}
+
+func newCredentialCache(ttl time.Duration) *credentialCache {
+	return &credentialCache{
+		ttl:       ttl,
+		expiries:  make(map[[sha256.Size]byte]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+// contains determines whether the credentials were verified within the TTL.
+func (c *credentialCache) contains(key [sha256.Size]byte) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	expiry, exists := c.expiries[key]
+	return exists && time.Now().Before(expiry)
+}
+
+// add records the credentials as verified, evicting expired entries at
+// most once per TTL.
+func (c *credentialCache) add(key [sha256.Size]byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastSweep) >= c.ttl {
+		for existing, expiry := range c.expiries {
+			if now.After(expiry) {
+				delete(c.expiries, existing)
+			}
+		}
+		c.lastSweep = now
+	}
+	c.expiries[key] = now.Add(c.ttl)
+}
+
+// isAuthorised determines whether the request carries a valid API key.
+// All requests are authorised if no API keys are configured.
+func isAuthorised(req *http.Request) bool {
+	if len(apiKeys) == 0 {
+		return true
+	}
+	provided := []byte(req.Header.Get(apiKeyHeader))
+	if len(provided) == 0 {
+		return false
+	}
+	var valid bool
+	for _, apiKey := range apiKeys {
+		// check every key, so timing does not reveal which key matched
+		if subtle.ConstantTimeCompare(provided, []byte(apiKey)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// newUnknownUserHash returns the hash compared for unknown users. The
+// configured password hashes are checked by config.Validate.
+func newUnknownUserHash() []byte {
+	if len(basicAuthUsers) == 0 {
+		return nil
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte("unknown user"), bcrypt.DefaultCost)
+	if err != nil {
+		logrus.Fatalf("error generating password hash: %v", err)
+	}
+	return hash
+}
+
+// isBasicAuthorised determines whether the request carries valid HTTP Basic
+// auth credentials. All requests are authorised if no users are configured.
+// Credentials verified within verifiedCredentialsTTL are not verified again.
+// Unless configured to be forwarded, the Authorization header is removed
+// from authorised requests, so the password is not sent to the function.
+func isBasicAuthorised(req *http.Request) bool {
+	if len(basicAuthUsers) == 0 {
+		return true
+	}
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		return false
+	}
+	key := sha256.Sum256([]byte(username + "\x00" + password))
+	if !verifiedCredentials.contains(key) {
+		hash, exists := basicAuthUsers[username]
+		if !exists {
+			_ = bcrypt.CompareHashAndPassword(unknownUserHash, []byte(password))
+			return false
+		}
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+			return false
+		}
+		verifiedCredentials.add(key)
+	}
+	if !basicAuthForward {
+		req.Header.Del("Authorization")
+	}
+	return true
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// setApiKeys requires one of the API keys for the duration of the test.
+func setApiKeys(t *testing.T, keys []string) {
+	previous := apiKeys
+	apiKeys = keys
+	t.Cleanup(func() { apiKeys = previous })
+}
+
+func TestApiKeyAuth(t *testing.T) {
+	setApiKeys(t, []string{"key-one", "key-two"})
+	tests := []struct {
+		name       string
+		apiKey     string
+		statusCode int
+	}{
+		{name: "valid key", apiKey: "key-two", statusCode: http.StatusOK},
+		{name: "missing key", statusCode: http.StatusUnauthorized},
+		{name: "wrong key", apiKey: "key-three", statusCode: http.StatusUnauthorized},
+		{name: "key prefix", apiKey: "key", statusCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeInvoker{}
+			req := httptest.NewRequest(http.MethodGet, "/fn/", nil)
+			if tt.apiKey != "" {
+				req.Header.Set(apiKeyHeader, tt.apiKey)
+			}
+
+			w := serve(newFakeClients(fake), req)
+
+			if w.Code != tt.statusCode {
+				t.Errorf("expected %v, got %v", tt.statusCode, w.Code)
+			}
+			if invoked := len(fake.invocations()) > 0; invoked != (tt.statusCode == http.StatusOK) {
+				t.Errorf("expected invoked to be %v", !invoked)
+			}
+		})
+	}
+}
+
+// setBasicAuthUsers requires Basic auth credentials of one of the users for
+// the duration of the test, keyed by username with the password as values.
+func setBasicAuthUsers(t *testing.T, passwords map[string]string) {
+	users := make(map[string]string)
+	for username, password := range passwords {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		users[username] = string(hash)
+	}
+	previousUsers, previousHash, previousVerified := basicAuthUsers, unknownUserHash, verifiedCredentials
+	basicAuthUsers, verifiedCredentials = users, newCredentialCache(verifiedCredentialsTTL)
+	unknownUserHash, _ = bcrypt.GenerateFromPassword([]byte("unknown user"), bcrypt.MinCost)
+	t.Cleanup(func() {
+		basicAuthUsers, unknownUserHash, verifiedCredentials = previousUsers, previousHash, previousVerified
+	})
+}
+
+func TestBasicAuth(t *testing.T) {
+	setBasicAuthUsers(t, map[string]string{"alice": "secret"})
+	tests := []struct {
+		name          string
+		username      string
+		password      string
+		forward       bool
+		statusCode    int
+		authorization bool
+	}{
+		{name: "valid", username: "alice", password: "secret", statusCode: http.StatusOK},
+		{name: "valid and forwarded", username: "alice", password: "secret", forward: true, statusCode: http.StatusOK, authorization: true},
+		{name: "wrong password", username: "alice", password: "guess", statusCode: http.StatusUnauthorized},
+		{name: "unknown user", username: "bob", password: "secret", statusCode: http.StatusUnauthorized},
+		{name: "missing", statusCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setBool(t, &basicAuthForward, tt.forward)
+			fake := &fakeInvoker{}
+			req := httptest.NewRequest(http.MethodGet, "/fn/", nil)
+			if tt.username != "" {
+				req.SetBasicAuth(tt.username, tt.password)
+			}
+
+			w := serve(newFakeClients(fake), req)
+
+			if w.Code != tt.statusCode {
+				t.Fatalf("expected %v, got %v", tt.statusCode, w.Code)
+			}
+			if tt.statusCode == http.StatusUnauthorized {
+				if challenge := w.Header().Get("WWW-Authenticate"); challenge != basicAuthRealm {
+					t.Errorf("expected a Basic auth challenge, got %q", challenge)
+				}
+				if len(fake.invocations()) > 0 {
+					t.Error("expected the function not to be invoked")
+				}
+				return
+			}
+			if _, forwarded := fake.lastEvent(t).Headers["Authorization"]; forwarded != tt.authorization {
+				t.Errorf("expected the Authorization header to be forwarded: %v", tt.authorization)
+			}
+		})
+	}
+}
+
+func TestBasicAuthVerificationCache(t *testing.T) {
+	tests := []struct {
+		name       string
+		password   string
+		ttl        time.Duration
+		statusCode int
+	}{
+		{name: "verified within TTL", password: "secret", ttl: time.Minute, statusCode: http.StatusOK},
+		{name: "verified after TTL", password: "secret", ttl: -time.Second, statusCode: http.StatusUnauthorized},
+		{name: "other password", password: "guess", ttl: time.Minute, statusCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setBasicAuthUsers(t, map[string]string{"alice": "secret"})
+			verifiedCredentials = newCredentialCache(tt.ttl)
+			req := httptest.NewRequest(http.MethodGet, "/fn/", nil)
+			req.SetBasicAuth("alice", "secret")
+			if w := serve(newFakeClients(&fakeInvoker{}), req); w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %v", w.Code)
+			}
+
+			// once verified, the stored hash is not needed unless the verification has expired
+			basicAuthUsers["alice"] = string(unknownUserHash)
+			req = httptest.NewRequest(http.MethodGet, "/fn/", nil)
+			req.SetBasicAuth("alice", tt.password)
+
+			if w := serve(newFakeClients(&fakeInvoker{}), req); w.Code != tt.statusCode {
+				t.Errorf("expected %v, got %v", tt.statusCode, w.Code)
+			}
+		})
+	}
+}
+
+func TestRateLimitBeforeBasicAuth(t *testing.T) {
+	setBasicAuthUsers(t, map[string]string{"alice": "secret"})
+	setRateLimit(t, 1, 1, "ip", nil)
+	fake := &fakeInvoker{}
+	clients := newFakeClients(fake)
+
+	statusCodes := make([]int, 3)
+	for i := range statusCodes {
+		req := httptest.NewRequest(http.MethodGet, "/fn/", nil)
+		req.SetBasicAuth("alice", "guess")
+		statusCodes[i] = serve(clients, req).Code
+	}
+
+	// later guesses are rejected by the rate limit, without the password being verified
+	if expected := []int{http.StatusUnauthorized, http.StatusTooManyRequests, http.StatusTooManyRequests}; !reflect.DeepEqual(statusCodes, expected) {
+		t.Errorf("expected status codes %v, got %v", expected, statusCodes)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"lambdahttpgw/config"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheStatusHeader reports whether the response was returned from the response cache
+const cacheStatusHeader = "X-Cache"
+
+var cacheVaryHeaders = config.GetResponseCacheVaryHeaders()
+
+// credentialHeaders identify the client, so cached responses to requests
+// carrying them are only returned to requests with the same values.
+var credentialHeaders = []string{"Authorization", "Cookie", apiKeyHeader}
+
+// getCache holds GET responses, for the duration allowed by their
+// Cache-Control header. It is nil if response caching is disabled.
+var getCache = newGetCache(config.GetResponseCacheSize())
+
+func newGetCache(size int) *responseCache {
+	if size <= 0 {
+		return nil
+	}
+	return newResponseCache(size)
+}
+
+// responseCacheKey returns the response cache key for the request, or an
+// empty string if the request cannot be cached. The key includes the function,
+// path, query string, the configured vary headers and the client credentials.
+func responseCacheKey(req *http.Request, proxyReq *proxyRequest, credentials string) string {
+	if getCache == nil || req.Method != http.MethodGet || hasCacheDirective(req.Header.Get("Cache-Control"), "no-store") {
+		return ""
+	}
+	var key strings.Builder
+	for _, part := range []string{proxyReq.Region, proxyReq.FunctionName, proxyReq.Qualifier, proxyReq.Path, proxyReq.RawQueryString} {
+		key.WriteString(part)
+		key.WriteByte(0)
+	}
+	for _, header := range cacheVaryHeaders {
+		key.WriteString(strings.Join(req.Header.Values(header), ","))
+		key.WriteByte(0)
+	}
+	key.WriteString(credentials)
+	return key.String()
+}
+
+// credentialHash returns a hash of the credentials in the request, or an
+// empty string if there are none, so cached responses can be scoped to the
+// client without holding its credentials in memory.
+func credentialHash(req *http.Request) string {
+	hash := sha256.New()
+	var found bool
+	for _, header := range credentialHeaders {
+		for _, value := range req.Header.Values(header) {
+			found = true
+			_, _ = io.WriteString(hash, header+"\x00"+value+"\x00")
+		}
+	}
+	if !found {
+		return ""
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// getCachedResponse returns the cached response for the key, unless the
+// client requires the response to be revalidated.
+func getCachedResponse(req *http.Request, cacheKey string) (*proxyResponse, bool) {
+	if cacheKey == "" {
+		return nil, false
+	}
+	if hasCacheDirective(req.Header.Get("Cache-Control"), "no-cache") || req.Header.Get("Pragma") == "no-cache" {
+		return nil, false
+	}
+	resp, found := getCache.get(cacheKey)
+	if found {
+		resp.setHeader(cacheStatusHeader, "HIT")
+	}
+	return resp, found
+}
+
+// storeCachedResponse caches a successful response, for its max age.
+// Responses without a max age, or which set cookies, are never cached.
+func storeCachedResponse(cacheKey string, resp *proxyResponse) {
+	if cacheKey == "" {
+		return
+	}
+	resp.setHeader(cacheStatusHeader, "MISS")
+	if resp.StatusCode != http.StatusOK || resp.getHeader("Set-Cookie") != "" {
+		return
+	}
+	if maxAge := responseMaxAge(resp.getHeader("Cache-Control")); maxAge > 0 {
+		getCache.add(cacheKey, resp, maxAge)
+	}
+}
+
+// responseMaxAge returns the duration a response can be cached for by a
+// shared cache, per its Cache-Control header, or 0 if it cannot be cached.
+func responseMaxAge(cacheControl string) time.Duration {
+	var maxAge, sharedMaxAge string
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value := splitDirective(directive)
+		switch name {
+		case "no-store", "no-cache", "private":
+			return 0
+		case "max-age":
+			maxAge = value
+		case "s-maxage":
+			sharedMaxAge = value
+		}
+	}
+	if sharedMaxAge != "" {
+		// takes precedence for shared caches, per RFC 7234
+		maxAge = sharedMaxAge
+	}
+	seconds, err := strconv.Atoi(maxAge)
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func hasCacheDirective(cacheControl string, directive string) bool {
+	for _, d := range strings.Split(cacheControl, ",") {
+		if name, _ := splitDirective(d); name == directive {
+			return true
+		}
+	}
+	return false
+}
+
+// splitDirective returns the lowercase name and the value of a Cache-Control directive.
+func splitDirective(directive string) (string, string) {
+	name, value := strings.TrimSpace(directive), ""
+	if i := strings.IndexByte(name, '='); i >= 0 {
+		name, value = name[:i], strings.Trim(name[i+1:], `"`)
+	}
+	return strings.ToLower(name), value
+}
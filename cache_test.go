@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func enableResponseCache(t *testing.T) {
+	previous := getCache
+	getCache = newResponseCache(10)
+	t.Cleanup(func() {
+		getCache = previous
+	})
+}
+
+func newCacheableResponse(cacheControl string) *proxyResponse {
+	return &proxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"Cache-Control": cacheControl},
+		Body:       []byte(`{"ok":true}`),
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	tests := []struct {
+		name          string
+		storeHeaders  map[string]string
+		cacheControl  string
+		statusCode    int
+		setCookie     bool
+		lookupHeaders map[string]string
+		expectHit     bool
+	}{
+		{name: "hit", cacheControl: "max-age=60", expectHit: true},
+		{name: "shared max age", cacheControl: "s-maxage=60", expectHit: true},
+		{name: "no max age", cacheControl: "public"},
+		{name: "zero max age", cacheControl: "max-age=0"},
+		{name: "private response", cacheControl: "private, max-age=60"},
+		{name: "no-store response", cacheControl: "no-store, max-age=60"},
+		{name: "no-cache response", cacheControl: "no-cache, max-age=60"},
+		{name: "error response", cacheControl: "max-age=60", statusCode: http.StatusNotFound},
+		{name: "response sets cookie", cacheControl: "max-age=60", setCookie: true},
+		{name: "client no-cache", cacheControl: "max-age=60", lookupHeaders: map[string]string{"Cache-Control": "no-cache"}},
+		{name: "client pragma", cacheControl: "max-age=60", lookupHeaders: map[string]string{"Pragma": "no-cache"}},
+		{name: "same credentials", cacheControl: "max-age=60",
+			storeHeaders:  map[string]string{"Authorization": "Bearer a"},
+			lookupHeaders: map[string]string{"Authorization": "Bearer a"}, expectHit: true},
+		{name: "other credentials", cacheControl: "max-age=60",
+			storeHeaders:  map[string]string{"Authorization": "Bearer a"},
+			lookupHeaders: map[string]string{"Authorization": "Bearer b"}},
+		{name: "credentials not sent", cacheControl: "max-age=60",
+			storeHeaders: map[string]string{"Cookie": "session=a"}},
+		{name: "other api key", cacheControl: "max-age=60",
+			storeHeaders:  map[string]string{apiKeyHeader: "a"},
+			lookupHeaders: map[string]string{apiKeyHeader: "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enableResponseCache(t)
+			proxyReq := &proxyRequest{Region: "eu-west-1", FunctionName: "fn", Path: "/items", RawQueryString: "a=1"}
+
+			storeReq := httptest.NewRequest(http.MethodGet, "/fn/items?a=1", nil)
+			for name, value := range tt.storeHeaders {
+				storeReq.Header.Set(name, value)
+			}
+			resp := newCacheableResponse(tt.cacheControl)
+			if tt.statusCode != 0 {
+				resp.StatusCode = tt.statusCode
+			}
+			if tt.setCookie {
+				resp.setHeader("Set-Cookie", "session=abc")
+			}
+			storeCachedResponse(responseCacheKey(storeReq, proxyReq, credentialHash(storeReq)), resp)
+			if cacheStatus := resp.getHeader(cacheStatusHeader); cacheStatus != "MISS" {
+				t.Errorf("expected MISS on stored response, got %q", cacheStatus)
+			}
+
+			lookupReq := httptest.NewRequest(http.MethodGet, "/fn/items?a=1", nil)
+			for name, value := range tt.lookupHeaders {
+				lookupReq.Header.Set(name, value)
+			}
+			cached, found := getCachedResponse(lookupReq, responseCacheKey(lookupReq, proxyReq, credentialHash(lookupReq)))
+			if found != tt.expectHit {
+				t.Fatalf("expected hit %v, got %v", tt.expectHit, found)
+			}
+			if found && cached.getHeader(cacheStatusHeader) != "HIT" {
+				t.Errorf("expected HIT on cached response, got %q", cached.getHeader(cacheStatusHeader))
+			}
+		})
+	}
+}
+
+func TestResponseCacheKeyUncacheable(t *testing.T) {
+	enableResponseCache(t)
+	proxyReq := &proxyRequest{FunctionName: "fn", Path: "/"}
+
+	post := httptest.NewRequest(http.MethodPost, "/fn/", nil)
+	if key := responseCacheKey(post, proxyReq, ""); key != "" {
+		t.Errorf("expected POST not to be cached, got key %q", key)
+	}
+	noStore := httptest.NewRequest(http.MethodGet, "/fn/", nil)
+	noStore.Header.Set("Cache-Control", "no-store")
+	if key := responseCacheKey(noStore, proxyReq, ""); key != "" {
+		t.Errorf("expected no-store request not to be cached, got key %q", key)
+	}
+}
+
+func TestResponseMaxAge(t *testing.T) {
+	tests := []struct {
+		cacheControl string
+		expected     time.Duration
+	}{
+		{"", 0},
+		{"max-age=60", time.Minute},
+		{"public, max-age=60", time.Minute},
+		{"max-age=60, s-maxage=10", 10 * time.Second},
+		{`max-age="30"`, 30 * time.Second},
+		{"MAX-AGE=5", 5 * time.Second},
+		{"max-age=invalid", 0},
+		{"private, max-age=60", 0},
+		{`private="Set-Cookie", max-age=60`, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cacheControl, func(t *testing.T) {
+			if actual := responseMaxAge(tt.cacheControl); actual != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestResponseCacheExpiry(t *testing.T) {
+	cache := newResponseCache(1)
+	cache.add("a", newCacheableResponse("max-age=1"), 10*time.Millisecond)
+	if _, found := cache.get("a"); !found {
+		t.Fatal("expected entry before expiry")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, found := cache.get("a"); found {
+		t.Error("expected entry to expire after its TTL")
+	}
+
+	cache.add("a", newCacheableResponse("max-age=1"), time.Minute)
+	cache.add("b", newCacheableResponse("max-age=1"), time.Minute)
+	if _, found := cache.get("a"); found {
+		t.Error("expected least recently used entry to be evicted")
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+	"lambdahttpgw/config"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// canaryRoutes splits requests for a function between its stable and
+// canary versions, keyed by function name. It holds a
+// map[string]config.CanaryRoute, which is replaced when the routes are reloaded.
+var canaryRoutes = loadCanaryRoutes()
+
+func loadCanaryRoutes() *atomic.Value {
+	routes := &atomic.Value{}
+	routes.Store(config.GetCanaryRoutes())
+	return routes
+}
+
+// selectCanary returns the function version to invoke for a request to the
+// named function. The stable or canary version is chosen at random according
+// to the canary weight, unless the request specifies a qualifier.
+func selectCanary(functionName string, headerQualifier string) string {
+	route, exists := canaryRoutes.Load().(map[string]config.CanaryRoute)[functionName]
+	if !exists || headerQualifier != "" {
+		return functionName
+	}
+	if route.Canary != "" && rand.Intn(100) < route.CanaryWeight {
+		return route.Canary
+	}
+	if route.Stable != "" {
+		return route.Stable
+	}
+	return functionName
+}
+
+// watchCanaryRoutes reloads the canary routes from the configuration file
+// when a hangup signal is received, so weights can be adjusted during a
+// rollout without restarting the gateway.
+func watchCanaryRoutes() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			reloadCanaryRoutes()
+		}
+	}()
+}
+
+func reloadCanaryRoutes() {
+	if err := config.ReloadConfigFile(); err != nil {
+		logrus.Errorf("not reloading canary routes: %v", err)
+		return
+	}
+	routes := config.GetCanaryRoutes()
+	canaryRoutes.Store(routes)
+	logrus.Infof("reloaded %d canary routes", len(routes))
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io/ioutil"
+	"lambdahttpgw/config"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func setCanaryRoutes(t *testing.T, routes map[string]config.CanaryRoute) {
+	previous := canaryRoutes.Load()
+	canaryRoutes.Store(routes)
+	t.Cleanup(func() {
+		canaryRoutes.Store(previous)
+	})
+}
+
+func TestSelectCanarySplit(t *testing.T) {
+	tests := []struct {
+		name   string
+		weight int
+	}{
+		{"no canary traffic", 0},
+		{"ten percent", 10},
+		{"even split", 50},
+		{"all canary traffic", 100},
+	}
+	const requests = 20000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setCanaryRoutes(t, map[string]config.CanaryRoute{
+				"fn": {Stable: "fn:prod", Canary: "fn:next", CanaryWeight: tt.weight},
+			})
+			var canary int
+			for i := 0; i < requests; i++ {
+				switch selected := selectCanary("fn", ""); selected {
+				case "fn:next":
+					canary++
+				case "fn:prod":
+				default:
+					t.Fatalf("unexpected function %v", selected)
+				}
+			}
+			// allow five standard deviations of the binomial distribution
+			p := float64(tt.weight) / 100
+			tolerance := 5 * math.Sqrt(requests*p*(1-p))
+			if expected := requests * p; math.Abs(float64(canary)-expected) > tolerance {
+				t.Errorf("expected %v±%.0f canary requests, got %v", expected, tolerance, canary)
+			}
+		})
+	}
+}
+
+func TestSelectCanaryPassthrough(t *testing.T) {
+	setCanaryRoutes(t, map[string]config.CanaryRoute{
+		"fn": {Stable: "fn:prod", Canary: "fn:next", CanaryWeight: 100},
+	})
+	if selected := selectCanary("fn", "v2"); selected != "fn" {
+		t.Errorf("expected qualified request to be unaffected, got %v", selected)
+	}
+	if selected := selectCanary("other", ""); selected != "other" {
+		t.Errorf("expected function without a route to be unaffected, got %v", selected)
+	}
+}
+
+func TestReloadCanaryRoutes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	t.Setenv("CONFIG_FILE", path)
+	setCanaryRoutes(t, map[string]config.CanaryRoute{})
+	writeFile := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		writeFile("{}")
+		if err := config.ReloadConfigFile(); err != nil {
+			t.Errorf("error resetting config file: %v", err)
+		}
+	})
+
+	writeFile("CANARY_ROUTES:\n  fn:\n    stable: fn:prod\n    canary: fn:next\n    canaryWeight: 100\n")
+	reloadCanaryRoutes()
+	if selected := selectCanary("fn", ""); selected != "fn:next" {
+		t.Fatalf("expected reloaded weight to apply, got %v", selected)
+	}
+
+	writeFile("CANARY_ROUTES: not-json\n")
+	reloadCanaryRoutes()
+	if selected := selectCanary("fn", ""); selected != "fn:next" {
+		t.Errorf("expected invalid file to retain previous routes, got %v", selected)
+	}
+
+	writeFile("CANARY_ROUTES:\n  fn:\n    stable: fn:prod\n    canary: fn:next\n    canaryWeight: 150\n")
+	reloadCanaryRoutes()
+	if selected := selectCanary("fn", ""); selected != "fn:next" {
+		t.Errorf("expected out of range weight to retain previous routes, got %v", selected)
+	}
+
+	writeFile("CANARY_ROUTES:\n  fn:\n    stable: fn:prod\n    canary: fn:next\n    canaryWeight: 0\n")
+	reloadCanaryRoutes()
+	if selected := selectCanary("fn", ""); selected != "fn:prod" {
+		t.Errorf("expected updated weight to apply, got %v", selected)
+	}
+}
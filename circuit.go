@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"lambdahttpgw/config"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var breaker = newCircuitBreaker(config.GetCircuitFailureThreshold(), config.GetCircuitResetTimeout())
+
+type circuitState struct {
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// circuitBreaker fails fast for functions that have failed repeatedly. After
+// the threshold of consecutive failures is reached, the circuit opens and
+// requests are rejected until the reset timeout elapses. A single probe request
+// is then allowed; if it succeeds the circuit closes, otherwise it reopens.
+type circuitBreaker struct {
+	lock         sync.Mutex
+	threshold    int
+	resetTimeout time.Duration
+	states       map[string]*circuitState
+}
+
+func newCircuitBreaker(threshold int, resetTimeout time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold:    threshold,
+		resetTimeout: resetTimeout,
+		states:       make(map[string]*circuitState),
+	}
+}
+
+// allow determines whether a request to the function may proceed.
+func (b *circuitBreaker) allow(functionName string) bool {
+	if b.threshold <= 0 {
+		return true
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	state, exists := b.states[functionName]
+	if !exists || state.failures < b.threshold {
+		return true
+	}
+	if state.probing || time.Since(state.openedAt) < b.resetTimeout {
+		return false
+	}
+	state.probing = true
+	return true
+}
+
+// record updates the state of the circuit for the function with the
+// outcome of an invocation. Only errors indicating a fault with the function
+// or the Lambda service count as failures.
+func (b *circuitBreaker) record(functionName string, err error) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if err == nil {
+		delete(b.states, functionName)
+		return
+	}
+	state, exists := b.states[functionName]
+	if !isCircuitFailure(err) {
+		// the outcome says nothing about the health of the function,
+		// so allow another probe if this was one
+		if exists {
+			state.probing = false
+		}
+		return
+	}
+	if !exists {
+		state = &circuitState{}
+		b.states[functionName] = state
+	}
+	state.failures++
+	if state.failures >= b.threshold {
+		state.openedAt = time.Now()
+		state.probing = false
+	}
+}
+
+// isCircuitFailure determines whether an invocation error indicates a fault
+// with the function or the Lambda service, such as a function error, timeout,
+// throttling or server error. Cancelled requests and client errors, such as
+// an unknown function, do not.
+func isCircuitFailure(err error) bool {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return false
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, errFunctionError):
+		return true
+	}
+	statusCode, _ := invokeErrorStatus(err)
+	return statusCode >= 500 || statusCode == http.StatusTooManyRequests
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"testing"
+	"time"
+)
+
+var errTestFunction = &functionError{functionName: "fn", errorType: "Unhandled"}
+
+func TestCircuitBreakerTripsAndRecovers(t *testing.T) {
+	b := newCircuitBreaker(2, 20*time.Millisecond)
+
+	b.record("fn", errTestFunction)
+	if !b.allow("fn") {
+		t.Fatal("expected circuit to stay closed below the threshold")
+	}
+	b.record("fn", errTestFunction)
+	if b.allow("fn") {
+		t.Fatal("expected circuit to open at the threshold")
+	}
+	if !b.allow("other") {
+		t.Fatal("expected other functions to be unaffected")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if !b.allow("fn") {
+		t.Fatal("expected a probe after the reset timeout")
+	}
+	if b.allow("fn") {
+		t.Fatal("expected only a single probe")
+	}
+	b.record("fn", errTestFunction)
+	if b.allow("fn") {
+		t.Fatal("expected circuit to reopen after a failed probe")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if !b.allow("fn") {
+		t.Fatal("expected a probe after the reset timeout")
+	}
+	b.record("fn", nil)
+	if !b.allow("fn") || !b.allow("fn") {
+		t.Fatal("expected circuit to close after a successful probe")
+	}
+}
+
+func TestCircuitBreakerIgnoresNonFailures(t *testing.T) {
+	b := newCircuitBreaker(1, 20*time.Millisecond)
+
+	notFound := awserr.New(lambda.ErrCodeResourceNotFoundException, "not found", nil)
+	b.record("fn", fmt.Errorf("error calling fn: %w", context.Canceled))
+	b.record("fn", fmt.Errorf("error calling fn: %w", notFound))
+	if !b.allow("fn") {
+		t.Fatal("expected cancellation and client errors not to open the circuit")
+	}
+
+	b.record("fn", errTestFunction)
+	time.Sleep(30 * time.Millisecond)
+	if !b.allow("fn") {
+		t.Fatal("expected a probe after the reset timeout")
+	}
+	b.record("fn", context.Canceled)
+	if !b.allow("fn") {
+		t.Fatal("expected another probe after a cancelled probe")
+	}
+}
+
+func TestIsCircuitFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"function error", errTestFunction, true},
+		{"timeout", fmt.Errorf("error calling fn: %w", context.DeadlineExceeded), true},
+		{"throttled", awserr.New(lambda.ErrCodeTooManyRequestsException, "rate exceeded", nil), true},
+		{"service error", awserr.New(lambda.ErrCodeServiceException, "internal error", nil), true},
+		{"network error", awserr.New("RequestError", "send request failed", nil), true},
+		{"cancelled", fmt.Errorf("error calling fn: %w", context.Canceled), false},
+		{"not found", awserr.New(lambda.ErrCodeResourceNotFoundException, "not found", nil), false},
+		{"access denied", awserr.New("AccessDeniedException", "denied", nil), false},
+		{"invalid invocation type", errInvalidInvocationType, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := isCircuitFailure(tt.err); actual != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/sirupsen/logrus"
+	"lambdahttpgw/config"
+	"net/http"
+	"regexp"
+	"sync"
+)
+
+const regionHeader = "X-Lambda-Region"
+
+// awsEndpoint overrides the Lambda service endpoint, such as for LocalStack.
+var awsEndpoint = config.GetAWSEndpoint()
+
+// lambdaVPCEndpoint is the interface VPC endpoint for the Lambda service in
+// the gateway region, such as when the endpoint does not use private DNS.
+var (
+	lambdaVPCEndpoint = config.GetLambdaVPCEndpoint()
+	gatewayRegion     = config.GetRegion()
+)
+
+// regionPattern matches AWS region names, such as 'eu-west-1'.
+var regionPattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-\d$`)
+
+// invoker invokes Lambda functions. It is satisfied by the Lambda service
+// client, and decouples invocation from the AWS SDK, such as to use a fake.
+type invoker interface {
+	InvokeWithContext(ctx aws.Context, input *lambda.InvokeInput, opts ...request.Option) (*lambda.InvokeOutput, error)
+}
+
+// identityClient returns the identity of the caller. It is satisfied by the
+// STS service client.
+type identityClient interface {
+	GetCallerIdentityWithContext(ctx aws.Context, input *sts.GetCallerIdentityInput, opts ...request.Option) (*sts.GetCallerIdentityOutput, error)
+}
+
+// clientCache holds a Lambda service client per region and role. Clients
+// are safe for concurrent use, so a single instance is shared across all
+// requests for a region and role.
+type clientCache struct {
+	sess      *session.Session
+	creds     *credentials.Credentials
+	lock      sync.Mutex
+	clients   map[clientKey]invoker
+	roleCreds map[string]*credentials.Credentials
+	// newInvoker creates the client for a region and role
+	newInvoker func(cfg *aws.Config) invoker
+	// newIdentityClient creates the client used to verify credentials
+	newIdentityClient func(cfg *aws.Config) identityClient
+}
+
+// clientKey identifies a client by region, and by the role assumed for
+// a tenant, where empty means the default credentials.
+type clientKey struct {
+	region  string
+	roleArn string
+}
+
+// role is an IAM role assumed to invoke functions.
+type role struct {
+	arn        string
+	externalId string
+}
+
+func newClientCache() *clientCache {
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		Config:            aws.Config{HTTPClient: newHTTPClient()},
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+	var creds *credentials.Credentials
+	if roleArn := config.GetAssumeRoleARN(); roleArn != "" {
+		logrus.Debugf("assuming role %v for lambda invocations", roleArn)
+		creds = assumeRoleCredentials(sess, role{arn: roleArn, externalId: config.GetAssumeRoleExternalID()})
+	}
+	return &clientCache{
+		sess:      sess,
+		creds:     creds,
+		clients:   make(map[clientKey]invoker),
+		roleCreds: make(map[string]*credentials.Credentials),
+		newInvoker: func(cfg *aws.Config) invoker {
+			return lambda.New(sess, cfg)
+		},
+		newIdentityClient: func(cfg *aws.Config) identityClient {
+			return sts.New(sess, cfg)
+		},
+	}
+}
+
+// newHTTPClient returns the HTTP client used for Lambda API requests. Most
+// requests go to the same regional endpoint, so more idle connections per
+// host are kept than the Go default, to avoid reconnecting under load.
+func newHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = config.GetAWSMaxIdleConns()
+	transport.MaxIdleConnsPerHost = config.GetAWSMaxIdleConnsPerHost()
+	transport.IdleConnTimeout = config.GetAWSIdleConnTimeout()
+	return &http.Client{Transport: transport}
+}
+
+// assumeRoleCredentials returns credentials for the role. The credentials
+// are shared by the clients for all regions, and are refreshed before
+// they expire.
+func assumeRoleCredentials(sess *session.Session, r role) *credentials.Credentials {
+	sessionName := config.GetAssumeRoleSessionName()
+	return stscreds.NewCredentials(sess, r.arn, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = sessionName
+		if r.externalId != "" {
+			p.ExternalID = aws.String(r.externalId)
+		}
+	})
+}
+
+// credentials returns the credentials used to invoke functions, assuming the
+// tenant role, if set.
+func (c *clientCache) credentials(tenantRole role) *credentials.Credentials {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.credentialsLocked(tenantRole)
+}
+
+func (c *clientCache) credentialsLocked(tenantRole role) *credentials.Credentials {
+	if tenantRole.arn != "" {
+		creds, exists := c.roleCreds[tenantRole.arn]
+		if !exists {
+			logrus.Debugf("assuming role %v for tenant lambda invocations", tenantRole.arn)
+			creds = assumeRoleCredentials(c.sess, tenantRole)
+			c.roleCreds[tenantRole.arn] = creds
+		}
+		return creds
+	}
+	if c.creds != nil {
+		return c.creds
+	}
+	return c.sess.Config.Credentials
+}
+
+// checkCredentials verifies that credentials are available to invoke
+// functions, by calling the STS GetCallerIdentity API, which requires
+// no permissions.
+func (c *clientCache) checkCredentials(ctx context.Context) (callerArn string, err error) {
+	cfg := &aws.Config{
+		Region:              aws.String(region),
+		Credentials:         c.credentials(role{}),
+		STSRegionalEndpoint: endpoints.RegionalSTSEndpoint,
+	}
+	if awsEndpoint != "" {
+		cfg.Endpoint = aws.String(awsEndpoint)
+	}
+	identity, err := c.newIdentityClient(cfg).GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(identity.Arn), nil
+}
+
+// get returns the client for the given region and tenant role, creating
+// it if required.
+func (c *clientCache) get(region string, tenantRole role) invoker {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := clientKey{region: region, roleArn: tenantRole.arn}
+	client, exists := c.clients[key]
+	if !exists {
+		logrus.Debugf("creating lambda client for region %v", region)
+
+		// retries are handled by the gateway, so are disabled in the SDK
+		cfg := &aws.Config{Region: aws.String(region), MaxRetries: aws.Int(0)}
+		if lambdaVPCEndpoint != "" && region == gatewayRegion {
+			// requests are still signed for the region, and the TLS certificate of the endpoint is verified
+			logrus.Debugf("using VPC endpoint %v for region %v", lambdaVPCEndpoint, region)
+			cfg.Endpoint = aws.String(lambdaVPCEndpoint)
+		} else if awsEndpoint != "" {
+			cfg.Endpoint = aws.String(awsEndpoint)
+			cfg.S3ForcePathStyle = aws.Bool(true)
+		}
+		cfg.Credentials = c.credentialsLocked(tenantRole)
+		client = c.newInvoker(cfg)
+		c.clients[key] = client
+	}
+	return client
+}
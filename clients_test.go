@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"io"
+	"io/ioutil"
+	"lambdahttpgw/config"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeInvoker is an invoker that records each invocation, and returns the
+// result of its respond function, so requests can be handled without AWS.
+type fakeInvoker struct {
+	lock    sync.Mutex
+	inputs  []*lambda.InvokeInput
+	configs []*aws.Config
+	// respond returns the result of an invocation, defaulting to an empty 200 response
+	respond func(ctx aws.Context, input *lambda.InvokeInput) (*lambda.InvokeOutput, error)
+}
+
+func (f *fakeInvoker) InvokeWithContext(ctx aws.Context, input *lambda.InvokeInput, _ ...request.Option) (*lambda.InvokeOutput, error) {
+	f.lock.Lock()
+	f.inputs = append(f.inputs, input)
+	respond := f.respond
+	f.lock.Unlock()
+	if respond == nil {
+		return proxyOutput(http.StatusOK, nil, ""), nil
+	}
+	return respond(ctx, input)
+}
+
+// invocations returns the inputs of each invocation so far.
+func (f *fakeInvoker) invocations() []*lambda.InvokeInput {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]*lambda.InvokeInput(nil), f.inputs...)
+}
+
+// lastEvent returns the version 1.0 event sent in the most recent invocation.
+func (f *fakeInvoker) lastEvent(t *testing.T) events.APIGatewayProxyRequest {
+	t.Helper()
+	var event events.APIGatewayProxyRequest
+	f.unmarshalLastPayload(t, &event)
+	return event
+}
+
+func (f *fakeInvoker) unmarshalLastPayload(t *testing.T, event interface{}) {
+	t.Helper()
+	inputs := f.invocations()
+	if len(inputs) == 0 {
+		t.Fatal("expected the function to be invoked")
+	}
+	if err := json.Unmarshal(inputs[len(inputs)-1].Payload, event); err != nil {
+		t.Fatalf("invalid event payload: %v", err)
+	}
+}
+
+// respondWith returns a respond function that always returns the result.
+func respondWith(output *lambda.InvokeOutput, err error) func(aws.Context, *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+	return func(aws.Context, *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+		return output, err
+	}
+}
+
+// proxyOutput returns an invocation result with a version 1.0 proxy response.
+func proxyOutput(statusCode int, headers map[string]string, body string) *lambda.InvokeOutput {
+	return payloadOutput(events.APIGatewayProxyResponse{StatusCode: statusCode, Headers: headers, Body: body})
+}
+
+// payloadOutput returns an invocation result with the response marshalled as its payload.
+func payloadOutput(response interface{}) *lambda.InvokeOutput {
+	payload, err := json.Marshal(response)
+	if err != nil {
+		panic(err)
+	}
+	return &lambda.InvokeOutput{StatusCode: aws.Int64(http.StatusOK), Payload: payload}
+}
+
+// newFakeClients returns a client cache whose clients are all the fake,
+// which records the configuration of each client created.
+func newFakeClients(fake *fakeInvoker) *clientCache {
+	clients := newClientCache()
+	clients.newInvoker = func(cfg *aws.Config) invoker {
+		fake.lock.Lock()
+		defer fake.lock.Unlock()
+		fake.configs = append(fake.configs, cfg)
+		return fake
+	}
+	return clients
+}
+
+func TestClientIsReusedAcrossRequests(t *testing.T) {
+	fake := &fakeInvoker{}
+	clients := newFakeClients(fake)
+	for i := 0; i < 3; i++ {
+		if w := serve(clients, httptest.NewRequest(http.MethodGet, "/fn/", nil)); w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %v: %s", w.Code, w.Body)
+		}
+	}
+	if invocations := len(fake.invocations()); invocations != 3 {
+		t.Errorf("expected 3 invocations, got %v", invocations)
+	}
+	if created := len(fake.configs); created != 1 {
+		t.Errorf("expected a single client to be created, got %v", created)
+	}
+}
+
+func TestClientUsesConfiguredRegion(t *testing.T) {
+	t.Setenv("AWS_REGION", "us-east-1")
+	setString(t, &region, config.GetRegion())
+	fake := &fakeInvoker{}
+
+	serve(newFakeClients(fake), httptest.NewRequest(http.MethodGet, "/fn/", nil))
+
+	if len(fake.configs) != 1 {
+		t.Fatalf("expected a client to be created, got %v", len(fake.configs))
+	}
+	if clientRegion := aws.StringValue(fake.configs[0].Region); clientRegion != "us-east-1" {
+		t.Errorf("expected client for us-east-1, got %v", clientRegion)
+	}
+}
+
+func TestRegionalClients(t *testing.T) {
+	fake := &fakeInvoker{}
+	clients := newFakeClients(fake)
+	tests := []struct {
+		headerRegion string
+		statusCode   int
+		region       string
+	}{
+		{headerRegion: "us-east-1", statusCode: http.StatusOK, region: "us-east-1"},
+		{statusCode: http.StatusOK, region: region},
+		{headerRegion: "us-east-1", statusCode: http.StatusOK, region: "us-east-1"},
+		{headerRegion: "ap-southeast-2", statusCode: http.StatusOK, region: "ap-southeast-2"},
+		{headerRegion: "not a region", statusCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/fn/", nil)
+		if tt.headerRegion != "" {
+			req.Header.Set(regionHeader, tt.headerRegion)
+		}
+		if w := serve(clients, req); w.Code != tt.statusCode {
+			t.Fatalf("expected %v for region %q, got %v: %s", tt.statusCode, tt.headerRegion, w.Code, w.Body)
+		}
+	}
+
+	var created []string
+	for _, cfg := range fake.configs {
+		created = append(created, aws.StringValue(cfg.Region))
+	}
+	if expected := []string{"us-east-1", region, "ap-southeast-2"}; !reflect.DeepEqual(created, expected) {
+		t.Errorf("expected a cached client per region %v, got %v", expected, created)
+	}
+}
+
+func TestClientEndpoint(t *testing.T) {
+	tests := []struct {
+		name         string
+		awsEndpoint  string
+		vpcEndpoint  string
+		headerRegion string
+		endpoint     string
+	}{
+		{name: "default"},
+		{name: "custom endpoint", awsEndpoint: "http://localhost:4566", endpoint: "http://localhost:4566"},
+		{name: "VPC endpoint", awsEndpoint: "http://localhost:4566", vpcEndpoint: "https://vpce-1.lambda.vpce.amazonaws.com", endpoint: "https://vpce-1.lambda.vpce.amazonaws.com"},
+		{name: "VPC endpoint in other region", awsEndpoint: "http://localhost:4566", vpcEndpoint: "https://vpce-1.lambda.vpce.amazonaws.com", headerRegion: "ap-southeast-2", endpoint: "http://localhost:4566"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setString(t, &awsEndpoint, tt.awsEndpoint)
+			setString(t, &lambdaVPCEndpoint, tt.vpcEndpoint)
+			setString(t, &gatewayRegion, region)
+			fake := &fakeInvoker{}
+			req := httptest.NewRequest(http.MethodGet, "/fn/", nil)
+			if tt.headerRegion != "" {
+				req.Header.Set(regionHeader, tt.headerRegion)
+			}
+
+			serve(newFakeClients(fake), req)
+
+			cfg := fake.configs[0]
+			if endpoint := aws.StringValue(cfg.Endpoint); endpoint != tt.endpoint {
+				t.Errorf("expected endpoint %q, got %q", tt.endpoint, endpoint)
+			}
+			if pathStyle := aws.BoolValue(cfg.S3ForcePathStyle); pathStyle != (tt.endpoint == tt.awsEndpoint && tt.awsEndpoint != "") {
+				t.Errorf("unexpected S3ForcePathStyle %v", pathStyle)
+			}
+		})
+	}
+}
+
+// TestVPCEndpointTLS invokes a function through a VPC endpoint using the
+// Lambda service client, verifying the request is signed for the gateway
+// region, and the TLS certificate of the endpoint is verified.
+func TestVPCEndpointTLS(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	var authorization atomic.Value
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		authorization.Store(req.Header.Get("Authorization"))
+		_, _ = w.Write([]byte(`{"statusCode":200,"body":"via endpoint"}`))
+	}))
+	defer server.Close()
+	setString(t, &lambdaVPCEndpoint, server.URL)
+	setString(t, &gatewayRegion, region)
+
+	tests := []struct {
+		name    string
+		trusted bool
+	}{
+		{name: "trusted certificate", trusted: true},
+		{name: "untrusted certificate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authorization.Store("")
+			clients := newClientCache()
+			if tt.trusted {
+				clients.sess.Config.HTTPClient = server.Client()
+			}
+			input := &lambda.InvokeInput{FunctionName: aws.String("fn"), Payload: []byte(`{}`)}
+
+			output, err := clients.get(region, role{}).InvokeWithContext(context.Background(), input)
+
+			if !tt.trusted {
+				if err == nil || !strings.Contains(err.Error(), "certificate") {
+					t.Fatalf("expected a certificate verification error, got %v", err)
+				}
+				if authorization.Load() != "" {
+					t.Error("expected no request to reach the endpoint")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(output.Payload), "via endpoint") {
+				t.Errorf("expected the response from the endpoint, got %s", output.Payload)
+			}
+			if scope := "/" + region + "/lambda/aws4_request"; !strings.Contains(authorization.Load().(string), scope) {
+				t.Errorf("expected the request to be signed for %v, got %v", scope, authorization.Load())
+			}
+		})
+	}
+}
+
+func TestAssumeRoleCredentials(t *testing.T) {
+	t.Setenv("ASSUME_ROLE_ARN", "arn:aws:iam::123456789012:role/invoker")
+	fake := &fakeInvoker{}
+	clients := newFakeClients(fake)
+	if clients.creds == nil {
+		t.Fatal("expected assumed role credentials")
+	}
+
+	clients.get(region, role{})
+	tenantRole := role{arn: "arn:aws:iam::210987654321:role/tenant", externalId: "tenant"}
+	clients.get(region, tenantRole)
+	clients.get("us-east-1", tenantRole)
+
+	if len(fake.configs) != 3 {
+		t.Fatalf("expected 3 clients, got %v", len(fake.configs))
+	}
+	if creds := fake.configs[0].Credentials; creds != clients.creds || creds == clients.sess.Config.Credentials {
+		t.Error("expected the client to use the assumed role credentials")
+	}
+	tenantCreds := fake.configs[1].Credentials
+	if tenantCreds == clients.creds || tenantCreds == nil {
+		t.Error("expected the tenant client to use the tenant role credentials")
+	}
+	if fake.configs[2].Credentials != tenantCreds {
+		t.Error("expected the tenant role credentials to be shared across regions")
+	}
+}
+
+// BenchmarkHTTPClient reports the connections opened per burst of concurrent
+// requests, for the Go default transport and the tuned transport.
+func BenchmarkHTTPClient(b *testing.B) {
+	clients := map[string]*http.Client{
+		"default": {Transport: http.DefaultTransport.(*http.Transport).Clone()},
+		"tuned":   newHTTPClient(),
+	}
+	for name, client := range clients {
+		b.Run(name, func(b *testing.B) {
+			var conns int64
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				// holds the connection, so requests overlap as invocations do
+				time.Sleep(time.Millisecond)
+				_, _ = w.Write([]byte("ok"))
+			}))
+			server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+				if state == http.StateNew {
+					atomic.AddInt64(&conns, 1)
+				}
+			}
+			server.Start()
+			defer server.Close()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var wg sync.WaitGroup
+				for r := 0; r < 16; r++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						resp, err := client.Get(server.URL)
+						if err != nil {
+							b.Error(err)
+							return
+						}
+						_, _ = io.Copy(ioutil.Discard, resp.Body)
+						_ = resp.Body.Close()
+					}()
+				}
+				wg.Wait()
+			}
+			b.ReportMetric(float64(atomic.LoadInt64(&conns))/float64(b.N), "conns/op")
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"lambdahttpgw/config"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	compressionEnabled = config.GetCompressionEnabled()
+	compressionMinSize = config.GetCompressionMinSize()
+)
+
+// compressResponse gzip-compresses the response body if compression is enabled,
+// the client accepts gzip, and the body is text-like and large enough
+// to benefit. Bodies already encoded by the function are left as-is.
+func compressResponse(req *http.Request, resp *proxyResponse) error {
+	if !compressionEnabled || len(resp.Body) < compressionMinSize {
+		return nil
+	}
+	if !acceptsGzip(req.Header.Get("Accept-Encoding")) || resp.getHeader("Content-Encoding") != "" {
+		return nil
+	}
+	if !isTextMimeType([]string{resp.getHeader("Content-Type")}) {
+		return nil
+	}
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(resp.Body); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	resp.Body = compressed.Bytes()
+	resp.setHeader("Content-Encoding", "gzip")
+	resp.setHeader("Content-Length", strconv.Itoa(len(resp.Body)))
+	if vary := resp.getHeader("Vary"); vary != "" {
+		resp.setHeader("Vary", vary+", Accept-Encoding")
+	} else {
+		resp.setHeader("Vary", "Accept-Encoding")
+	}
+	return nil
+}
+
+// decodeRequestBody decompresses a gzip or deflate encoded request body, as
+// functions rarely handle compressed bodies. The decompressed body is limited
+// to the maximum body size, guarding against decompression bombs. It returns
+// false if the body is not encoded, or uses another encoding, which is
+// forwarded as-is.
+func decodeRequestBody(contentEncoding string, body []byte) ([]byte, bool, error) {
+	var reader io.ReadCloser
+	var err error
+	switch strings.ToLower(strings.TrimSpace(contentEncoding)) {
+	case "gzip", "x-gzip":
+		reader, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		// deflate bodies use the zlib format, per RFC 7230
+		reader, err = zlib.NewReader(bytes.NewReader(body))
+	default:
+		return body, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %v", errContentEncoding, err)
+	}
+	defer reader.Close()
+
+	// reads one byte over the limit, to detect oversized bodies without reading them fully
+	decoded, err := ioutil.ReadAll(io.LimitReader(reader, maxBodySize+1))
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %v", errContentEncoding, err)
+	}
+	if int64(len(decoded)) > maxBodySize {
+		return nil, false, fmt.Errorf("%w: decompressed limit is %v bytes", errBodyTooLarge, maxBodySize)
+	}
+	return decoded, true, nil
+}
+
+// acceptsGzip determines whether the Accept-Encoding header permits gzip,
+// ignoring encodings with a quality value of zero.
+func acceptsGzip(acceptEncoding string) bool {
+	for _, encoding := range strings.Split(acceptEncoding, ",") {
+		parts := strings.Split(encoding, ";")
+		if !strings.EqualFold(strings.TrimSpace(parts[0]), "gzip") {
+			continue
+		}
+		for _, param := range parts[1:] {
+			if q := strings.TrimSpace(param); strings.HasPrefix(q, "q=") {
+				if quality, err := strconv.ParseFloat(q[2:], 64); err == nil && quality == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCompressResponse(t *testing.T) {
+	setBool(t, &compressionEnabled, true)
+	setInt(t, &compressionMinSize, 10)
+	body := strings.Repeat(`{"a":1}`, 20)
+
+	tests := []struct {
+		name            string
+		acceptEncoding  string
+		headers         map[string]string
+		body            string
+		contentEncoding string
+	}{
+		{name: "accepts gzip", acceptEncoding: "gzip, deflate", body: body, contentEncoding: "gzip"},
+		{name: "does not accept gzip", acceptEncoding: "br", body: body},
+		{name: "gzip refused", acceptEncoding: "gzip;q=0", body: body},
+		{name: "below threshold", acceptEncoding: "gzip", body: "{}"},
+		{name: "already encoded", acceptEncoding: "gzip", headers: map[string]string{"Content-Encoding": "br"}, body: body, contentEncoding: "br"},
+		{name: "binary", acceptEncoding: "gzip", headers: map[string]string{"Content-Type": "image/png"}, body: body},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{"Content-Type": "application/json"}
+			for name, value := range tt.headers {
+				headers[name] = value
+			}
+			fake := &fakeInvoker{respond: respondWith(proxyOutput(http.StatusOK, headers, tt.body), nil)}
+			req := httptest.NewRequest(http.MethodGet, "/fn/", nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+
+			w := serve(newFakeClients(fake), req)
+
+			if contentEncoding := w.Header().Get("Content-Encoding"); contentEncoding != tt.contentEncoding {
+				t.Fatalf("expected Content-Encoding %q, got %q", tt.contentEncoding, contentEncoding)
+			}
+			if tt.contentEncoding != "gzip" {
+				if w.Body.String() != tt.body {
+					t.Errorf("expected the body unchanged, got %q", w.Body)
+				}
+				return
+			}
+			if contentLength := w.Header().Get("Content-Length"); contentLength != strconv.Itoa(w.Body.Len()) {
+				t.Errorf("expected Content-Length of the compressed body, got %v", contentLength)
+			}
+			reader, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decompressed, err := ioutil.ReadAll(reader)
+			if err != nil || string(decompressed) != tt.body {
+				t.Errorf("expected the body to decompress, got %q: %v", decompressed, err)
+			}
+		})
+	}
+}
+
+// compress encodes the body with the content encoding.
+func compress(t *testing.T, contentEncoding string, body []byte) []byte {
+	var buf bytes.Buffer
+	var writer io.WriteCloser
+	switch contentEncoding {
+	case "gzip":
+		writer = gzip.NewWriter(&buf)
+	case "deflate":
+		writer = zlib.NewWriter(&buf)
+	default:
+		return body
+	}
+	if _, err := writer.Write(body); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecompressRequestBody(t *testing.T) {
+	setInt64(t, &maxBodySize, 1024)
+	tests := []struct {
+		name            string
+		contentEncoding string
+		body            []byte
+		raw             []byte
+		statusCode      int
+		want            string
+		wantEncoding    string
+	}{
+		{name: "gzip", contentEncoding: "gzip", body: []byte("hello gzip"), statusCode: http.StatusOK, want: "hello gzip"},
+		{name: "deflate", contentEncoding: "deflate", body: []byte("hello deflate"), statusCode: http.StatusOK, want: "hello deflate"},
+		{name: "other encoding", contentEncoding: "br", body: []byte("brotli"), statusCode: http.StatusOK, want: "brotli", wantEncoding: "br"},
+		{name: "invalid gzip", contentEncoding: "gzip", raw: []byte("not gzip"), statusCode: http.StatusBadRequest},
+		{name: "decompression bomb", contentEncoding: "gzip", body: make([]byte, 256<<10), statusCode: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeInvoker{}
+			body := tt.raw
+			if body == nil {
+				body = compress(t, tt.contentEncoding, tt.body)
+			}
+			if int64(len(body)) > maxBodySize {
+				t.Fatalf("compressed body of %v bytes is over the limit", len(body))
+			}
+			req := httptest.NewRequest(http.MethodPost, "/fn/", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "text/plain")
+			req.Header.Set("Content-Encoding", tt.contentEncoding)
+			req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+
+			w := serve(newFakeClients(fake), req)
+
+			if w.Code != tt.statusCode {
+				t.Fatalf("expected %v, got %v: %s", tt.statusCode, w.Code, w.Body)
+			}
+			if tt.statusCode != http.StatusOK {
+				if len(fake.invocations()) > 0 {
+					t.Error("expected the function not to be invoked")
+				}
+				return
+			}
+			event := fake.lastEvent(t)
+			if event.Body != tt.want {
+				t.Errorf("expected body %q, got %q", tt.want, event.Body)
+			}
+			if contentEncoding := event.Headers["Content-Encoding"]; contentEncoding != tt.wantEncoding {
+				t.Errorf("expected Content-Encoding %q, got %q", tt.wantEncoding, contentEncoding)
+			}
+			if contentLength := event.Headers["Content-Length"]; contentLength != strconv.Itoa(len(tt.want)) {
+				t.Errorf("expected Content-Length %v, got %v", len(tt.want), contentLength)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import "lambdahttpgw/config"
+
+// invokeSlots is a semaphore limiting the number of in-flight invocations.
+// It is nil if concurrency is unlimited.
+var invokeSlots = newInvokeSlots(config.GetMaxConcurrency())
+
+func newInvokeSlots(maxConcurrency int) chan struct{} {
+	if maxConcurrency <= 0 {
+		return nil
+	}
+	return make(chan struct{}, maxConcurrency)
+}
+
+// acquireInvokeSlot reserves a slot for an invocation, without blocking.
+// It returns false if the concurrency limit has been reached.
+func acquireInvokeSlot() bool {
+	return acquireSlot(invokeSlots)
+}
+
+func releaseInvokeSlot() {
+	releaseSlot(invokeSlots)
+}
+
+// acquireSlot reserves a slot in the semaphore, without blocking. It returns
+// false if all slots are in use. A nil semaphore has unlimited slots.
+func acquireSlot(slots chan struct{}) bool {
+	if slots == nil {
+		return true
+	}
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func releaseSlot(slots chan struct{}) {
+	if slots == nil {
+		return
+	}
+	<-slots
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// setMaxConcurrency limits in-flight invocations for the duration of the test.
+func setMaxConcurrency(t *testing.T, maxConcurrency int) {
+	previous := invokeSlots
+	invokeSlots = newInvokeSlots(maxConcurrency)
+	t.Cleanup(func() { invokeSlots = previous })
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	setMaxConcurrency(t, 1)
+	started, release := make(chan struct{}), make(chan struct{})
+	var blocked bool
+	fake := &fakeInvoker{respond: func(aws.Context, *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+		if !blocked {
+			blocked = true
+			close(started)
+			<-release
+		}
+		return proxyOutput(http.StatusOK, nil, ""), nil
+	}}
+	clients := newFakeClients(fake)
+
+	inFlight := make(chan int)
+	go func() {
+		inFlight <- serve(clients, httptest.NewRequest(http.MethodGet, "/fn/", nil)).Code
+	}()
+	<-started
+
+	w := serve(clients, httptest.NewRequest(http.MethodGet, "/fn/", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("expected 503 with Retry-After when saturated, got %v", w.Code)
+	}
+
+	close(release)
+	if statusCode := <-inFlight; statusCode != http.StatusOK {
+		t.Errorf("expected in-flight request to succeed, got %v", statusCode)
+	}
+	if w = serve(clients, httptest.NewRequest(http.MethodGet, "/fn/", nil)); w.Code != http.StatusOK {
+		t.Errorf("expected the slot to be released, got %v", w.Code)
+	}
+}
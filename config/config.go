@@ -1,56 +1,878 @@
 package config
 
 import (
+	"encoding/json"
 	"github.com/sirupsen/logrus"
-	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// CanaryRoute splits requests for a function between two versions, such as
+// aliases, sending the canary weight percentage of requests to the canary.
+type CanaryRoute struct {
+	Stable       string `json:"stable"`
+	Canary       string `json:"canary"`
+	CanaryWeight int    `json:"canaryWeight"`
+}
+
+// FunctionConfig holds settings for a function, overriding the global settings.
+type FunctionConfig struct {
+	Timeout Duration `json:"timeout"`
+}
+
+// Tenant holds the account and functions used for requests from a tenant.
+type Tenant struct {
+	Region         string `json:"region"`
+	RoleARN        string `json:"roleArn"`
+	ExternalID     string `json:"externalId"`
+	FunctionPrefix string `json:"functionPrefix"`
+}
+
+// Duration is a time.Duration that is unmarshalled from a string, such as '60s'.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	d.Duration = duration
+	return nil
+}
+
 var (
 	StatsUrl             = getStatsUrl()
 	StatsRecorderEnabled = isStatsRecorderEnabled()
 	StatsReporterEnabled = isStatsReporterEnabled()
+	MetricsEnabled       = isMetricsEnabled()
 )
 
+// GetAccessLogFormat returns the format of access log lines: "none",
+// "common" or "combined".
+func GetAccessLogFormat() string {
+	accessLogFormat := getEnv("ACCESS_LOG_FORMAT")
+	if accessLogFormat == "" {
+		accessLogFormat = "none"
+	}
+	return accessLogFormat
+}
+
+// GetAccessLogFile returns the file to which access log lines are appended,
+// or an empty string to write them to stdout.
+func GetAccessLogFile() string {
+	return getEnv("ACCESS_LOG_FILE")
+}
+
+// GetAllowedFunctions returns the patterns of function names that may be
+// invoked, such as 'orders-*'. Patterns use the syntax of path.Match.
+func GetAllowedFunctions() []string {
+	return splitList(getEnv("ALLOWED_FUNCTIONS"))
+}
+
+// GetDropHeaders returns the names of request headers that are not
+// forwarded to functions.
+func GetDropHeaders() []string {
+	return splitList(getEnv("DROP_HEADERS"))
+}
+
+// GetForwardHeaders returns the names of the only request headers forwarded
+// to functions, where empty means all headers are forwarded.
+func GetForwardHeaders() []string {
+	return splitList(getEnv("FORWARD_HEADERS"))
+}
+
+func GetApiKeys() []string {
+	return splitList(getEnv("API_KEYS"))
+}
+
+// GetBasicAuthUsers returns the bcrypt password hashes of users allowed to
+// authenticate with HTTP Basic auth, keyed by username.
+func GetBasicAuthUsers() map[string]string {
+	users := make(map[string]string)
+	for _, entry := range splitList(getEnv("BASIC_AUTH_USERS")) {
+		// invalid entries are reported by Validate
+		if username, hash, ok := splitBasicAuthUser(entry); ok {
+			users[username] = hash
+		}
+	}
+	return users
+}
+
+func splitBasicAuthUser(entry string) (username string, hash string, ok bool) {
+	separator := strings.Index(entry, ":")
+	if separator <= 0 || separator == len(entry)-1 {
+		return "", "", false
+	}
+	return entry[:separator], entry[separator+1:], true
+}
+
+// GetBasicAuthForward returns whether the Authorization header is forwarded
+// to functions once Basic auth credentials are validated.
+func GetBasicAuthForward() bool {
+	return getEnv("BASIC_AUTH_FORWARD") == "true"
+}
+
+func GetApiKeyHeader() string {
+	apiKeyHeader := getEnv("API_KEY_HEADER")
+	if apiKeyHeader == "" {
+		apiKeyHeader = "X-Api-Key"
+	}
+	return apiKeyHeader
+}
+
+// GetAssumeRoleARN returns the ARN of the role assumed to invoke functions,
+// such as for functions in another account.
+func GetAssumeRoleARN() string {
+	return getEnv("ASSUME_ROLE_ARN")
+}
+
+func GetAssumeRoleExternalID() string {
+	return getEnv("ASSUME_ROLE_EXTERNAL_ID")
+}
+
+func GetAssumeRoleSessionName() string {
+	sessionName := getEnv("ASSUME_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = "lambda-http-gateway"
+	}
+	return sessionName
+}
+
+// GetLambdaVPCEndpoint returns the interface VPC endpoint for the Lambda
+// service, if any.
+func GetLambdaVPCEndpoint() string {
+	return getEnv("LAMBDA_VPC_ENDPOINT")
+}
+
+// GetAWSEndpoint returns the custom endpoint for the Lambda service, if any.
+func GetAWSEndpoint() string {
+	return getEnv("AWS_ENDPOINT_URL")
+}
+
+// GetCanaryRoutes returns the canary configuration, keyed by function name.
+func GetCanaryRoutes() map[string]CanaryRoute {
+	canaryRoutes := make(map[string]CanaryRoute)
+	raw := getEnv("CANARY_ROUTES")
+	if raw == "" {
+		return canaryRoutes
+	}
+	if err := json.Unmarshal([]byte(raw), &canaryRoutes); err != nil {
+		logrus.Warnf("ignoring invalid CANARY_ROUTES: %v", err)
+		return map[string]CanaryRoute{}
+	}
+	return canaryRoutes
+}
+
+// GetAWSIdleConnTimeout returns how long idle connections to the Lambda
+// API are kept open.
+func GetAWSIdleConnTimeout() time.Duration {
+	return getDuration("AWS_IDLE_CONN_TIMEOUT", 120*time.Second)
+}
+
+// GetAWSMaxIdleConns returns the maximum number of idle connections to the
+// Lambda API, across all regions.
+func GetAWSMaxIdleConns() int {
+	maxIdleConns, err := strconv.Atoi(getEnv("AWS_MAX_IDLE_CONNS"))
+	if err != nil {
+		maxIdleConns = 200
+	}
+	return maxIdleConns
+}
+
+// GetAWSMaxIdleConnsPerHost returns the maximum number of idle connections
+// to the Lambda API in each region.
+func GetAWSMaxIdleConnsPerHost() int {
+	maxIdleConnsPerHost, err := strconv.Atoi(getEnv("AWS_MAX_IDLE_CONNS_PER_HOST"))
+	if err != nil {
+		maxIdleConnsPerHost = 100
+	}
+	return maxIdleConnsPerHost
+}
+
+// GetCircuitFailureThreshold returns the number of consecutive failed
+// invocations of a function after which requests fail fast, where 0
+// disables the circuit breaker.
+func GetCircuitFailureThreshold() int {
+	threshold, err := strconv.Atoi(getEnv("CIRCUIT_FAILURE_THRESHOLD"))
+	if err != nil {
+		threshold = 0
+	}
+	return threshold
+}
+
+func GetCircuitResetTimeout() time.Duration {
+	return getDuration("CIRCUIT_RESET_TIMEOUT", 30*time.Second)
+}
+
+func GetCompressionEnabled() bool {
+	return getEnv("COMPRESSION_ENABLED") == "true"
+}
+
+// GetCompressionMinSize returns the minimum response body size in bytes
+// for the response to be compressed.
+func GetCompressionMinSize() int {
+	minSize, err := strconv.Atoi(getEnv("COMPRESSION_MIN_SIZE"))
+	if err != nil {
+		minSize = 1024
+	}
+	return minSize
+}
+
+func GetCorsAllowOrigins() []string {
+	return splitList(getEnv("CORS_ALLOW_ORIGINS"))
+}
+
+func GetCorsAllowMethods() []string {
+	allowMethods := getEnv("CORS_ALLOW_METHODS")
+	if allowMethods == "" {
+		allowMethods = "GET,HEAD,POST,PUT,PATCH,DELETE"
+	}
+	return splitList(allowMethods)
+}
+
+func GetCorsAllowHeaders() []string {
+	allowHeaders := getEnv("CORS_ALLOW_HEADERS")
+	if allowHeaders == "" {
+		allowHeaders = "Authorization,Content-Type"
+	}
+	return splitList(allowHeaders)
+}
+
 func GetConfigLevel() logrus.Level {
-	level, err := logrus.ParseLevel(os.Getenv("LOG_LEVEL"))
+	level, err := logrus.ParseLevel(getEnv("LOG_LEVEL"))
 	if err != nil {
 		level = logrus.DebugLevel
 	}
 	return level
 }
 
+// GetLogFormat returns the log output format: "text" or "json".
+func GetLogFormat() string {
+	logFormat := getEnv("LOG_FORMAT")
+	if logFormat == "" {
+		logFormat = "text"
+	}
+	return logFormat
+}
+
+// GetMaxBodySize returns the maximum request body size in bytes,
+// defaulting to the Lambda synchronous invocation payload limit.
+func GetMaxBodySize() int64 {
+	maxBodySize, err := strconv.ParseInt(getEnv("MAX_BODY_SIZE"), 10, 64)
+	if err != nil {
+		maxBodySize = 6 * 1024 * 1024
+	}
+	return maxBodySize
+}
+
+// GetMaxConcurrency returns the maximum number of concurrent invocations,
+// where 0 means unlimited.
+func GetMaxConcurrency() int {
+	maxConcurrency, err := strconv.Atoi(getEnv("MAX_CONCURRENCY"))
+	if err != nil {
+		maxConcurrency = 0
+	}
+	return maxConcurrency
+}
+
+// GetMaxResponseSize returns the maximum size of a decoded function response
+// body, in bytes, where 0 means unlimited.
+func GetMaxResponseSize() int64 {
+	maxResponseSize, err := strconv.ParseInt(getEnv("MAX_RESPONSE_SIZE"), 10, 64)
+	if err != nil {
+		maxResponseSize = 0
+	}
+	return maxResponseSize
+}
+
+func GetMaxRetries() int {
+	maxRetries, err := strconv.Atoi(getEnv("MAX_RETRIES"))
+	if err != nil {
+		maxRetries = 2
+	}
+	return maxRetries
+}
+
+func isMetricsEnabled() bool {
+	return getEnv("METRICS_ENABLED") != "false"
+}
+
+func GetMetricsPath() string {
+	metricsPath := getEnv("METRICS_PATH")
+	if metricsPath == "" {
+		metricsPath = "/system/metrics"
+	}
+	return metricsPath
+}
+
 func GetPort() string {
-	port := os.Getenv("PORT")
+	port := getEnv("PORT")
 	if port == "" {
 		port = "8090"
 	}
 	return port
 }
 
+// GetRawBase64Response returns whether base64 encoded function response
+// bodies are returned to the client without decoding.
+func GetRawBase64Response() bool {
+	return getEnv("RAW_BASE64_RESPONSE") == "true"
+}
+
 func GetRegion() string {
-	region := os.Getenv("AWS_REGION")
+	region := getEnv("AWS_REGION")
 	if region == "" {
 		region = "eu-west-1"
 	}
 	return region
 }
 
+// GetDebugLogs returns whether to request the function log tail, which is
+// logged when the function returns an error.
+func GetDebugLogs() bool {
+	return getEnv("DEBUG_LOGS") == "true"
+}
+
+// GetDebugLogHeaderSize returns the maximum size of the function log tail
+// returned to the client when the function returns an error, where 0 means
+// the log tail is not returned.
+func GetDebugLogHeaderSize() int {
+	size, err := strconv.Atoi(getEnv("DEBUG_LOG_HEADER_SIZE"))
+	if err != nil {
+		size = 0
+	}
+	return size
+}
+
+// GetDebugTiming returns whether to add invocation timing headers to responses.
+func GetDebugTiming() bool {
+	return getEnv("DEBUG_TIMING") == "true"
+}
+
+// GetDefaultContentType returns the content type of function responses
+// that do not specify one.
+func GetDefaultContentType() string {
+	contentType, set := lookupEnv("DEFAULT_CONTENT_TYPE")
+	if !set {
+		contentType = "application/json"
+	}
+	return contentType
+}
+
+// GetDetectBase64Response returns whether function response bodies with a
+// binary content type, but not flagged as base64 encoded, are decoded if
+// they are valid base64.
+func GetDetectBase64Response() bool {
+	return getEnv("DETECT_BASE64_RESPONSE") == "true"
+}
+
+// GetDryRun returns whether to return the event that would be sent to
+// the function to the client, instead of invoking the function.
+func GetDryRun() bool {
+	return getEnv("DRY_RUN") == "true"
+}
+
+// GetEnableH2C returns whether to serve HTTP/2 over cleartext connections.
+func GetEnableH2C() bool {
+	return getEnv("ENABLE_H2C") == "true"
+}
+
+// GetErrorFormat returns the format of gateway error responses: "json"
+// or "plain".
+func GetErrorFormat() string {
+	errorFormat := getEnv("ERROR_FORMAT")
+	if errorFormat == "" {
+		errorFormat = "json"
+	}
+	return errorFormat
+}
+
+// GetExposeExecutedVersion returns whether the version of the function that
+// served the request is returned in a response header.
+func GetExposeExecutedVersion() bool {
+	return getEnv("EXPOSE_EXECUTED_VERSION") == "true"
+}
+
+// GetFailOnBadCreds returns whether the gateway exits at startup if the
+// AWS credentials are invalid, instead of only logging an error.
+func GetFailOnBadCreds() bool {
+	return getEnv("FAIL_ON_BAD_CREDS") == "true"
+}
+
+// GetFunctionConfigs returns the per-function settings, keyed by function
+// name. Invalid settings are reported by Validate.
+func GetFunctionConfigs() map[string]FunctionConfig {
+	functionConfigs := make(map[string]FunctionConfig)
+	raw := getEnv("FUNCTION_CONFIG")
+	if raw == "" {
+		return functionConfigs
+	}
+	if err := json.Unmarshal([]byte(raw), &functionConfigs); err != nil {
+		return map[string]FunctionConfig{}
+	}
+	return functionConfigs
+}
+
+// GetFunctionURLs returns the function URLs used when the invoke mode is
+// "functionurl", keyed by function name, optionally with a qualifier.
+func GetFunctionURLs() map[string]string {
+	functionURLs := make(map[string]string)
+	raw := getEnv("FUNCTION_URLS")
+	if raw == "" {
+		return functionURLs
+	}
+	if err := json.Unmarshal([]byte(raw), &functionURLs); err != nil {
+		logrus.Warnf("ignoring invalid FUNCTION_URLS: %v", err)
+		return map[string]string{}
+	}
+	return functionURLs
+}
+
+func GetHealthPath() string {
+	healthPath := getEnv("HEALTH_PATH")
+	if healthPath == "" {
+		healthPath = "/health"
+	}
+	return healthPath
+}
+
+// GetOtelEnabled returns whether to export OpenTelemetry traces.
+func GetOtelEnabled() bool {
+	return getEnv("OTEL_ENABLED") == "true"
+}
+
+// GetPassthroughResponse returns whether function output that is not a valid
+// proxy response should be returned to the client as-is.
+func GetPassthroughResponse() bool {
+	return getEnv("PASSTHROUGH_RESPONSE") == "true"
+}
+
+// GetPathPrefix returns the prefix stripped from request paths before
+// routing, such as when the gateway is mounted behind a reverse proxy.
+func GetPathPrefix() string {
+	pathPrefix := strings.Trim(getEnv("PATH_PREFIX"), "/")
+	if pathPrefix == "" {
+		return ""
+	}
+	return "/" + pathPrefix
+}
+
+// GetPayloadVersion returns the API Gateway payload format version
+// used for events sent to functions: "1.0" (REST API) or "2.0" (HTTP API).
+func GetPayloadVersion() string {
+	payloadVersion := getEnv("PAYLOAD_VERSION")
+	if payloadVersion == "" {
+		payloadVersion = "1.0"
+	}
+	return payloadVersion
+}
+
+// RouteTarget is the function a route prefix maps to, and the HTTP methods
+// the route accepts, where empty means all methods.
+type RouteTarget struct {
+	Function string   `json:"function"`
+	Methods  []string `json:"methods"`
+	// RequestTemplate is a Go template that reshapes the request body.
+	RequestTemplate string `json:"requestTemplate"`
+	// ResponseTemplate is a Go template that reshapes the response body.
+	ResponseTemplate string `json:"responseTemplate"`
+}
+
+// UnmarshalJSON accepts either a function name, or an object with the
+// function name and methods.
+func (t *RouteTarget) UnmarshalJSON(data []byte) error {
+	var functionName string
+	if err := json.Unmarshal(data, &functionName); err == nil {
+		*t = RouteTarget{Function: functionName}
+		return nil
+	}
+	type target RouteTarget
+	return json.Unmarshal(data, (*target)(t))
+}
+
+// RegexRoute maps request paths matching a regular expression to a function,
+// where the function name may refer to capture groups, such as '$1'.
+type RegexRoute struct {
+	Pattern  string `json:"pattern"`
+	Function string `json:"function"`
+}
+
+// GetRegexRoutes returns the regular expression routes, in the order they
+// are evaluated.
+func GetRegexRoutes() []RegexRoute {
+	var regexRoutes []RegexRoute
+	raw := getEnv("REGEX_ROUTES")
+	if raw == "" {
+		return regexRoutes
+	}
+	if err := json.Unmarshal([]byte(raw), &regexRoutes); err != nil {
+		logrus.Warnf("ignoring invalid REGEX_ROUTES: %v", err)
+		return nil
+	}
+	return regexRoutes
+}
+
+// GetRouteMap returns the mapping of path prefixes to functions.
+func GetRouteMap() map[string]RouteTarget {
+	routeMap := make(map[string]RouteTarget)
+	raw := getEnv("ROUTE_MAP")
+	if raw == "" {
+		return routeMap
+	}
+	if err := json.Unmarshal([]byte(raw), &routeMap); err != nil {
+		logrus.Warnf("ignoring invalid ROUTE_MAP: %v", err)
+		return map[string]RouteTarget{}
+	}
+	return routeMap
+}
+
+// GetStatusOverrideHeader returns the name of the function response header
+// whose value selects a status code override, where empty disables overrides.
+func GetStatusOverrideHeader() string {
+	return getEnv("STATUS_OVERRIDE_HEADER")
+}
+
+// GetStatusOverrides returns the status codes returned to the client, keyed
+// by the value of the status override header.
+func GetStatusOverrides() map[string]int {
+	statusOverrides := make(map[string]int)
+	raw := getEnv("STATUS_OVERRIDES")
+	if raw == "" {
+		return statusOverrides
+	}
+	if err := json.Unmarshal([]byte(raw), &statusOverrides); err != nil {
+		logrus.Warnf("ignoring invalid STATUS_OVERRIDES: %v", err)
+		return map[string]int{}
+	}
+	return statusOverrides
+}
+
+// GetStreamThreshold returns the size in bytes of a base64 encoded binary
+// response body, above which it is decoded as it is written to the client,
+// rather than being decoded in memory first, where 0 disables streaming.
+func GetStreamThreshold() int {
+	streamThreshold, err := strconv.Atoi(getEnv("STREAM_THRESHOLD"))
+	if err != nil {
+		streamThreshold = 1024 * 1024
+	}
+	return streamThreshold
+}
+
+// GetStripResponseHeaders returns the names of function response headers
+// that are not returned to the client, in addition to hop-by-hop headers.
+func GetStripResponseHeaders() []string {
+	return splitList(getEnv("STRIP_RESPONSE_HEADERS"))
+}
+
+// GetTextMimeTypes returns the content types of request bodies sent to
+// functions as plain strings, rather than base64 encoded. Entries may use
+// a wildcard subtype, such as 'text/*'.
+func GetTextMimeTypes() []string {
+	textMimeTypes := getEnv("TEXT_MIME_TYPES")
+	if textMimeTypes == "" {
+		textMimeTypes = "application/json,application/x-www-form-urlencoded,text/*"
+	}
+	return splitList(strings.ToLower(textMimeTypes))
+}
+
+// GetTrailingSlash returns how trailing slashes in the path forwarded to
+// functions are normalised: "keep", "strip" or "add".
+func GetTrailingSlash() string {
+	trailingSlash := getEnv("TRAILING_SLASH")
+	if trailingSlash == "" {
+		trailingSlash = "keep"
+	}
+	return trailingSlash
+}
+
+func GetTenantHeader() string {
+	tenantHeader := getEnv("TENANT_HEADER")
+	if tenantHeader == "" {
+		tenantHeader = "X-Tenant"
+	}
+	return tenantHeader
+}
+
+// GetTenants returns the tenant configuration, keyed by tenant name.
+func GetTenants() map[string]Tenant {
+	tenants := make(map[string]Tenant)
+	raw := getEnv("TENANTS")
+	if raw == "" {
+		return tenants
+	}
+	if err := json.Unmarshal([]byte(raw), &tenants); err != nil {
+		logrus.Warnf("ignoring invalid TENANTS: %v", err)
+		return map[string]Tenant{}
+	}
+	return tenants
+}
+
+func GetTLSCertFile() string {
+	return getEnv("TLS_CERT_FILE")
+}
+
+func GetTLSKeyFile() string {
+	return getEnv("TLS_KEY_FILE")
+}
+
+// GetTrustProxy returns whether the gateway is behind a trusted proxy, so
+// X-Forwarded-For can be used to determine the client IP address.
+func GetTrustProxy() bool {
+	return getEnv("TRUST_PROXY") == "true"
+}
+
+// GetTrustedProxyHops returns the number of trusted proxies in front of the
+// gateway, each of which appends an entry to X-Forwarded-For.
+func GetTrustedProxyHops() int {
+	hops, err := strconv.Atoi(getEnv("TRUSTED_PROXY_HOPS"))
+	if err != nil || hops < 1 {
+		hops = 1
+	}
+	return hops
+}
+
+// GetRequestIdFormat returns the format of generated request IDs: "uuid",
+// "ulid" or "short".
+func GetRequestIdFormat() string {
+	requestIdFormat := getEnv("REQUEST_ID_FORMAT")
+	if requestIdFormat == "" {
+		requestIdFormat = "uuid"
+	}
+	return requestIdFormat
+}
+
 func GetRequestIdHeader() string {
-	return os.Getenv("REQUEST_ID_HEADER")
+	requestIdHeader := getEnv("REQUEST_ID_HEADER")
+	if requestIdHeader == "" {
+		requestIdHeader = "X-Request-Id"
+	}
+	return requestIdHeader
+}
+
+// GetResponseCacheSize returns the maximum number of GET responses cached,
+// where 0 disables response caching.
+func GetResponseCacheSize() int {
+	size, err := strconv.Atoi(getEnv("RESPONSE_CACHE_SIZE"))
+	if err != nil {
+		size = 0
+	}
+	return size
+}
+
+// GetResponseCacheVaryHeaders returns the names of request headers that are
+// included in the response cache key, as responses may differ by their values.
+func GetResponseCacheVaryHeaders() []string {
+	varyHeaders, found := lookupEnv("RESPONSE_CACHE_VARY_HEADERS")
+	if !found {
+		varyHeaders = "Accept"
+	}
+	return splitList(varyHeaders)
+}
+
+// GetHostRouting returns whether to route requests to functions based on the
+// subdomain of the request host, instead of the first path segment.
+func GetHostRouting() bool {
+	return getEnv("HOST_ROUTING") == "true"
+}
+
+// GetHostRoutingDomain returns the base domain stripped from the request
+// host before determining the function name, when host routing is enabled.
+func GetHostRoutingDomain() string {
+	return getEnv("HOST_ROUTING_DOMAIN")
+}
+
+// GetInvokeMode returns how functions are invoked: "sdk", using the Invoke
+// API, or "functionurl", using signed requests to function URLs.
+func GetInvokeMode() string {
+	invokeMode := getEnv("INVOKE_MODE")
+	if invokeMode == "" {
+		invokeMode = "sdk"
+	}
+	return invokeMode
+}
+
+func GetInvokeTimeout() time.Duration {
+	return getDuration("INVOKE_TIMEOUT", 30*time.Second)
+}
+
+// GetLongPollRoutes returns the request path patterns of long poll routes,
+// which use the long poll timeout. Patterns use the syntax of path.Match,
+// such as '/events/*'.
+func GetLongPollRoutes() []string {
+	return splitList(getEnv("LONGPOLL_ROUTES"))
+}
+
+// GetLongPollTimeout returns the invocation timeout for long poll routes,
+// which defaults to the maximum Lambda function timeout.
+func GetLongPollTimeout() time.Duration {
+	return getDuration("LONGPOLL_TIMEOUT", 15*time.Minute)
+}
+
+// GetMaxInvokeTimeout returns the maximum invocation timeout that can be
+// requested with the X-Timeout-Ms header, defaulting to the longest of the
+// invocation timeout, the long poll timeout and any per-function timeouts.
+func GetMaxInvokeTimeout() time.Duration {
+	maxTimeout := GetInvokeTimeout()
+	if len(GetLongPollRoutes()) > 0 && GetLongPollTimeout() > maxTimeout {
+		maxTimeout = GetLongPollTimeout()
+	}
+	for _, functionConfig := range GetFunctionConfigs() {
+		if functionConfig.Timeout.Duration > maxTimeout {
+			maxTimeout = functionConfig.Timeout.Duration
+		}
+	}
+	return getDuration("MAX_INVOKE_TIMEOUT", maxTimeout)
+}
+
+// GetSetForwardedHeaders returns whether to set the X-Forwarded-For,
+// X-Forwarded-Proto and X-Forwarded-Port headers sent to functions.
+func GetSetForwardedHeaders() bool {
+	return getEnv("SET_FORWARDED_HEADERS") == "true"
+}
+
+// GetShadowFunction returns the name or ARN of the function to which a copy
+// of each request is sent asynchronously, with its response discarded.
+func GetShadowFunction() string {
+	return getEnv("SHADOW_FUNCTION")
+}
+
+// GetShadowMaxConcurrency returns the maximum number of concurrent
+// invocations of the shadow function, where 0 means unlimited.
+func GetShadowMaxConcurrency() int {
+	maxConcurrency, err := strconv.Atoi(getEnv("SHADOW_MAX_CONCURRENCY"))
+	if err != nil {
+		maxConcurrency = 100
+	}
+	return maxConcurrency
+}
+
+// GetShadowTimeout returns the maximum duration to wait for the shadow
+// function invocation to be queued.
+func GetShadowTimeout() time.Duration {
+	return getDuration("SHADOW_TIMEOUT", 10*time.Second)
+}
+
+func GetShutdownTimeout() time.Duration {
+	return getDuration("SHUTDOWN_TIMEOUT", 15*time.Second)
+}
+
+// GetRateLimit returns the number of requests per second allowed from each
+// client, where 0 disables rate limiting.
+func GetRateLimit() float64 {
+	rateLimit, err := strconv.ParseFloat(getEnv("RATE_LIMIT"), 64)
+	if err != nil {
+		rateLimit = 0
+	}
+	return rateLimit
+}
+
+// GetRateBurst returns the number of requests a client can make in a burst,
+// above the rate limit. If 0, this defaults to the rate limit.
+func GetRateBurst() int {
+	burst, err := strconv.Atoi(getEnv("RATE_BURST"))
+	if err != nil {
+		burst = 0
+	}
+	return burst
+}
+
+// GetRateLimitKey returns how clients are identified for rate limiting:
+// "ip", or "apikey" to use the API key of authorised requests.
+func GetRateLimitKey() string {
+	rateLimitKey := getEnv("RATE_LIMIT_KEY")
+	if rateLimitKey == "" {
+		rateLimitKey = "ip"
+	}
+	return rateLimitKey
+}
+
+// GetReadTimeout returns the maximum duration for reading an entire
+// request, including the body.
+func GetReadTimeout() time.Duration {
+	return getDuration("READ_TIMEOUT", 60*time.Second)
+}
+
+// GetReadHeaderTimeout returns the maximum duration for reading request
+// headers, which protects against slow clients holding connections open.
+func GetReadHeaderTimeout() time.Duration {
+	return getDuration("READ_HEADER_TIMEOUT", 10*time.Second)
+}
+
+// GetVersionPath returns the path of the build information endpoint.
+func GetVersionPath() string {
+	versionPath := getEnv("VERSION_PATH")
+	if versionPath == "" {
+		versionPath = "/system/version"
+	}
+	return versionPath
+}
+
+// GetWarmupFunctions returns the names or ARNs of functions periodically
+// invoked with a warmup event.
+func GetWarmupFunctions() []string {
+	return splitList(getEnv("WARMUP_FUNCTIONS"))
+}
+
+func GetWarmupInterval() time.Duration {
+	return getDuration("WARMUP_INTERVAL", 5*time.Minute)
+}
+
+// GetWriteTimeout returns the maximum duration before timing out writes of
+// the response. This defaults to allow for the maximum invocation timeout, as
+// the response is not written until the invocation completes.
+func GetWriteTimeout() time.Duration {
+	return getDuration("WRITE_TIMEOUT", GetMaxInvokeTimeout()+10*time.Second)
+}
+
+// GetIdempotencyCacheSize returns the maximum number of responses cached by
+// idempotency key, where 0 disables idempotency keys.
+func GetIdempotencyCacheSize() int {
+	size, err := strconv.Atoi(getEnv("IDEMPOTENCY_CACHE_SIZE"))
+	if err != nil {
+		size = 0
+	}
+	return size
+}
+
+// GetIdempotencyTTL returns how long a response is returned for repeated
+// requests with the same idempotency key.
+func GetIdempotencyTTL() time.Duration {
+	return getDuration("IDEMPOTENCY_TTL", time.Hour)
+}
+
+// GetIdleTimeout returns the maximum duration to wait for the next request
+// on a keep-alive connection.
+func GetIdleTimeout() time.Duration {
+	return getDuration("IDLE_TIMEOUT", 120*time.Second)
 }
 
 func isStatsRecorderEnabled() bool {
-	return os.Getenv("STATS_RECORDER") == "true" || isStatsReporterEnabled()
+	return getEnv("STATS_RECORDER") == "true" || isStatsReporterEnabled()
 }
 
 func getStatsUrl() string {
-	return os.Getenv("STATS_REPORT_URL")
+	return getEnv("STATS_REPORT_URL")
+}
+
+// GetStage returns the stage name reported to functions in the request context.
+func GetStage() string {
+	stage := getEnv("STAGE")
+	if stage == "" {
+		stage = "$default"
+	}
+	return stage
 }
 
 func GetStatsInterval() time.Duration {
 	var seconds time.Duration
-	interval := os.Getenv("STATS_REPORT_INTERVAL")
+	interval := getEnv("STATS_REPORT_INTERVAL")
 	if interval == "" {
 		seconds = 5 * time.Second
 	} else {
@@ -63,3 +885,25 @@ func isStatsReporterEnabled() bool {
 	// note: don't use the cached var
 	return getStatsUrl() != ""
 }
+
+// getDuration parses a duration from the environment variable,
+// returning the default value if it is not set.
+func getDuration(envVar string, defaultValue time.Duration) time.Duration {
+	value := getEnv(envVar)
+	if value == "" {
+		return defaultValue
+	}
+	duration, _ := time.ParseDuration(value)
+	return duration
+}
+
+// splitList splits a comma-separated value, ignoring empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
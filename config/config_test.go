@@ -0,0 +1,29 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaxInvokeTimeout(t *testing.T) {
+	tests := []struct {
+		name           string
+		functionConfig string
+		maxTimeout     string
+		want           time.Duration
+	}{
+		{name: "invoke timeout", want: 30 * time.Second},
+		{name: "longest per-function timeout", functionConfig: `{"slow-fn":{"timeout":"2m"},"fast-fn":{"timeout":"5s"}}`, want: 2 * time.Minute},
+		{name: "configured", functionConfig: `{"slow-fn":{"timeout":"2m"}}`, maxTimeout: "5m", want: 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("INVOKE_TIMEOUT", "30s")
+			t.Setenv("FUNCTION_CONFIG", tt.functionConfig)
+			t.Setenv("MAX_INVOKE_TIMEOUT", tt.maxTimeout)
+			if timeout := GetMaxInvokeTimeout(); timeout != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, timeout)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	// fileValues holds the settings from the configuration file, if any,
+	// keyed by environment variable name.
+	fileValues = loadConfigFile(os.Getenv("CONFIG_FILE"))
+	fileLock   sync.RWMutex
+)
+
+// loadConfigFile reads the settings from the configuration file at startup,
+// exiting if it cannot be read.
+func loadConfigFile(path string) map[string]string {
+	values, err := readConfigFile(path)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	return values
+}
+
+// readConfigFile reads settings from a YAML or JSON file, where each key is
+// the name of an environment variable. Nested values, such as route maps,
+// are converted to JSON, as they would be set in the environment.
+func readConfigFile(path string) (map[string]string, error) {
+	values := make(map[string]string)
+	if path == "" {
+		return values, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file %v: %v", path, err)
+	}
+	// JSON is a subset of YAML, so both formats are parsed the same way
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("error parsing config file %v: %v", path, err)
+	}
+	for key, value := range raw {
+		stringValue, err := toSettingValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %v in config file %v: %v", key, path, err)
+		}
+		values[strings.ToUpper(key)] = stringValue
+	}
+	return values, nil
+}
+
+// ReloadConfigFile reads the configuration file again, so settings that
+// support reloading, such as canary routes, can be changed without a restart.
+// If the new settings are invalid, the previous settings are retained.
+func ReloadConfigFile() error {
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		return fmt.Errorf("CONFIG_FILE is not set")
+	}
+	values, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	fileLock.Lock()
+	previous := fileValues
+	fileValues = values
+	fileLock.Unlock()
+
+	if err := Validate(); err != nil {
+		fileLock.Lock()
+		fileValues = previous
+		fileLock.Unlock()
+		return err
+	}
+	return nil
+}
+
+// toSettingValue converts a value from the config file to the form used
+// for the equivalent environment variable.
+func toSettingValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case map[interface{}]interface{}, []interface{}:
+		encoded, err := json.Marshal(toJSONValue(v))
+		if err != nil {
+			return "", err
+		}
+		return string(encoded), nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// toJSONValue converts YAML maps, which may have non-string keys, to maps
+// that can be encoded as JSON.
+func toJSONValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			converted[fmt.Sprint(key)] = toJSONValue(item)
+		}
+		return converted
+	case []interface{}:
+		for i, item := range v {
+			v[i] = toJSONValue(item)
+		}
+		return v
+	default:
+		return value
+	}
+}
+
+// lookupEnv returns the value of the setting from the environment, or
+// otherwise from the config file. Environment variables take precedence.
+func lookupEnv(name string) (string, bool) {
+	if value, set := os.LookupEnv(name); set {
+		return value, true
+	}
+	fileLock.RLock()
+	defer fileLock.RUnlock()
+	value, set := fileValues[name]
+	return value, set
+}
+
+// getEnv returns the value of the setting, or an empty string if it is not set.
+func getEnv(name string) string {
+	value, _ := lookupEnv(name)
+	return value
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// useConfigFile writes the config file and loads its settings for the
+// duration of the test, returning the path of the file.
+func useConfigFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	values, err := readConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := fileValues
+	fileValues = values
+	t.Cleanup(func() { fileValues = previous })
+	t.Setenv("CONFIG_FILE", path)
+	return path
+}
+
+const yamlConfig = `
+port: 9000
+AWS_REGION: us-east-1
+INVOKE_TIMEOUT: 5s
+ROUTE_MAP:
+  /users: user-fn
+  /orders:
+    function: order-fn
+    methods: [GET]
+`
+
+const jsonConfig = `{
+  "PORT": "9000",
+  "AWS_REGION": "us-east-1",
+  "INVOKE_TIMEOUT": "5s",
+  "ROUTE_MAP": {"/users": "user-fn", "/orders": {"function": "order-fn", "methods": ["GET"]}}
+}`
+
+func TestConfigFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{name: "yaml", file: "config.yaml", content: yamlConfig},
+		{name: "json", file: "config.json", content: jsonConfig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfigFile(t, tt.file, tt.content)
+
+			if port := GetPort(); port != "9000" {
+				t.Errorf("expected port from file, got %v", port)
+			}
+			if region := GetRegion(); region != "us-east-1" {
+				t.Errorf("expected region from file, got %v", region)
+			}
+			if timeout := GetInvokeTimeout(); timeout != 5*time.Second {
+				t.Errorf("expected invoke timeout from file, got %v", timeout)
+			}
+			expected := map[string]RouteTarget{
+				"/users":  {Function: "user-fn"},
+				"/orders": {Function: "order-fn", Methods: []string{"GET"}},
+			}
+			if routeMap := GetRouteMap(); !reflect.DeepEqual(routeMap, expected) {
+				t.Errorf("expected route map from file %v, got %v", expected, routeMap)
+			}
+		})
+	}
+}
+
+func TestEnvOverridesConfigFile(t *testing.T) {
+	useConfigFile(t, "config.yaml", yamlConfig)
+	t.Setenv("PORT", "9100")
+	t.Setenv("AWS_REGION", "")
+
+	if port := GetPort(); port != "9100" {
+		t.Errorf("expected port from the environment, got %v", port)
+	}
+	// set but empty in the environment, so the default is used rather than the file value
+	if region := GetRegion(); region != "eu-west-1" {
+		t.Errorf("expected the default region, got %v", region)
+	}
+	if timeout := GetInvokeTimeout(); timeout != 5*time.Second {
+		t.Errorf("expected invoke timeout from file, got %v", timeout)
+	}
+}
+
+func TestNoConfigFile(t *testing.T) {
+	values, err := readConfigFile("")
+	if err != nil || len(values) != 0 {
+		t.Errorf("expected no settings without a config file, got %v: %v", values, err)
+	}
+	if _, err := readConfigFile(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("expected an error for a missing config file")
+	}
+}
+
+func TestReloadConfigFile(t *testing.T) {
+	path := useConfigFile(t, "config.yaml", "PORT: 9000\n")
+
+	if err := os.WriteFile(path, []byte("PORT: 9200\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReloadConfigFile(); err != nil {
+		t.Fatal(err)
+	}
+	if port := GetPort(); port != "9200" {
+		t.Errorf("expected the reloaded port, got %v", port)
+	}
+
+	if err := os.WriteFile(path, []byte("PORT: 70000\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReloadConfigFile(); err == nil {
+		t.Error("expected invalid settings to be rejected")
+	}
+	if port := GetPort(); port != "9200" {
+		t.Errorf("expected the previous settings to be retained, got %v", port)
+	}
+}
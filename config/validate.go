@@ -0,0 +1,255 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationSettings = []string{
+	"AWS_IDLE_CONN_TIMEOUT",
+	"CIRCUIT_RESET_TIMEOUT",
+	"IDEMPOTENCY_TTL",
+	"IDLE_TIMEOUT",
+	"INVOKE_TIMEOUT",
+	"LONGPOLL_TIMEOUT",
+	"MAX_INVOKE_TIMEOUT",
+	"READ_HEADER_TIMEOUT",
+	"READ_TIMEOUT",
+	"SHADOW_TIMEOUT",
+	"SHUTDOWN_TIMEOUT",
+	"STATS_REPORT_INTERVAL",
+	"WARMUP_INTERVAL",
+	"WRITE_TIMEOUT",
+}
+
+// positiveDurationSettings must also be greater than zero, as they are
+// used for tickers and deadlines
+var positiveDurationSettings = []string{
+	"INVOKE_TIMEOUT",
+	"SHADOW_TIMEOUT",
+	"STATS_REPORT_INTERVAL",
+	"WARMUP_INTERVAL",
+}
+
+// patternSettings are lists of path.Match patterns
+var patternSettings = []string{
+	"ALLOWED_FUNCTIONS",
+	"LONGPOLL_ROUTES",
+}
+
+// integerSettings must be zero or more
+var integerSettings = []string{
+	"AWS_MAX_IDLE_CONNS",
+	"AWS_MAX_IDLE_CONNS_PER_HOST",
+	"CIRCUIT_FAILURE_THRESHOLD",
+	"COMPRESSION_MIN_SIZE",
+	"DEBUG_LOG_HEADER_SIZE",
+	"IDEMPOTENCY_CACHE_SIZE",
+	"MAX_BODY_SIZE",
+	"MAX_CONCURRENCY",
+	"MAX_RESPONSE_SIZE",
+	"MAX_RETRIES",
+	"RATE_BURST",
+	"RESPONSE_CACHE_SIZE",
+	"SHADOW_MAX_CONCURRENCY",
+	"STREAM_THRESHOLD",
+}
+
+var booleanSettings = []string{
+	"BASIC_AUTH_FORWARD",
+	"COMPRESSION_ENABLED",
+	"DEBUG_LOGS",
+	"DEBUG_TIMING",
+	"DETECT_BASE64_RESPONSE",
+	"DRY_RUN",
+	"ENABLE_H2C",
+	"EXPOSE_EXECUTED_VERSION",
+	"FAIL_ON_BAD_CREDS",
+	"HOST_ROUTING",
+	"METRICS_ENABLED",
+	"OTEL_ENABLED",
+	"PASSTHROUGH_RESPONSE",
+	"RAW_BASE64_RESPONSE",
+	"SET_FORWARDED_HEADERS",
+	"STATS_RECORDER",
+	"TRUST_PROXY",
+}
+
+var enumSettings = map[string][]string{
+	"ACCESS_LOG_FORMAT": {"none", "common", "combined"},
+	"ERROR_FORMAT":      {"json", "plain"},
+	"INVOKE_MODE":       {"sdk", "functionurl"},
+	"LOG_FORMAT":        {"text", "json"},
+	"PAYLOAD_VERSION":   {"1.0", "2.0"},
+	"RATE_LIMIT_KEY":    {"ip", "apikey"},
+	"REQUEST_ID_FORMAT": {"uuid", "ulid", "short"},
+	"TRAILING_SLASH":    {"keep", "strip", "add"},
+}
+
+// jsonSettings returns a value of the type each JSON setting is unmarshalled into.
+var jsonSettings = map[string]func() interface{}{
+	"CANARY_ROUTES":    func() interface{} { return &map[string]CanaryRoute{} },
+	"FUNCTION_CONFIG":  func() interface{} { return &map[string]FunctionConfig{} },
+	"FUNCTION_URLS":    func() interface{} { return &map[string]string{} },
+	"REGEX_ROUTES":     func() interface{} { return &[]RegexRoute{} },
+	"ROUTE_MAP":        func() interface{} { return &map[string]RouteTarget{} },
+	"STATUS_OVERRIDES": func() interface{} { return &map[string]int{} },
+	"TENANTS":          func() interface{} { return &map[string]Tenant{} },
+}
+
+// Validate checks the format of each setting that is set, so invalid values
+// can be reported at startup, rather than replaced with defaults. It returns
+// an error describing every invalid setting.
+func Validate() error {
+	var problems []string
+	for _, name := range durationSettings {
+		if value := getEnv(name); value != "" {
+			if duration, err := time.ParseDuration(value); err != nil || duration < 0 {
+				problems = append(problems, fmt.Sprintf("%v must be a duration, such as '30s': %q", name, value))
+			} else if duration == 0 && contains(positiveDurationSettings, name) {
+				problems = append(problems, fmt.Sprintf("%v must be a duration greater than zero, such as '30s': %q", name, value))
+			}
+		}
+	}
+	for _, name := range integerSettings {
+		if value := getEnv(name); value != "" {
+			if number, err := strconv.ParseInt(value, 10, 64); err != nil || number < 0 {
+				problems = append(problems, fmt.Sprintf("%v must be a whole number of zero or more: %q", name, value))
+			}
+		}
+	}
+	if value := getEnv("RATE_LIMIT"); value != "" {
+		if rate, err := strconv.ParseFloat(value, 64); err != nil || rate < 0 {
+			problems = append(problems, fmt.Sprintf("RATE_LIMIT must be a number of zero or more: %q", value))
+		}
+	}
+	if getEnv("RATE_LIMIT_KEY") == "apikey" && len(GetApiKeys()) == 0 {
+		// otherwise clients could send a new key with each request to avoid the limit
+		problems = append(problems, "RATE_LIMIT_KEY 'apikey' requires API_KEYS to be set")
+	}
+	if value := getEnv("TRUSTED_PROXY_HOPS"); value != "" {
+		if hops, err := strconv.Atoi(value); err != nil || hops < 1 {
+			problems = append(problems, fmt.Sprintf("TRUSTED_PROXY_HOPS must be a whole number of one or more: %q", value))
+		}
+	}
+	if value := getEnv("PORT"); value != "" {
+		if port, err := strconv.Atoi(value); err != nil || port < 1 || port > 65535 {
+			problems = append(problems, fmt.Sprintf("PORT must be a number between 1 and 65535: %q", value))
+		}
+	}
+	for _, name := range booleanSettings {
+		if value := getEnv(name); value != "" && value != "true" && value != "false" {
+			problems = append(problems, fmt.Sprintf("%v must be 'true' or 'false': %q", name, value))
+		}
+	}
+	for name, allowed := range enumSettings {
+		if value := getEnv(name); value != "" && !contains(allowed, value) {
+			problems = append(problems, fmt.Sprintf("%v must be one of %v: %q", name, strings.Join(allowed, ", "), value))
+		}
+	}
+	if value := getEnv("LOG_LEVEL"); value != "" {
+		if _, err := logrus.ParseLevel(value); err != nil {
+			problems = append(problems, fmt.Sprintf("LOG_LEVEL must be one of trace, debug, info, warn, error: %q", value))
+		}
+	}
+	for name, newValue := range jsonSettings {
+		if value := getEnv(name); value != "" {
+			if err := json.Unmarshal([]byte(value), newValue()); err != nil {
+				problems = append(problems, fmt.Sprintf("%v must be valid JSON: %v", name, err))
+			}
+		}
+	}
+
+	for _, name := range patternSettings {
+		for _, pattern := range splitList(getEnv(name)) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				problems = append(problems, fmt.Sprintf("%v pattern is invalid: %q", name, pattern))
+			}
+		}
+	}
+	problems = append(problems, validateBasicAuthUsers()...)
+	problems = append(problems, validateRegexRoutes()...)
+	problems = append(problems, validateCanaryRoutes()...)
+	if value := getEnv("LAMBDA_VPC_ENDPOINT"); value != "" {
+		if parsed, err := url.Parse(value); err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+			problems = append(problems, fmt.Sprintf("LAMBDA_VPC_ENDPOINT must be an https URL, such as 'https://vpce-0123-abcd.lambda.eu-west-1.vpce.amazonaws.com': %q", value))
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	// sorted for a consistent order, as map iteration order is random
+	sort.Strings(problems)
+	return fmt.Errorf("invalid configuration: %v", strings.Join(problems, "; "))
+}
+
+// validateBasicAuthUsers checks each entry is a username and bcrypt hash, as
+// ignoring an entry could leave requests unauthenticated.
+func validateBasicAuthUsers() []string {
+	var problems []string
+	for _, entry := range splitList(getEnv("BASIC_AUTH_USERS")) {
+		username, hash, ok := splitBasicAuthUser(entry)
+		if !ok {
+			// the entry is not included, as it may contain a password
+			problems = append(problems, "BASIC_AUTH_USERS entries must be 'user:bcrypt-hash'")
+			continue
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			problems = append(problems, fmt.Sprintf("BASIC_AUTH_USERS hash for user %v is not a valid bcrypt hash: %v", username, err))
+		}
+	}
+	return problems
+}
+
+func validateRegexRoutes() []string {
+	var regexRoutes []RegexRoute
+	if err := json.Unmarshal([]byte(getEnv("REGEX_ROUTES")), &regexRoutes); err != nil {
+		// reported as invalid JSON, if set
+		return nil
+	}
+	var problems []string
+	for _, regexRoute := range regexRoutes {
+		if _, err := regexp.Compile(regexRoute.Pattern); err != nil {
+			problems = append(problems, fmt.Sprintf("REGEX_ROUTES pattern is invalid: %v", err))
+		}
+	}
+	return problems
+}
+
+// validateCanaryRoutes checks each canary weight is a percentage, with a
+// canary version to send the weighted requests to.
+func validateCanaryRoutes() []string {
+	var canaryRoutes map[string]CanaryRoute
+	if err := json.Unmarshal([]byte(getEnv("CANARY_ROUTES")), &canaryRoutes); err != nil {
+		// reported as invalid JSON, if set
+		return nil
+	}
+	var problems []string
+	for functionName, canaryRoute := range canaryRoutes {
+		if canaryRoute.CanaryWeight < 0 || canaryRoute.CanaryWeight > 100 {
+			problems = append(problems, fmt.Sprintf("CANARY_ROUTES weight for %v must be between 0 and 100: %d", functionName, canaryRoute.CanaryWeight))
+		} else if canaryRoute.CanaryWeight > 0 && canaryRoute.Canary == "" {
+			problems = append(problems, fmt.Sprintf("CANARY_ROUTES weight for %v requires a canary version", functionName))
+		}
+	}
+	return problems
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
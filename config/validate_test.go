@@ -0,0 +1,94 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		problems []string
+	}{
+		{name: "defaults"},
+		{
+			name: "valid settings",
+			env: map[string]string{
+				"INVOKE_TIMEOUT":      "10s",
+				"MAX_CONCURRENCY":     "0",
+				"DRY_RUN":             "true",
+				"PAYLOAD_VERSION":     "2.0",
+				"ALLOWED_FUNCTIONS":   "orders-*,users",
+				"BASIC_AUTH_USERS":    "alice:$2a$04$ZUWhLNnJfKq1eYTfhEhuLePqZFQ7jQykSPl8vWk00Z0y5G3AryuRa",
+				"LAMBDA_VPC_ENDPOINT": "https://vpce-0123.lambda.eu-west-1.vpce.amazonaws.com",
+				"REGEX_ROUTES":        `[{"pattern":"^/v(\\d+)/","function":"api-v$1"}]`,
+				"RATE_LIMIT_KEY":      "apikey",
+				"API_KEYS":            "key1",
+			},
+		},
+		{name: "duration without unit", env: map[string]string{"READ_TIMEOUT": "30"}, problems: []string{"READ_TIMEOUT must be a duration"}},
+		{name: "negative duration", env: map[string]string{"IDLE_TIMEOUT": "-1s"}, problems: []string{"IDLE_TIMEOUT must be a duration"}},
+		{name: "zero invoke timeout", env: map[string]string{"INVOKE_TIMEOUT": "0s"}, problems: []string{"INVOKE_TIMEOUT must be a duration greater than zero"}},
+		{name: "zero stats interval", env: map[string]string{"STATS_REPORT_INTERVAL": "0"}, problems: []string{"STATS_REPORT_INTERVAL must be a duration greater than zero"}},
+		{name: "zero warmup interval", env: map[string]string{"WARMUP_INTERVAL": "0m"}, problems: []string{"WARMUP_INTERVAL must be a duration greater than zero"}},
+		{name: "zero allowed for other durations", env: map[string]string{"SHUTDOWN_TIMEOUT": "0s"}},
+		{name: "negative integer", env: map[string]string{"MAX_BODY_SIZE": "-1"}, problems: []string{"MAX_BODY_SIZE must be a whole number"}},
+		{name: "invalid port", env: map[string]string{"PORT": "70000"}, problems: []string{"PORT must be a number between 1 and 65535"}},
+		{name: "invalid boolean", env: map[string]string{"DRY_RUN": "yes"}, problems: []string{"DRY_RUN must be 'true' or 'false'"}},
+		{name: "invalid enum", env: map[string]string{"TRAILING_SLASH": "remove"}, problems: []string{"TRAILING_SLASH must be one of keep, strip, add"}},
+		{name: "invalid log level", env: map[string]string{"LOG_LEVEL": "verbose"}, problems: []string{"LOG_LEVEL must be one of"}},
+		{name: "invalid JSON", env: map[string]string{"FUNCTION_CONFIG": `{"fn":{"timeout":"10"}}`}, problems: []string{"FUNCTION_CONFIG must be valid JSON"}},
+		{name: "invalid allowed function pattern", env: map[string]string{"ALLOWED_FUNCTIONS": "orders-[*"}, problems: []string{"ALLOWED_FUNCTIONS pattern is invalid"}},
+		{name: "invalid long poll route", env: map[string]string{"LONGPOLL_ROUTES": "/events/[a-"}, problems: []string{"LONGPOLL_ROUTES pattern is invalid"}},
+		{name: "invalid regex route", env: map[string]string{"REGEX_ROUTES": `[{"pattern":"^/(v","function":"fn"}]`}, problems: []string{"REGEX_ROUTES pattern is invalid"}},
+		{name: "canary weight over 100", env: map[string]string{"CANARY_ROUTES": `{"fn":{"stable":"fn:1","canary":"fn:2","canaryWeight":150}}`}, problems: []string{"CANARY_ROUTES weight for fn must be between 0 and 100"}},
+		{name: "negative canary weight", env: map[string]string{"CANARY_ROUTES": `{"fn":{"stable":"fn:1","canary":"fn:2","canaryWeight":-1}}`}, problems: []string{"CANARY_ROUTES weight for fn must be between 0 and 100"}},
+		{name: "canary weight without canary", env: map[string]string{"CANARY_ROUTES": `{"fn":{"stable":"fn:1","canaryWeight":10}}`}, problems: []string{"CANARY_ROUTES weight for fn requires a canary version"}},
+		{name: "stable only", env: map[string]string{"CANARY_ROUTES": `{"fn":{"stable":"fn:1"}}`}},
+		{name: "basic auth entry without hash", env: map[string]string{"BASIC_AUTH_USERS": "alice"}, problems: []string{"BASIC_AUTH_USERS entries must be 'user:bcrypt-hash'"}},
+		{name: "basic auth plain password", env: map[string]string{"BASIC_AUTH_USERS": "alice:secret"}, problems: []string{"BASIC_AUTH_USERS hash for user alice is not a valid bcrypt hash"}},
+		{name: "http vpc endpoint", env: map[string]string{"LAMBDA_VPC_ENDPOINT": "http://vpce.example.com"}, problems: []string{"LAMBDA_VPC_ENDPOINT must be an https URL"}},
+		{name: "api key rate limit without keys", env: map[string]string{"RATE_LIMIT_KEY": "apikey"}, problems: []string{"RATE_LIMIT_KEY 'apikey' requires API_KEYS"}},
+		{name: "zero proxy hops", env: map[string]string{"TRUSTED_PROXY_HOPS": "0"}, problems: []string{"TRUSTED_PROXY_HOPS must be a whole number of one or more"}},
+		{
+			name:     "multiple problems",
+			env:      map[string]string{"PORT": "abc", "DRY_RUN": "1", "INVOKE_TIMEOUT": "fast"},
+			problems: []string{"DRY_RUN must be", "INVOKE_TIMEOUT must be", "PORT must be"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			err := Validate()
+			if len(tt.problems) == 0 {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected problems %v, got no error", tt.problems)
+			}
+			message := strings.TrimPrefix(err.Error(), "invalid configuration: ")
+			reported := strings.Split(message, "; ")
+			if len(reported) != len(tt.problems) {
+				t.Fatalf("expected %d problems, got %v", len(tt.problems), reported)
+			}
+			for i, problem := range tt.problems {
+				if !strings.HasPrefix(reported[i], problem) {
+					t.Errorf("expected problem %q, got %q", problem, reported[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateOmitsBasicAuthEntry(t *testing.T) {
+	t.Setenv("BASIC_AUTH_USERS", "alice-password")
+	if err := Validate(); err == nil || strings.Contains(err.Error(), "alice-password") {
+		t.Errorf("expected error without the entry, got %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"lambdahttpgw/config"
+	"net/http"
+	"strings"
+)
+
+var (
+	corsAllowOrigins = config.GetCorsAllowOrigins()
+	corsAllowMethods = strings.Join(config.GetCorsAllowMethods(), ", ")
+	corsAllowHeaders = strings.Join(config.GetCorsAllowHeaders(), ", ")
+)
+
+// applyCors sets the CORS response headers for cross-origin requests from an
+// allowed origin. It returns true if the request was a preflight request, which
+// has been fully handled and should not be proxied to a function.
+func applyCors(w http.ResponseWriter, req *http.Request) (handled bool) {
+	origin := req.Header.Get("Origin")
+	if len(corsAllowOrigins) == 0 || origin == "" {
+		return false
+	}
+	allowOrigin, allowed := matchOrigin(origin)
+	if !allowed {
+		return false
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+	w.Header().Add("Vary", "Origin")
+
+	if req.Method != http.MethodOptions || req.Header.Get("Access-Control-Request-Method") == "" {
+		return false
+	}
+	w.Header().Set("Access-Control-Allow-Methods", corsAllowMethods)
+	w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+func matchOrigin(origin string) (allowOrigin string, allowed bool) {
+	for _, allowedOrigin := range corsAllowOrigins {
+		if allowedOrigin == "*" {
+			return "*", true
+		}
+		if strings.EqualFold(allowedOrigin, origin) {
+			return origin, true
+		}
+	}
+	return "", false
+}
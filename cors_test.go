@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// setCorsAllowOrigins allows the origins for the duration of the test.
+func setCorsAllowOrigins(t *testing.T, origins []string) {
+	previous := corsAllowOrigins
+	corsAllowOrigins = origins
+	t.Cleanup(func() { corsAllowOrigins = previous })
+}
+
+func TestCorsPreflight(t *testing.T) {
+	setCorsAllowOrigins(t, []string{"https://app.example.com"})
+	setString(t, &corsAllowMethods, "GET, POST")
+	setString(t, &corsAllowHeaders, "Content-Type")
+	fake := &fakeInvoker{}
+	req := httptest.NewRequest(http.MethodOptions, "/fn/", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+
+	w := serve(newFakeClients(fake), req)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected 204, got %v", w.Code)
+	}
+	expected := map[string]string{
+		"Access-Control-Allow-Origin":  "https://app.example.com",
+		"Access-Control-Allow-Methods": "GET, POST",
+		"Access-Control-Allow-Headers": "Content-Type",
+	}
+	for name, value := range expected {
+		if actual := w.Header().Get(name); actual != value {
+			t.Errorf("expected %v to be %q, got %q", name, value, actual)
+		}
+	}
+	if invocations := len(fake.invocations()); invocations != 0 {
+		t.Errorf("expected the preflight request not to be proxied, got %v invocations", invocations)
+	}
+}
+
+func TestCorsRequest(t *testing.T) {
+	setCorsAllowOrigins(t, []string{"https://app.example.com"})
+	tests := []struct {
+		name        string
+		origin      string
+		allowOrigin string
+	}{
+		{name: "allowed origin", origin: "https://app.example.com", allowOrigin: "https://app.example.com"},
+		{name: "other origin", origin: "https://evil.example.com"},
+		{name: "same origin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeInvoker{respond: respondWith(proxyOutput(http.StatusOK, map[string]string{"Vary": "Accept-Encoding"}, "ok"), nil)}
+			req := httptest.NewRequest(http.MethodGet, "/fn/", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+
+			w := serve(newFakeClients(fake), req)
+
+			if w.Code != http.StatusOK || len(fake.invocations()) != 1 {
+				t.Fatalf("expected the request to be proxied, got %v", w.Code)
+			}
+			if allowOrigin := w.Header().Get("Access-Control-Allow-Origin"); allowOrigin != tt.allowOrigin {
+				t.Errorf("expected allowed origin %q, got %q", tt.allowOrigin, allowOrigin)
+			}
+			expectedVary := []string{"Accept-Encoding"}
+			if tt.allowOrigin != "" {
+				expectedVary = []string{"Origin", "Accept-Encoding"}
+			}
+			if vary := w.Header().Values("Vary"); !reflect.DeepEqual(vary, expectedVary) {
+				t.Errorf("expected Vary %v, got %v", expectedVary, vary)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	b64 "encoding/base64"
+	"errors"
+	"lambdahttpgw/config"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	invokeDurationHeader = "X-Invoke-Duration-Ms"
+	billedDurationHeader = "X-Billed-Duration-Ms"
+
+	// logTailHeader holds the base64 encoded function log tail
+	logTailHeader = "X-Lambda-Log"
+)
+
+var (
+	debugTiming        = config.GetDebugTiming()
+	debugLogs          = config.GetDebugLogs()
+	debugLogHeaderSize = config.GetDebugLogHeaderSize()
+
+	// billedDurationPattern matches the billed duration in the REPORT line of the function log.
+	billedDurationPattern = regexp.MustCompile(`Billed Duration: (\d+) ms`)
+)
+
+// addTimingHeaders sets the measured invocation duration on the response and,
+// if a log tail was returned, the billed duration reported by Lambda.
+func addTimingHeaders(resp *proxyResponse, duration time.Duration, logResult *string) {
+	resp.setHeader(invokeDurationHeader, strconv.FormatInt(duration.Milliseconds(), 10))
+	if billed := parseBilledDuration(logResult); billed != "" {
+		resp.setHeader(billedDurationHeader, billed)
+	}
+}
+
+func parseBilledDuration(logResult *string) string {
+	if match := billedDurationPattern.FindStringSubmatch(decodeLogTail(logResult)); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// decodeLogTail decodes the base64 encoded log tail returned by Lambda,
+// returning an empty string if none was returned.
+func decodeLogTail(logResult *string) string {
+	if logResult == nil {
+		return ""
+	}
+	logTail, err := b64.StdEncoding.DecodeString(*logResult)
+	if err != nil {
+		return ""
+	}
+	return string(logTail)
+}
+
+// setLogTailHeader returns the function log tail to the client, if the error
+// is a function error and the log tail header is enabled. The end of the log
+// tail is kept, as the error is typically logged last. The header value is
+// base64 encoded, as the log tail contains line breaks.
+func setLogTailHeader(w http.ResponseWriter, err error) {
+	var fnErr *functionError
+	if !debugLogs || debugLogHeaderSize <= 0 || !errors.As(err, &fnErr) || fnErr.logTail == "" {
+		return
+	}
+	logTail := fnErr.logTail
+	if len(logTail) > debugLogHeaderSize {
+		logTail = logTail[len(logTail)-debugLogHeaderSize:]
+	}
+	w.Header().Set(logTailHeader, b64.StdEncoding.EncodeToString([]byte(logTail)))
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	b64 "encoding/base64"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDebugTimingHeaders(t *testing.T) {
+	logTail := "START RequestId: 1\nREPORT RequestId: 1\tDuration: 12.34 ms\tBilled Duration: 13 ms\n"
+	tests := []struct {
+		name        string
+		debugTiming bool
+		billed      string
+	}{
+		{name: "enabled", debugTiming: true, billed: "13"},
+		{name: "disabled", debugTiming: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setBool(t, &debugTiming, tt.debugTiming)
+			fake := &fakeInvoker{respond: func(_ aws.Context, input *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+				output := proxyOutput(http.StatusOK, nil, "")
+				if aws.StringValue(input.LogType) == lambda.LogTypeTail {
+					output.LogResult = aws.String(b64.StdEncoding.EncodeToString([]byte(logTail)))
+				}
+				return output, nil
+			}}
+
+			w := serve(newFakeClients(fake), httptest.NewRequest(http.MethodGet, "/fn/", nil))
+
+			if _, present := w.Header()[invokeDurationHeader]; present != tt.debugTiming {
+				t.Errorf("expected %v to be present: %v", invokeDurationHeader, tt.debugTiming)
+			}
+			if billed := w.Header().Get(billedDurationHeader); billed != tt.billed {
+				t.Errorf("expected billed duration %q, got %q", tt.billed, billed)
+			}
+		})
+	}
+}
+
+func TestFunctionLogTail(t *testing.T) {
+	logTail := "START RequestId: 1\nERROR Uncaught exception: boom\nEND RequestId: 1\n"
+	tests := []struct {
+		name       string
+		debugLogs  bool
+		headerSize int
+		logged     bool
+		header     string
+	}{
+		{name: "disabled", headerSize: 1024},
+		{name: "logged", debugLogs: true, logged: true},
+		{name: "header", debugLogs: true, headerSize: 1024, logged: true, header: logTail},
+		{name: "truncated header", debugLogs: true, headerSize: 17, logged: true, header: "END RequestId: 1\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setBool(t, &debugLogs, tt.debugLogs)
+			setInt(t, &debugLogHeaderSize, tt.headerSize)
+			logger := logrus.StandardLogger()
+			hook := logtest.NewLocal(logger)
+			t.Cleanup(func() { logger.ReplaceHooks(make(logrus.LevelHooks)) })
+			fake := &fakeInvoker{respond: func(_ aws.Context, input *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+				output := &lambda.InvokeOutput{
+					StatusCode:    aws.Int64(http.StatusOK),
+					FunctionError: aws.String("Unhandled"),
+					Payload:       []byte(`{"errorMessage":"boom","errorType":"Error"}`),
+				}
+				if aws.StringValue(input.LogType) == lambda.LogTypeTail {
+					output.LogResult = aws.String(b64.StdEncoding.EncodeToString([]byte(logTail)))
+				}
+				return output, nil
+			}}
+
+			w := serve(newFakeClients(fake), httptest.NewRequest(http.MethodGet, "/fn/", nil))
+
+			if w.Code != http.StatusInternalServerError {
+				t.Fatalf("expected 500, got %v", w.Code)
+			}
+			logged := false
+			for _, entry := range hook.AllEntries() {
+				if strings.Contains(entry.Message, "Uncaught exception: boom") {
+					logged = true
+				}
+			}
+			if logged != tt.logged {
+				t.Errorf("expected the log tail to be logged: %v", tt.logged)
+			}
+			var header string
+			if encoded := w.Header().Get(logTailHeader); encoded != "" {
+				decoded, err := b64.StdEncoding.DecodeString(encoded)
+				if err != nil {
+					t.Fatalf("invalid log tail header: %v", err)
+				}
+				header = string(decoded)
+			}
+			if header != tt.header {
+				t.Errorf("expected log tail header %q, got %q", tt.header, header)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"net/http"
+	"strings"
+)
+
+var (
+	errBodyTooLarge          = errors.New("request body too large")
+	errContentEncoding       = errors.New("invalid request body encoding")
+	errContentLength         = errors.New("request body does not match Content-Length")
+	errFunctionError         = errors.New("function returned an error")
+	errFunctionNotAllowed    = errors.New("function is not allowed")
+	errIdempotencyConflict   = errors.New("a request with the same idempotency key is in progress")
+	errInvalidInvocationType = errors.New("invalid invocation type")
+	errNoFunctionURL         = errors.New("no function URL configured")
+	errRequestTemplate       = errors.New("error rendering request template")
+	errUnknownTenant         = errors.New("unknown tenant")
+	errPathNotFound          = errors.New("not found")
+	errResponseTooLarge      = errors.New("function response too large")
+)
+
+// functionError indicates the function returned an error, such as an
+// unhandled exception. The log tail is set if it was requested.
+type functionError struct {
+	functionName string
+	errorType    string
+	logTail      string
+}
+
+func (e *functionError) Error() string {
+	return fmt.Sprintf("%v: %v error from %v", errFunctionError, e.errorType, e.functionName)
+}
+
+func (e *functionError) Unwrap() error {
+	return errFunctionError
+}
+
+// methodNotAllowedError indicates the request method is not accepted by the route.
+type methodNotAllowedError struct {
+	allowed []string
+}
+
+func (e *methodNotAllowedError) Error() string {
+	return fmt.Sprintf("method not allowed, must be one of: %v", strings.Join(e.allowed, ", "))
+}
+
+// parseErrorStatus maps a request parsing error to the status code
+// returned to the client.
+func parseErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errBodyTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errPathNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errUnknownTenant), errors.Is(err, errFunctionNotAllowed):
+		return http.StatusForbidden
+	case errors.Is(err, errRequestTemplate):
+		return http.StatusInternalServerError
+	}
+	var methodErr *methodNotAllowedError
+	if errors.As(err, &methodErr) {
+		return http.StatusMethodNotAllowed
+	}
+	return http.StatusBadRequest
+}
+
+// invokeErrorStatus maps an invocation error to the status code and
+// message returned to the client.
+func invokeErrorStatus(err error) (statusCode int, message string) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, "timed out invoking function"
+	case errors.Is(err, errFunctionError):
+		return http.StatusInternalServerError, errFunctionError.Error()
+	case errors.Is(err, errNoFunctionURL):
+		return http.StatusNotFound, "function not found"
+	case errors.Is(err, errInvalidInvocationType):
+		return http.StatusBadRequest, err.Error()
+	case errors.Is(err, errResponseTooLarge):
+		return http.StatusBadGateway, errResponseTooLarge.Error()
+	}
+
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		switch awsErr.Code() {
+		case lambda.ErrCodeResourceNotFoundException:
+			return http.StatusNotFound, "function not found"
+		case "AccessDeniedException":
+			return http.StatusForbidden, "access denied to function"
+		case lambda.ErrCodeTooManyRequestsException, lambda.ErrCodeEC2ThrottledException:
+			return http.StatusTooManyRequests, "function invocation was throttled"
+		}
+	}
+	return http.StatusBadGateway, "error invoking function"
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAWSErrorStatus(t *testing.T) {
+	setInt(t, &maxRetries, 0)
+	tests := []struct {
+		code       string
+		statusCode int
+	}{
+		{code: lambda.ErrCodeResourceNotFoundException, statusCode: http.StatusNotFound},
+		{code: "AccessDeniedException", statusCode: http.StatusForbidden},
+		{code: lambda.ErrCodeTooManyRequestsException, statusCode: http.StatusTooManyRequests},
+		{code: lambda.ErrCodeEC2ThrottledException, statusCode: http.StatusTooManyRequests},
+		{code: lambda.ErrCodeServiceException, statusCode: http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			fake := &fakeInvoker{respond: respondWith(nil, awserr.New(tt.code, "failed", nil))}
+
+			w := serve(newFakeClients(fake), httptest.NewRequest(http.MethodGet, "/fn/", nil))
+
+			if w.Code != tt.statusCode {
+				t.Errorf("expected %v, got %v: %s", tt.statusCode, w.Code, w.Body)
+			}
+		})
+	}
+}
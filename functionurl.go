@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"lambdahttpgw/config"
+	"lambdahttpgw/stats"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	invokeMode   = config.GetInvokeMode()
+	functionURLs = config.GetFunctionURLs()
+
+	// functionURLClient is shared, so connections to function URLs are reused.
+	functionURLClient = newHTTPClient()
+)
+
+// functionURLRequestHeaders are not forwarded to function URLs, as they
+// would conflict with the request signature.
+var functionURLRequestHeaders = []string{
+	"Authorization",
+	"Host",
+	"X-Amz-Date",
+	"X-Amz-Security-Token",
+}
+
+// resolveFunctionURL returns the configured URL for the function, preferring
+// a URL configured for the qualified function name, such as 'fn:live'.
+func resolveFunctionURL(functionName string, qualifier string) (string, error) {
+	if qualifier != "" {
+		if functionURL, exists := functionURLs[functionName+":"+qualifier]; exists {
+			return functionURL, nil
+		}
+	}
+	if functionURL, exists := functionURLs[functionName]; exists {
+		return functionURL, nil
+	}
+	return "", fmt.Errorf("%w for %v", errNoFunctionURL, functionName)
+}
+
+// buildFunctionURL appends the request path and query string to the function URL.
+func buildFunctionURL(functionURL string, path string, rawQueryString string) string {
+	target := strings.TrimSuffix(functionURL, "/") + path
+	if rawQueryString != "" {
+		target += "?" + rawQueryString
+	}
+	return target
+}
+
+// invokeFunctionURL forwards the request to the function URL, signed with
+// SigV4 using the gateway credentials, instead of using the Invoke API.
+// The function URL response is returned to the client as-is.
+func invokeFunctionURL(
+	ctx context.Context,
+	log *logrus.Entry,
+	clients *clientCache,
+	proxyReq *proxyRequest,
+) (*proxyResponse, error) {
+	functionName := proxyReq.FunctionName
+	if proxyReq.InvocationType != lambda.InvocationTypeRequestResponse {
+		return nil, fmt.Errorf("%w: %v is not supported for function URLs", errInvalidInvocationType, proxyReq.InvocationType)
+	}
+	functionURL, err := resolveFunctionURL(functionName, proxyReq.Qualifier)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, proxyReq.Timeout)
+	defer cancel()
+
+	target := buildFunctionURL(functionURL, proxyReq.Path, proxyReq.RawQueryString)
+	req, err := http.NewRequestWithContext(ctx, proxyReq.HTTPMethod, target, bytes.NewReader(proxyReq.Body))
+	if err != nil {
+		return nil, fmt.Errorf("error building request to %v: %v", target, err)
+	}
+	for key, values := range proxyReq.MultiValueHeaders {
+		if isFunctionURLExcludedHeader(key) {
+			continue
+		}
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	signer := v4.NewSigner(clients.credentials(proxyReq.Role))
+	if _, err := signer.Sign(req, bytes.NewReader(proxyReq.Body), "lambda", proxyReq.Region, time.Now()); err != nil {
+		return nil, fmt.Errorf("error signing request to %v: %v", target, err)
+	}
+
+	log.WithFields(logrus.Fields{
+		"functionName": functionName,
+		"requestBytes": len(proxyReq.Body),
+	}).Debugf("invoking function URL %v with %v", target, proxyReq.HTTPMethod)
+
+	invokeStart := time.Now()
+	resp, err := functionURLClient.Do(req)
+	if err == nil {
+		defer resp.Body.Close()
+	}
+	stats.RecordInvocation(functionName, time.Since(invokeStart), err)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("error calling %v: %w", target, ctx.Err())
+		}
+		return nil, fmt.Errorf("error calling %v: %v", target, err)
+	}
+	body := io.Reader(resp.Body)
+	if maxResponseSize > 0 {
+		// reads one byte over the limit, to detect oversized responses without buffering them
+		body = io.LimitReader(resp.Body, maxResponseSize+1)
+	}
+	respBody, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response from %v: %v", target, err)
+	}
+	if maxResponseSize > 0 && int64(len(respBody)) > maxResponseSize {
+		return nil, fmt.Errorf("%w: %v body exceeds limit of %v bytes", errResponseTooLarge, target, maxResponseSize)
+	}
+
+	log.WithFields(logrus.Fields{
+		"functionName":  functionName,
+		"statusCode":    resp.StatusCode,
+		"responseBytes": len(respBody),
+	}).Debugf("received response from function URL %v", target)
+	return &proxyResponse{
+		StatusCode:        resp.StatusCode,
+		MultiValueHeaders: resp.Header,
+		Body:              respBody,
+	}, nil
+}
+
+func isFunctionURLExcludedHeader(name string) bool {
+	for _, excluded := range functionURLRequestHeaders {
+		if strings.EqualFold(name, excluded) {
+			return true
+		}
+	}
+	return false
+}
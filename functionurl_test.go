@@ -0,0 +1,102 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// setFunctionURLs invokes function URLs for the duration of the test.
+func setFunctionURLs(t *testing.T, urls map[string]string) {
+	previous := functionURLs
+	functionURLs = urls
+	t.Cleanup(func() { functionURLs = previous })
+	setString(t, &invokeMode, "functionurl")
+}
+
+func TestFunctionURLConstruction(t *testing.T) {
+	setFunctionURLs(t, map[string]string{
+		"fn":      "https://abc.lambda-url.eu-west-1.on.aws/",
+		"fn:live": "https://live.lambda-url.eu-west-1.on.aws",
+	})
+	tests := []struct {
+		name           string
+		functionName   string
+		qualifier      string
+		path           string
+		rawQueryString string
+		expected       string
+	}{
+		{name: "path", functionName: "fn", path: "/items/1", expected: "https://abc.lambda-url.eu-west-1.on.aws/items/1"},
+		{name: "query string", functionName: "fn", path: "/", rawQueryString: "a=1&b=2", expected: "https://abc.lambda-url.eu-west-1.on.aws/?a=1&b=2"},
+		{name: "qualified", functionName: "fn", qualifier: "live", path: "/", expected: "https://live.lambda-url.eu-west-1.on.aws/"},
+		{name: "unconfigured qualifier", functionName: "fn", qualifier: "next", path: "/", expected: "https://abc.lambda-url.eu-west-1.on.aws/"},
+		{name: "unconfigured function", functionName: "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			functionURL, err := resolveFunctionURL(tt.functionName, tt.qualifier)
+			if tt.expected == "" {
+				if err == nil {
+					t.Errorf("expected an error, got %v", functionURL)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if target := buildFunctionURL(functionURL, tt.path, tt.rawQueryString); target != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, target)
+			}
+		})
+	}
+}
+
+func TestFunctionURLSigning(t *testing.T) {
+	type received struct {
+		method, path, authorization, date, body, forwarded string
+	}
+	requests := make(chan received, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		requests <- received{
+			method:        req.Method,
+			path:          req.URL.RequestURI(),
+			authorization: req.Header.Get("Authorization"),
+			date:          req.Header.Get("X-Amz-Date"),
+			body:          string(body),
+			forwarded:     req.Header.Get("X-Custom"),
+		}
+		w.Header().Set("X-Function", "url")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("created"))
+	}))
+	defer server.Close()
+	setFunctionURLs(t, map[string]string{"fn": server.URL})
+
+	clients := newFakeClients(&fakeInvoker{})
+	clients.creds = credentials.NewStaticCredentials("AKIDEXAMPLE", "secret", "")
+	req := httptest.NewRequest(http.MethodPost, "/fn/items?a=1", strings.NewReader("hello"))
+	req.Header.Set("Authorization", "Bearer client-token")
+	req.Header.Set("X-Custom", "value")
+
+	w := serve(clients, req)
+
+	if w.Code != http.StatusCreated || w.Body.String() != "created" || w.Header().Get("X-Function") != "url" {
+		t.Fatalf("expected the function URL response, got %v %q", w.Code, w.Body)
+	}
+	r := <-requests
+	if r.method != http.MethodPost || r.path != "/items?a=1" || r.body != "hello" || r.forwarded != "value" {
+		t.Errorf("unexpected forwarded request: %+v", r)
+	}
+	expectedScope := "/" + region + "/lambda/aws4_request"
+	if !strings.HasPrefix(r.authorization, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") || !strings.Contains(r.authorization, expectedScope) {
+		t.Errorf("expected a SigV4 signature for lambda in %v, got %q", region, r.authorization)
+	}
+	if r.date == "" {
+		t.Error("expected X-Amz-Date to be set")
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"lambdahttpgw/config"
+	"strings"
+	"sync"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+
+	// idempotentReplayedHeader is set on responses returned from the cache
+	idempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+var idempotencyTTL = config.GetIdempotencyTTL()
+
+// idempotencyCache holds responses by function and idempotency key.
+// It is nil if idempotency keys are disabled.
+var idempotencyCache = newIdempotencyCache(config.GetIdempotencyCacheSize())
+
+func newIdempotencyCache(size int) *responseCache {
+	if size <= 0 {
+		return nil
+	}
+	return newResponseCache(size)
+}
+
+// inFlightKeys holds the idempotency cache keys of requests being invoked.
+var (
+	inFlightKeys = make(map[string]bool)
+	inFlightLock sync.Mutex
+)
+
+// idempotencyCacheKey returns the cache key for the request, or an empty
+// string if idempotency keys are disabled or the request has no key.
+// Only requests from the same client, to the same function, qualifier,
+// region and role, share responses.
+func idempotencyCacheKey(proxyReq *proxyRequest, idempotencyKey string, credentials string) string {
+	if idempotencyCache == nil || idempotencyKey == "" {
+		return ""
+	}
+	return strings.Join([]string{
+		proxyReq.Region,
+		proxyReq.Role.arn,
+		proxyReq.FunctionName,
+		proxyReq.Qualifier,
+		credentials,
+		idempotencyKey,
+	}, "\x00")
+}
+
+// claimIdempotencyKey returns the cached response for the key, if any.
+// Otherwise, the key is marked as in flight until release is called, so
+// concurrent requests with the same key fail with errIdempotencyConflict,
+// rather than invoking the function again.
+func claimIdempotencyKey(cacheKey string) (resp *proxyResponse, release func(), err error) {
+	release = func() {}
+	if cacheKey == "" {
+		return nil, release, nil
+	}
+	inFlightLock.Lock()
+	defer inFlightLock.Unlock()
+
+	// checked while holding the lock, as the response is stored before the key is released
+	if resp, found := idempotencyCache.get(cacheKey); found {
+		resp.setHeader(idempotentReplayedHeader, "true")
+		return resp, release, nil
+	}
+	if inFlightKeys[cacheKey] {
+		return nil, release, errIdempotencyConflict
+	}
+	inFlightKeys[cacheKey] = true
+	return nil, func() {
+		inFlightLock.Lock()
+		delete(inFlightKeys, cacheKey)
+		inFlightLock.Unlock()
+	}, nil
+}
+
+// storeIdempotentResponse caches the response for the key. Server error
+// responses are not cached, so the request can be retried.
+func storeIdempotentResponse(cacheKey string, resp *proxyResponse) {
+	if cacheKey == "" || resp.StatusCode >= 500 {
+		return
+	}
+	idempotencyCache.add(cacheKey, resp, idempotencyTTL)
+}
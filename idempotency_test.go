@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func enableIdempotencyCache(t *testing.T, ttl time.Duration) {
+	previousCache, previousTTL := idempotencyCache, idempotencyTTL
+	idempotencyCache, idempotencyTTL = newResponseCache(10), ttl
+	t.Cleanup(func() {
+		idempotencyCache, idempotencyTTL = previousCache, previousTTL
+	})
+}
+
+func TestIdempotencyCache(t *testing.T) {
+	fn := &proxyRequest{Region: "eu-west-1", FunctionName: "fn"}
+	tests := []struct {
+		name         string
+		storeReq     *proxyRequest
+		storeCreds   string
+		lookupReq    *proxyRequest
+		lookupKey    string
+		lookupCreds  string
+		statusCode   int
+		expectReplay bool
+	}{
+		{name: "hit", storeReq: fn, lookupReq: fn, lookupKey: "key-1", expectReplay: true},
+		{name: "other key", storeReq: fn, lookupReq: fn, lookupKey: "key-2"},
+		{name: "same client", storeReq: fn, storeCreds: "a", lookupReq: fn, lookupKey: "key-1", lookupCreds: "a", expectReplay: true},
+		{name: "other client", storeReq: fn, storeCreds: "a", lookupReq: fn, lookupKey: "key-1", lookupCreds: "b"},
+		{name: "other function", storeReq: fn, lookupReq: &proxyRequest{Region: "eu-west-1", FunctionName: "other"}, lookupKey: "key-1"},
+		{name: "other qualifier", storeReq: fn, lookupReq: &proxyRequest{Region: "eu-west-1", FunctionName: "fn", Qualifier: "v2"}, lookupKey: "key-1"},
+		{name: "other region", storeReq: fn, lookupReq: &proxyRequest{Region: "us-east-1", FunctionName: "fn"}, lookupKey: "key-1"},
+		{name: "other tenant role", storeReq: fn, lookupReq: &proxyRequest{Region: "eu-west-1", FunctionName: "fn", Role: role{arn: "arn:aws:iam::123456789012:role/tenant"}}, lookupKey: "key-1"},
+		{name: "client error stored", storeReq: fn, lookupReq: fn, lookupKey: "key-1", statusCode: http.StatusBadRequest, expectReplay: true},
+		{name: "server error not stored", storeReq: fn, lookupReq: fn, lookupKey: "key-1", statusCode: http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enableIdempotencyCache(t, time.Minute)
+			statusCode := tt.statusCode
+			if statusCode == 0 {
+				statusCode = http.StatusCreated
+			}
+			storeKey := idempotencyCacheKey(tt.storeReq, "key-1", tt.storeCreds)
+			_, release, err := claimIdempotencyKey(storeKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			storeIdempotentResponse(storeKey, &proxyResponse{StatusCode: statusCode, Body: []byte("created")})
+			release()
+
+			resp, release, err := claimIdempotencyKey(idempotencyCacheKey(tt.lookupReq, tt.lookupKey, tt.lookupCreds))
+			defer release()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if replayed := resp != nil; replayed != tt.expectReplay {
+				t.Fatalf("expected replay %v, got %v", tt.expectReplay, replayed)
+			}
+			if resp != nil && resp.getHeader(idempotentReplayedHeader) != "true" {
+				t.Errorf("expected %v header on replayed response", idempotentReplayedHeader)
+			}
+		})
+	}
+}
+
+func TestIdempotencyKeyDisabled(t *testing.T) {
+	if key := idempotencyCacheKey(&proxyRequest{FunctionName: "fn"}, "", ""); key != "" {
+		t.Errorf("expected no key without an idempotency key header, got %q", key)
+	}
+}
+
+func TestIdempotencyExpiry(t *testing.T) {
+	enableIdempotencyCache(t, 10*time.Millisecond)
+	key := idempotencyCacheKey(&proxyRequest{FunctionName: "fn"}, "key-1", "")
+	storeIdempotentResponse(key, &proxyResponse{StatusCode: http.StatusOK})
+	time.Sleep(20 * time.Millisecond)
+	resp, release, err := claimIdempotencyKey(key)
+	defer release()
+	if err != nil || resp != nil {
+		t.Errorf("expected response to expire after the TTL, got %v, %v", resp, err)
+	}
+}
+
+func TestIdempotencyConflict(t *testing.T) {
+	enableIdempotencyCache(t, time.Minute)
+	key := idempotencyCacheKey(&proxyRequest{FunctionName: "fn"}, "key-1", "")
+
+	_, release, err := claimIdempotencyKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := claimIdempotencyKey(key); !errors.Is(err, errIdempotencyConflict) {
+		t.Fatalf("expected conflict while in flight, got %v", err)
+	}
+	release()
+
+	_, releaseRetry, err := claimIdempotencyKey(key)
+	if err != nil {
+		t.Fatalf("expected retry to be allowed after a failed request, got %v", err)
+	}
+	storeIdempotentResponse(key, &proxyResponse{StatusCode: http.StatusOK})
+	releaseRetry()
+
+	if resp, _, err := claimIdempotencyKey(key); err != nil || resp == nil {
+		t.Fatalf("expected stored response after release, got %v, %v", resp, err)
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"lambdahttpgw/config"
+	"path"
+)
+
+var (
+	longPollRoutes  = config.GetLongPollRoutes()
+	longPollTimeout = config.GetLongPollTimeout()
+)
+
+// isLongPollPath determines whether the request path matches a long poll
+// route, so the long poll timeout applies instead of the invocation timeout.
+func isLongPollPath(requestPath string) bool {
+	for _, pattern := range longPollRoutes {
+		if matched, _ := path.Match(pattern, requestPath); matched {
+			return true
+		}
+	}
+	return false
+}
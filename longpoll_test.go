@@ -0,0 +1,47 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLongPollTimeout(t *testing.T) {
+	setDuration(t, &invokeTimeout, 30*time.Second)
+	setDuration(t, &longPollTimeout, 10*time.Minute)
+	setDuration(t, &maxInvokeTimeout, 15*time.Minute)
+	previous := longPollRoutes
+	longPollRoutes = []string{"/poll-fn/events", "/stream-fn/*"}
+	t.Cleanup(func() { longPollRoutes = previous })
+	tests := []struct {
+		name    string
+		path    string
+		timeout time.Duration
+	}{
+		{name: "exact route", path: "/poll-fn/events", timeout: 10 * time.Minute},
+		{name: "pattern route", path: "/stream-fn/updates", timeout: 10 * time.Minute},
+		{name: "other path of function", path: "/poll-fn/other", timeout: 30 * time.Second},
+		{name: "pattern matches one segment", path: "/stream-fn/updates/1", timeout: 30 * time.Second},
+		{name: "other function", path: "/fn/events", timeout: 30 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var remaining time.Duration
+			fake := &fakeInvoker{respond: func(ctx aws.Context, _ *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+				deadline, _ := ctx.Deadline()
+				remaining = time.Until(deadline)
+				return proxyOutput(http.StatusOK, nil, ""), nil
+			}}
+
+			if w := serve(newFakeClients(fake), httptest.NewRequest(http.MethodGet, tt.path, nil)); w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %v: %s", w.Code, w.Body)
+			}
+			if remaining > tt.timeout || remaining < tt.timeout-time.Second {
+				t.Errorf("expected a timeout of %v, got %v remaining", tt.timeout, remaining)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// responseCache is an in-memory LRU cache of function responses, where each
+// entry expires after its own TTL. It is safe for concurrent use.
+type responseCache struct {
+	lock    sync.Mutex
+	size    int
+	entries *list.List
+	index   map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key    string
+	resp   *proxyResponse
+	expiry time.Time
+}
+
+func newResponseCache(size int) *responseCache {
+	return &responseCache{
+		size:    size,
+		entries: list.New(),
+		index:   make(map[string]*list.Element),
+	}
+}
+
+// get returns a copy of the cached response, if present and not expired.
+func (c *responseCache) get(key string) (*proxyResponse, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, exists := c.index[key]
+	if !exists {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.expiry) {
+		c.entries.Remove(element)
+		delete(c.index, key)
+		return nil, false
+	}
+	c.entries.MoveToFront(element)
+	return entry.resp.clone(), true
+}
+
+// add caches a copy of the response for the TTL, evicting the least
+// recently used entry if the cache is full.
+func (c *responseCache) add(key string, resp *proxyResponse, ttl time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry := &cacheEntry{key: key, resp: resp.clone(), expiry: time.Now().Add(ttl)}
+	if element, exists := c.index[key]; exists {
+		element.Value = entry
+		c.entries.MoveToFront(element)
+		return
+	}
+	c.index[key] = c.entries.PushFront(entry)
+	if c.entries.Len() > c.size {
+		oldest := c.entries.Back()
+		c.entries.Remove(oldest)
+		delete(c.index, oldest.Value.(*cacheEntry).key)
+	}
+}
@@ -1,74 +1,426 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	b64 "encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/lambda"
-	"github.com/google/uuid"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
-	"io/ioutil"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"io"
 	"lambdahttpgw/config"
+	"lambdahttpgw/routing"
 	"lambdahttpgw/stats"
+	"net"
 	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
 	"strings"
+	"syscall"
+	"text/template"
 	"time"
+	"unicode/utf8"
 )
 
 var (
-	region          = config.GetRegion()
-	requestIdHeader = config.GetRequestIdHeader()
-	version         = "dev"
+	region                = config.GetRegion()
+	requestIdHeader       = config.GetRequestIdHeader()
+	invokeTimeout         = config.GetInvokeTimeout()
+	maxInvokeTimeout      = config.GetMaxInvokeTimeout()
+	functionConfigs       = config.GetFunctionConfigs()
+	payloadVersion        = config.GetPayloadVersion()
+	stage                 = config.GetStage()
+	maxBodySize           = config.GetMaxBodySize()
+	maxResponseSize       = config.GetMaxResponseSize()
+	maxRetries            = config.GetMaxRetries()
+	textMimeTypes         = config.GetTextMimeTypes()
+	dryRun                = config.GetDryRun()
+	hostRouting           = config.GetHostRouting()
+	passthroughResponse   = config.GetPassthroughResponse()
+	trustProxy            = config.GetTrustProxy()
+	trustedProxyHops      = config.GetTrustedProxyHops()
+	setForwardedHeaders   = config.GetSetForwardedHeaders()
+	forwardHeaders        = toHeaderSet(config.GetForwardHeaders())
+	dropHeaders           = toHeaderSet(config.GetDropHeaders())
+	pathPrefix            = config.GetPathPrefix()
+	trailingSlash         = config.GetTrailingSlash()
+	exposeExecutedVersion = config.GetExposeExecutedVersion()
+	rawBase64Response     = config.GetRawBase64Response()
+	detectBase64Response  = config.GetDetectBase64Response()
+	streamThreshold       = config.GetStreamThreshold()
+	version               = "dev"
+	commit                = "none"
+	date                  = "unknown"
+)
+
+// streamChunkSize is the size of each chunk of a streamed response body.
+const streamChunkSize = 32 * 1024
+
+// maxInitialBodyBuffer caps the buffer allocated for a request body before
+// it is read, as the declared Content-Length is chosen by the client.
+const maxInitialBodyBuffer = 64 * 1024
+
+const (
+	functionHeader       = "X-Lambda-Function"
+	qualifierHeader      = "X-Lambda-Qualifier"
+	invocationTypeHeader = "X-Invocation-Type"
+	rawResponseHeader    = "X-Raw-Response"
+	timeoutHeader        = "X-Timeout-Ms"
+
+	executedVersionHeader = "X-Lambda-Executed-Version"
+)
+
+var (
+	// qualifierPattern matches valid function version numbers and alias names.
+	qualifierPattern = regexp.MustCompile(`^[a-zA-Z0-9$_-]{1,128}$`)
+
+	// arnPattern matches a function ARN, with an optional qualifier.
+	arnPattern = regexp.MustCompile(`^arn:aws[a-zA-Z-]*:lambda:[a-z0-9-]+:\d{12}:function:[a-zA-Z0-9_-]+(:[a-zA-Z0-9$_-]+)?$`)
 )
 
 func main() {
 	logrus.SetLevel(config.GetConfigLevel())
+	setLogFormat(config.GetLogFormat())
+	if err := config.Validate(); err != nil {
+		logrus.Fatal(err)
+	}
 	stats.Init()
+	routing.Init()
+	shutdownTracing := initTracing()
 
-	http.Handle("/system/metrics", promhttp.Handler())
+	if config.MetricsEnabled {
+		http.Handle(config.GetMetricsPath(), promhttp.Handler())
+	}
 	http.HandleFunc("/system/status", statusHandler)
-	http.HandleFunc("/", handler)
+	if config.StatsRecorderEnabled {
+		http.HandleFunc("/system/stats", stats.Handler)
+	}
+	http.HandleFunc(config.GetHealthPath(), healthHandler)
+	http.HandleFunc(config.GetVersionPath(), versionHandler)
+	clients := newClientCache()
+	http.HandleFunc("/", instrument(traced(func(w http.ResponseWriter, req *http.Request) {
+		handler(w, req, clients)
+	})))
 
 	port := config.GetPort()
-	logrus.Infof("starting http lambda gateway %v for region %v on port %v", version, region, port)
-	err := http.ListenAndServe(":"+port, nil)
+	server := newServer(":"+port, http.DefaultServeMux)
+
+	certFile, keyFile := config.GetTLSCertFile(), config.GetTLSKeyFile()
+	if (certFile == "") != (keyFile == "") {
+		logrus.Fatalf("both TLS_CERT_FILE and TLS_KEY_FILE must be set to enable TLS")
+	}
+
+	go func() {
+		if err := listenAndServe(server, certFile, keyFile); err != nil && err != http.ErrServerClosed {
+			panic(err)
+		}
+	}()
+
+	// the server is started first, so requests during startup receive a 503
+	if !dryRun {
+		verifyCredentials(clients)
+		startWarmup(context.Background(), clients)
+	}
+	watchCanaryRoutes()
+	setReady()
+	logrus.Debugf("lambda gateway is ready")
+
+	waitForShutdown(server)
+	if err := shutdownTracing(context.Background()); err != nil {
+		logrus.Errorf("error flushing traces: %v", err)
+	}
+}
+
+// newServer returns the server for the handler, with the configured timeouts,
+// which protect against slow clients holding connections open.
+func newServer(addr string, handler http.Handler) *http.Server {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       config.GetReadTimeout(),
+		ReadHeaderTimeout: config.GetReadHeaderTimeout(),
+		WriteTimeout:      config.GetWriteTimeout(),
+		IdleTimeout:       config.GetIdleTimeout(),
+	}
+	if config.GetEnableH2C() {
+		// serves HTTP/2 without TLS, falling back to HTTP/1 for other clients
+		server.Handler = h2c.NewHandler(handler, &http2.Server{
+			IdleTimeout: server.IdleTimeout,
+		})
+	}
+	return server
+}
+
+// listenAndServe serves HTTPS if a TLS certificate and key are set,
+// otherwise HTTP, until the server is shut down.
+func listenAndServe(server *http.Server, certFile string, keyFile string) error {
+	if certFile != "" {
+		logrus.Infof("starting https lambda gateway %v for region %v on %v", version, region, server.Addr)
+		return server.ListenAndServeTLS(certFile, keyFile)
+	}
+	logrus.Infof("starting http lambda gateway %v for region %v on %v", version, region, server.Addr)
+	return server.ListenAndServe()
+}
+
+// setLogFormat selects the JSON log formatter, if configured, instead of the
+// default text formatter.
+func setLogFormat(format string) {
+	if format == "json" {
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	}
+}
+
+// waitForShutdown blocks until an interrupt or termination signal is received,
+// then allows in-flight requests to complete, within the configured grace period.
+func waitForShutdown(server *http.Server) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+
+	shutdownTimeout := config.GetShutdownTimeout()
+	logrus.Infof("received %v - shutting down within %v", sig, shutdownTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logrus.Errorf("error shutting down server: %v", err)
+		return
+	}
+	logrus.Infof("shutdown complete")
+}
+
+// verifyCredentials checks the AWS credentials at startup, so misconfiguration
+// is reported before the first request. If FAIL_ON_BAD_CREDS is set, the
+// gateway exits if the credentials are invalid.
+func verifyCredentials(clients *clientCache) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	callerArn, err := clients.checkCredentials(ctx)
 	if err != nil {
-		panic(err)
+		if config.GetFailOnBadCreds() {
+			logrus.Fatalf("AWS credentials are invalid or unavailable: %v", err)
+		}
+		logrus.Errorf("AWS credentials are invalid or unavailable - function invocations will fail: %v", err)
+		return
 	}
+	logrus.Infof("using AWS credentials for %v", callerArn)
 }
 
 func statusHandler(w http.ResponseWriter, _ *http.Request) {
 	_, _ = fmt.Fprintf(w, "ok\n")
 }
 
-func handler(w http.ResponseWriter, req *http.Request) {
+// healthHandler responds to liveness/readiness probes without invoking Lambda.
+func healthHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !isReady() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = fmt.Fprintf(w, `{"status":"starting"}`)
+		return
+	}
+	_, _ = fmt.Fprintf(w, `{"status":"ok"}`)
+}
+
+// versionHandler responds with the build information, which is set at build
+// time using '-ldflags', such as '-X main.version=1.2.3'.
+func versionHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"version": version,
+		"commit":  commit,
+		"date":    date,
+	})
+}
+
+// proxyRequest holds the parts of the incoming HTTP request that are
+// forwarded to the Lambda function.
+type proxyRequest struct {
+	RequestID      string
+	FunctionName   string
+	Qualifier      string
+	Region         string
+	Role           role
+	InvocationType string
+	Timeout        time.Duration
+	RawResponse    bool
+	// StreamResponse allows a large binary response body to be decoded as it
+	// is written, as it is not needed in memory, such as to be cached
+	StreamResponse bool
+	// ResponseTemplate reshapes the function response body, if set
+	ResponseTemplate  *template.Template
+	HTTPMethod        string
+	Path              string
+	Resource          string
+	PathParameters    map[string]string
+	Protocol          string
+	SourceIP          string
+	UserAgent         string
+	Headers           map[string]string
+	MultiValueHeaders map[string][]string
+	RawQueryString    string
+	// single-value query parameters take the first value of each key
+	QueryStringParameters           map[string]string
+	MultiValueQueryStringParameters map[string][]string
+	Body                            []byte
+}
+
+// proxyResponse holds the parts of the Lambda response that are
+// returned to the HTTP client.
+type proxyResponse struct {
+	StatusCode        int
+	Headers           map[string]string
+	MultiValueHeaders map[string][]string
+	Body              []byte
+	// encodedBody is a base64 encoded body, decoded as it is written to the
+	// client, if set, instead of Body
+	encodedBody string
+}
+
+func handler(w http.ResponseWriter, req *http.Request, clients *clientCache) {
 	startTime := time.Now()
 	stats.IncActiveRequests()
 	defer stats.DecActiveRequests()
-	log := logrus.WithField("requestId", getRequestId(requestIdHeader, req))
+	requestId := getRequestId(requestIdHeader, req)
+	log := logrus.WithField("requestId", requestId)
+
+	// set before any response is written, so it is present on errors too
+	w.Header().Set(requestIdHeader, requestId)
 
 	client := req.RemoteAddr
 	log.Debugf("received request %v %v from client %v", req.Method, req.URL, client)
 
-	functionName, path, requestHeaders, requestBody, err := parseRequest(req)
+	if !isReady() {
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusServiceUnavailable, "gateway is starting", requestId)
+		return
+	}
+
+	if handled := applyCors(w, req); handled {
+		log.Debugf("responded to CORS preflight request from client %v", client)
+		return
+	}
+
+	// hashed before Basic auth credentials are removed, to scope cached responses to the client
+	credentials := credentialHash(req)
+
+	if !isAuthorised(req) {
+		log.Warnf("rejecting request from client %v - missing or invalid API key", client)
+		writeError(w, http.StatusUnauthorized, "missing or invalid API key", requestId)
+		return
+	}
+	// limited before Basic auth credentials are verified, so clients cannot guess passwords, or
+	// use up CPU verifying them, faster than the rate limit
+	if !applyRateLimit(w, req) {
+		log.Warnf("rejecting request from client %v - rate limit exceeded", client)
+		writeError(w, http.StatusTooManyRequests, "rate limit exceeded", requestId)
+		return
+	}
+	if !isBasicAuthorised(req) {
+		log.Warnf("rejecting request from client %v - missing or invalid credentials", client)
+		w.Header().Set("WWW-Authenticate", basicAuthRealm)
+		writeError(w, http.StatusUnauthorized, "missing or invalid credentials", requestId)
+		return
+	}
+
+	req.Body = http.MaxBytesReader(w, req.Body, maxBodySize)
+	proxyReq, err := parseRequest(req, requestId)
 	if err != nil {
 		log.Error(err)
-		w.WriteHeader(http.StatusBadRequest)
+		var methodErr *methodNotAllowedError
+		if errors.As(err, &methodErr) {
+			w.Header().Set("Allow", strings.Join(methodErr.allowed, ", "))
+		}
+		writeError(w, parseErrorStatus(err), err.Error(), requestId)
 		return
 	}
+	functionName := proxyReq.FunctionName
+	setSpanFunction(req.Context(), functionName)
 
-	code, responseBody, responseHeaders, err := invoke(log, functionName, req.Method, path, requestHeaders, requestBody)
+	cacheKey := idempotencyCacheKey(proxyReq, req.Header.Get(idempotencyKeyHeader), credentials)
+	cachedResp, releaseKey, err := claimIdempotencyKey(cacheKey)
+	if err != nil {
+		log.Warnf("rejecting request to %v - %v", functionName, err)
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusConflict, err.Error(), requestId)
+		return
+	}
+	// released once the response is stored, so a later duplicate finds it
+	defer releaseKey()
+
+	getCacheKey := responseCacheKey(req, proxyReq, credentials)
+	found := cachedResp != nil
+	if !found {
+		cachedResp, found = getCachedResponse(req, getCacheKey)
+	}
+	if found {
+		log.Debugf("returning cached response from %v", functionName)
+		if err = compressResponse(req, cachedResp); err != nil {
+			log.Warnf("failed to compress response - sending uncompressed: %v", err)
+		}
+		if err = sendResponse(log, w, cachedResp, client); err != nil {
+			log.Error(err)
+		}
+		return
+	}
+
+	if !acquireInvokeSlot() {
+		log.Warnf("rejecting request to %v - concurrency limit reached", functionName)
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusServiceUnavailable, "too many concurrent requests", requestId)
+		return
+	}
+	defer releaseInvokeSlot()
+	// checked once a slot is acquired, so a probe request is always invoked
+	if !breaker.allow(functionName) {
+		log.Warnf("rejecting request to %v - circuit is open", functionName)
+		w.Header().Set("Retry-After", strconv.Itoa(int(breaker.resetTimeout.Seconds())))
+		writeError(w, http.StatusServiceUnavailable, "function is unavailable", requestId)
+		return
+	}
+	mirrorRequest(log, clients, proxyReq)
+	proxyReq.StreamResponse = cacheKey == "" && getCacheKey == "" && proxyReq.ResponseTemplate == nil
+
+	var proxyResp *proxyResponse
+	if invokeMode == "functionurl" {
+		proxyResp, err = invokeFunctionURL(req.Context(), log, clients, proxyReq)
+	} else {
+		proxyResp, err = invoke(req.Context(), log, clients.get(proxyReq.Region, proxyReq.Role), proxyReq)
+	}
+	breaker.record(functionName, err)
 	if err != nil {
 		log.Error(err)
-		w.WriteHeader(http.StatusBadGateway)
+		stats.RecordHit(stats.Invocation{
+			FunctionName: functionName,
+			Duration:     time.Since(startTime),
+			Failed:       true,
+		})
+		statusCode, message := invokeErrorStatus(err)
+		setLogTailHeader(w, err)
+		writeError(w, statusCode, message, requestId)
 		return
 	}
+	if proxyReq.ResponseTemplate != nil {
+		renderResponseTemplate(log, proxyReq.ResponseTemplate, proxyResp)
+	}
+	storeIdempotentResponse(cacheKey, proxyResp)
+	storeCachedResponse(getCacheKey, proxyResp)
 
-	err = sendResponse(log, w, responseHeaders, code, responseBody, client)
+	if err = compressResponse(req, proxyResp); err != nil {
+		log.Warnf("failed to compress response - sending uncompressed: %v", err)
+	}
+
+	err = sendResponse(log, w, proxyResp, client)
 	if err != nil {
 		log.Error(err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -76,7 +428,13 @@ func handler(w http.ResponseWriter, req *http.Request) {
 	}
 
 	elapsed := time.Since(startTime)
-	log.Infof("proxied request to %v [code: %v, body %v bytes] for client %v in %v", functionName, code, len(*responseBody), client, elapsed)
+	log.WithFields(logrus.Fields{
+		"functionName":  functionName,
+		"statusCode":    proxyResp.StatusCode,
+		"requestBytes":  len(proxyReq.Body),
+		"responseBytes": proxyResp.bodyLen(),
+		"durationMs":    elapsed.Milliseconds(),
+	}).Infof("proxied request to %v for client %v in %v", functionName, client, elapsed)
 	stats.RecordHit(stats.Invocation{
 		FunctionName: functionName,
 		Duration:     elapsed,
@@ -89,104 +447,533 @@ func getRequestId(headerName string, req *http.Request) string {
 		requestId = req.Header.Get(headerName)
 	}
 	if requestId == "" {
-		requestId = uuid.NewString()
+		requestId = requestIds.NewID()
 	}
 	return requestId
 }
 
-func parseRequest(req *http.Request) (functionName string, path string, headers *map[string]string, body *[]byte, err error) {
-	splitPath := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/"), "/", 2)
+func parseRequest(req *http.Request, requestId string) (*proxyRequest, error) {
+	// the escaped path is used so encoded characters, such as slashes,
+	// are passed to the function verbatim
+	requestPath, err := stripPathPrefix(req.URL.EscapedPath())
+	if err != nil {
+		return nil, err
+	}
 
-	path = "/"
-	if len(splitPath) >= 1 && splitPath[0] != "" {
-		functionName = splitPath[0]
-		if len(splitPath) >= 2 {
-			path = "/" + splitPath[1]
+	var functionName, path, resource string
+	var pathParameters map[string]string
+	var requestTemplate, responseTemplate *template.Template
+	if headerFunction := req.Header.Get(functionHeader); headerFunction != "" {
+		// the full path is passed to the function
+		functionName, path = headerFunction, requestPath
+	} else if hostRouting {
+		functionName, err = routing.ResolveHost(req.Host)
+		if err != nil {
+			return nil, err
 		}
+		path = requestPath
 	} else {
-		return "", "", nil, nil, fmt.Errorf("path must include function name and request path")
+		match, err := routing.Resolve(requestPath)
+		if err != nil {
+			return nil, err
+		}
+		if !isMethodAllowed(req.Method, match.AllowedMethods) {
+			return nil, &methodNotAllowedError{allowed: match.AllowedMethods}
+		}
+		path, resource, pathParameters = match.Path, match.Resource, match.PathParameters
+		requestTemplate, responseTemplate = match.RequestTemplate, match.ResponseTemplate
+		functionName, err = url.PathUnescape(match.FunctionName)
+		if err != nil {
+			return nil, fmt.Errorf("invalid function name: %v", err)
+		}
+		if functionName, err = decodeFunctionName(functionName); err != nil {
+			return nil, err
+		}
+	}
+	path = normaliseTrailingSlash(path)
+
+	headerQualifier := req.Header.Get(qualifierHeader)
+	functionName = selectCanary(functionName, headerQualifier)
+	functionName, qualifier, err := parseFunctionName(functionName, headerQualifier)
+	if err != nil {
+		return nil, err
+	}
+
+	tenant, err := resolveTenant(req.Header.Get(tenantHeader))
+	if err != nil {
+		return nil, err
+	}
+	if functionName, err = applyTenantPrefix(tenant, functionName); err != nil {
+		return nil, err
+	}
+	if err = checkFunctionAllowed(functionName); err != nil {
+		return nil, err
+	}
+	headerRegion := req.Header.Get(regionHeader)
+	var tenantRole role
+	if tenant != nil {
+		if headerRegion == "" {
+			headerRegion = tenant.Region
+		}
+		tenantRole = role{arn: tenant.RoleARN, externalId: tenant.ExternalID}
+	}
+
+	functionRegion, err := resolveRegion(functionName, headerRegion)
+	if err != nil {
+		return nil, err
 	}
 
+	invocationType := req.Header.Get(invocationTypeHeader)
+	if invocationType == "" {
+		invocationType = lambda.InvocationTypeRequestResponse
+	} else if invocationType != lambda.InvocationTypeRequestResponse && invocationType != lambda.InvocationTypeEvent {
+		return nil, fmt.Errorf("%w: %v", errInvalidInvocationType, invocationType)
+	}
+
+	timeout, err := parseTimeout(functionName, requestPath, req.Header.Get(timeoutHeader))
+	if err != nil {
+		return nil, err
+	}
+
+	// single-value headers are retained for backward compatibility
 	requestHeaders := make(map[string]string)
+	multiValueHeaders := make(map[string][]string)
 	for requestHeaderKey, requestHeaderValue := range req.Header {
 		requestHeaders[requestHeaderKey] = requestHeaderValue[0]
+		multiValueHeaders[requestHeaderKey] = requestHeaderValue
+	}
+	// the Host header is removed from req.Header, but is needed by functions that build absolute URLs
+	if req.Host != "" {
+		setRequestHeader(requestHeaders, multiValueHeaders, "Host", req.Host)
+		// an existing header is only retained from a trusted proxy, as clients could set it to any host
+		if _, exists := multiValueHeaders["X-Forwarded-Host"]; !exists || !trustProxy {
+			setRequestHeader(requestHeaders, multiValueHeaders, "X-Forwarded-Host", req.Host)
+		}
+	}
+	if setForwardedHeaders {
+		addForwardedHeaders(req, requestHeaders, multiValueHeaders)
+	}
+	filterRequestHeaders(requestHeaders, multiValueHeaders)
+
+	queryParams := make(map[string]string)
+	multiValueQueryParams := make(map[string][]string)
+	for queryKey, queryValue := range req.URL.Query() {
+		queryParams[queryKey] = queryValue[0]
+		multiValueQueryParams[queryKey] = queryValue
 	}
 
-	requestBody, err := ioutil.ReadAll(req.Body)
+	requestBody, err := readBody(req)
 	if err != nil {
-		return "", "", nil, nil, fmt.Errorf("error parsing request body: %v", err)
+		return nil, err
+	}
+	requestBody, decoded, err := decodeRequestBody(req.Header.Get("Content-Encoding"), requestBody)
+	if err != nil {
+		return nil, err
+	}
+	if decoded {
+		delete(requestHeaders, "Content-Encoding")
+		delete(multiValueHeaders, "Content-Encoding")
+		if _, exists := multiValueHeaders["Content-Length"]; exists {
+			setRequestHeader(requestHeaders, multiValueHeaders, "Content-Length", strconv.Itoa(len(requestBody)))
+		}
 	}
-	return functionName, path, &requestHeaders, &requestBody, err
+	proxyReq := &proxyRequest{
+		RequestID:                       requestId,
+		FunctionName:                    functionName,
+		Qualifier:                       qualifier,
+		Region:                          functionRegion,
+		Role:                            tenantRole,
+		InvocationType:                  invocationType,
+		Timeout:                         timeout,
+		RawResponse:                     rawBase64Response || req.Header.Get(rawResponseHeader) == "true",
+		ResponseTemplate:                responseTemplate,
+		HTTPMethod:                      req.Method,
+		Path:                            path,
+		Resource:                        resource,
+		PathParameters:                  pathParameters,
+		Protocol:                        req.Proto,
+		SourceIP:                        getSourceIP(req),
+		UserAgent:                       req.UserAgent(),
+		RawQueryString:                  req.URL.RawQuery,
+		Headers:                         requestHeaders,
+		MultiValueHeaders:               multiValueHeaders,
+		QueryStringParameters:           queryParams,
+		MultiValueQueryStringParameters: multiValueQueryParams,
+		Body:                            requestBody,
+	}
+	if requestTemplate != nil {
+		if proxyReq.Body, err = renderRequestTemplate(requestTemplate, proxyReq); err != nil {
+			return nil, err
+		}
+		if _, exists := multiValueHeaders["Content-Length"]; exists {
+			contentLength := strconv.Itoa(len(proxyReq.Body))
+			requestHeaders["Content-Length"] = contentLength
+			multiValueHeaders["Content-Length"] = []string{contentLength}
+		}
+	}
+	return proxyReq, nil
 }
 
-func invoke(
-	log *logrus.Entry,
-	functionName string,
-	httpMethod string,
-	path string,
-	requestHeaders *map[string]string,
-	requestBody *[]byte,
-) (statusCode int, responseBody *[]byte, responseHeaders *map[string]string, err error) {
-	log.Debugf("invoking function %v with %v %v [body: %v bytes]", functionName, httpMethod, path, len(*requestBody))
+// decodeFunctionName decodes a function name prefixed with '@', which is
+// base64url encoded, so names and ARNs containing characters that cannot
+// appear cleanly in a path can be used. Other names are returned as-is.
+func decodeFunctionName(functionName string) (string, error) {
+	if !strings.HasPrefix(functionName, "@") {
+		return functionName, nil
+	}
+	encoded := strings.TrimRight(strings.TrimPrefix(functionName, "@"), "=")
+	decoded, err := b64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("invalid encoded function name %v - names prefixed with '@' must be base64url encoded: %v", functionName, err)
+	}
+	if len(decoded) == 0 || !utf8.Valid(decoded) || strings.ContainsAny(string(decoded), "/ ") {
+		return "", fmt.Errorf("invalid encoded function name: %v", functionName)
+	}
+	return string(decoded), nil
+}
 
-	// Create Lambda service client
-	sess := session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-	}))
+// parseFunctionName splits an optional version or alias from the function name.
+// The function may be specified by name ('name' or 'name:qualifier'), partial
+// ARN ('123456789012:function:name') or full ARN, each with an optional qualifier.
+//
+// If the function name has no qualifier, the value of the qualifier header
+// is used, if provided.
+func parseFunctionName(functionName string, headerQualifier string) (name string, qualifier string, err error) {
+	parts := strings.Split(functionName, ":")
+	name = functionName
 
-	client := lambda.New(sess, &aws.Config{Region: aws.String(region)})
+	switch {
+	case strings.HasPrefix(functionName, "arn:"):
+		if !arnPattern.MatchString(functionName) {
+			return "", "", fmt.Errorf("invalid function ARN: %v", functionName)
+		}
+		if len(parts) == 8 {
+			name, qualifier = strings.Join(parts[:7], ":"), parts[7]
+		}
+	case len(parts) >= 3 && parts[1] == "function":
+		if len(parts) > 4 {
+			return "", "", fmt.Errorf("invalid partial function ARN: %v", functionName)
+		}
+		if len(parts) == 4 {
+			name, qualifier = strings.Join(parts[:3], ":"), parts[3]
+		}
+	case len(parts) == 2:
+		name, qualifier = parts[0], parts[1]
+	case len(parts) > 2:
+		return "", "", fmt.Errorf("invalid function name: %v", functionName)
+	}
 
-	encodedBody := b64.StdEncoding.EncodeToString(*requestBody)
-	request := events.APIGatewayProxyRequest{
-		HTTPMethod:      httpMethod,
-		Path:            path,
-		Headers:         *requestHeaders,
-		Body:            encodedBody,
-		IsBase64Encoded: true,
+	if qualifier == "" {
+		qualifier = headerQualifier
+	}
+	if qualifier != "" && !qualifierPattern.MatchString(qualifier) {
+		return "", "", fmt.Errorf("invalid function qualifier: %v", qualifier)
+	}
+	return name, qualifier, nil
+}
+
+// parseTimeout returns the invocation timeout requested in milliseconds,
+// which may not exceed the maximum. If none is requested, the timeout
+// for long poll routes is used, followed by the timeout configured for the
+// function, falling back to the default timeout.
+func parseTimeout(functionName string, requestPath string, headerTimeout string) (time.Duration, error) {
+	if headerTimeout == "" {
+		if isLongPollPath(requestPath) {
+			return longPollTimeout, nil
+		}
+		if functionConfig, exists := functionConfigs[functionName]; exists && functionConfig.Timeout.Duration > 0 {
+			return functionConfig.Timeout.Duration, nil
+		}
+		return invokeTimeout, nil
+	}
+	millis, err := strconv.ParseInt(headerTimeout, 10, 64)
+	if err != nil || millis <= 0 {
+		return 0, fmt.Errorf("invalid timeout: %v", headerTimeout)
 	}
+	timeout := time.Duration(millis) * time.Millisecond
+	if timeout > maxInvokeTimeout {
+		return 0, fmt.Errorf("timeout %v exceeds maximum of %v", timeout, maxInvokeTimeout)
+	}
+	return timeout, nil
+}
 
-	payload, err := json.Marshal(request)
+// readBody reads the request body, which is limited to the maximum body size.
+// The Invoke API requires the whole payload, so the body is buffered, but
+// bodies declared to be over the limit are rejected without being read.
+func readBody(req *http.Request) ([]byte, error) {
+	if req.ContentLength > maxBodySize {
+		return nil, fmt.Errorf("%w: limit is %v bytes", errBodyTooLarge, maxBodySize)
+	}
+	// the buffer grows as the body is read, so a large declared length is
+	// not allocated until the client sends that much
+	var buf bytes.Buffer
+	if req.ContentLength > maxInitialBodyBuffer {
+		buf.Grow(maxInitialBodyBuffer)
+	} else if req.ContentLength > 0 {
+		buf.Grow(int(req.ContentLength))
+	}
+	_, err := buf.ReadFrom(req.Body)
+	requestBody := buf.Bytes()
 	if err != nil {
-		return 0, nil, nil, fmt.Errorf("error marshalling request: %v", err)
+		// the body reader is limited, so a read that fails at the limit indicates it was exceeded
+		if int64(len(requestBody)) >= maxBodySize {
+			return nil, fmt.Errorf("%w: limit is %v bytes", errBodyTooLarge, maxBodySize)
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("%w: read %v of %v bytes", errContentLength, len(requestBody), req.ContentLength)
+		}
+		return nil, fmt.Errorf("error parsing request body: %v", err)
+	}
+	// a content length of -1 means it was not declared, such as for chunked requests
+	if req.ContentLength >= 0 && int64(len(requestBody)) != req.ContentLength {
+		return nil, fmt.Errorf("%w: read %v of %v bytes", errContentLength, len(requestBody), req.ContentLength)
+	}
+	return requestBody, nil
+}
+
+// isMethodAllowed checks whether the request method is one of the allowed
+// methods, where no allowed methods means all methods are allowed.
+func isMethodAllowed(method string, allowedMethods []string) bool {
+	if len(allowedMethods) == 0 {
+		return true
+	}
+	for _, allowed := range allowedMethods {
+		if method == allowed {
+			return true
+		}
 	}
+	return false
+}
+
+// stripPathPrefix removes the configured path prefix from the request path.
+func stripPathPrefix(requestPath string) (string, error) {
+	if pathPrefix == "" {
+		return requestPath, nil
+	}
+	if requestPath == pathPrefix {
+		return "/", nil
+	}
+	if !strings.HasPrefix(requestPath, pathPrefix+"/") {
+		return "", fmt.Errorf("%w: path must start with %v", errPathNotFound, pathPrefix)
+	}
+	return strings.TrimPrefix(requestPath, pathPrefix), nil
+}
 
-	result, err := client.Invoke(&lambda.InvokeInput{FunctionName: aws.String(functionName), Payload: payload})
+// normaliseTrailingSlash strips or adds a trailing slash to the path, if
+// configured. The root path is always "/".
+func normaliseTrailingSlash(path string) string {
+	switch trailingSlash {
+	case "strip":
+		if stripped := strings.TrimRight(path, "/"); stripped != "" {
+			return stripped
+		}
+		return "/"
+	case "add":
+		if !strings.HasSuffix(path, "/") {
+			return path + "/"
+		}
+	}
+	return path
+}
+
+// addForwardedHeaders sets the X-Forwarded-Proto and X-Forwarded-Port headers,
+// and appends the client IP address to X-Forwarded-For, so functions can
+// determine the original scheme and client. Existing scheme and port headers
+// are retained if the gateway is behind a trusted proxy.
+func addForwardedHeaders(req *http.Request, requestHeaders map[string]string, multiValueHeaders map[string][]string) {
+	remoteIP, _, err := net.SplitHostPort(req.RemoteAddr)
 	if err != nil {
-		return 0, nil, nil, fmt.Errorf("error calling %v: %v", functionName, err)
+		remoteIP = req.RemoteAddr
+	}
+	forwardedFor := remoteIP
+	if existing := req.Header.Values("X-Forwarded-For"); len(existing) > 0 {
+		forwardedFor = strings.Join(existing, ", ") + ", " + remoteIP
 	}
+	setRequestHeader(requestHeaders, multiValueHeaders, "X-Forwarded-For", forwardedFor)
 
-	var resp events.APIGatewayProxyResponse
+	if _, exists := multiValueHeaders["X-Forwarded-Proto"]; !exists || !trustProxy {
+		proto := "http"
+		if req.TLS != nil {
+			proto = "https"
+		}
+		setRequestHeader(requestHeaders, multiValueHeaders, "X-Forwarded-Proto", proto)
+	}
+	if _, exists := multiValueHeaders["X-Forwarded-Port"]; !exists || !trustProxy {
+		if localAddr, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+			if _, port, err := net.SplitHostPort(localAddr.String()); err == nil {
+				setRequestHeader(requestHeaders, multiValueHeaders, "X-Forwarded-Port", port)
+			}
+		}
+	}
+}
 
-	err = json.Unmarshal(result.Payload, &resp)
-	statusCode = resp.StatusCode
-	if err != nil || statusCode == 0 {
-		return statusCode, nil, nil, fmt.Errorf("error unmarshalling response: %v", err)
+// filterRequestHeaders removes the headers that are not forwarded to the
+// function. If an allowlist is configured, only those headers are forwarded,
+// and any headers in the denylist are then removed.
+func filterRequestHeaders(requestHeaders map[string]string, multiValueHeaders map[string][]string) {
+	if len(forwardHeaders) == 0 && len(dropHeaders) == 0 {
+		return
+	}
+	for name := range multiValueHeaders {
+		if (len(forwardHeaders) > 0 && !forwardHeaders[name]) || dropHeaders[name] {
+			delete(requestHeaders, name)
+			delete(multiValueHeaders, name)
+		}
 	}
+}
 
-	var respBody []byte
-	if resp.IsBase64Encoded {
-		respBody, err = b64.StdEncoding.DecodeString(resp.Body)
-		if err != nil {
-			return statusCode, nil, nil, fmt.Errorf("error decoding body %v: %v", resp.Body, err)
+// setRequestHeader replaces the value of the header forwarded to the function.
+func setRequestHeader(requestHeaders map[string]string, multiValueHeaders map[string][]string, name string, value string) {
+	requestHeaders[name] = value
+	multiValueHeaders[name] = []string{value}
+}
+
+// toHeaderSet returns the set of canonical header names.
+func toHeaderSet(names []string) map[string]bool {
+	headers := make(map[string]bool, len(names))
+	for _, name := range names {
+		headers[textproto.CanonicalMIMEHeaderKey(name)] = true
+	}
+	return headers
+}
+
+// getSourceIP returns the IP address of the client. If the gateway is behind
+// trusted proxies, the client address is taken from X-Forwarded-For. Entries
+// are read from the right, as those to the left of the address appended by
+// the furthest trusted proxy are supplied by the client, so can be spoofed.
+func getSourceIP(req *http.Request) string {
+	if trustProxy {
+		var entries []string
+		for _, value := range req.Header.Values("X-Forwarded-For") {
+			for _, entry := range strings.Split(value, ",") {
+				if entry = strings.TrimSpace(entry); entry != "" {
+					entries = append(entries, entry)
+				}
+			}
 		}
-	} else {
-		respBody = []byte(resp.Body)
+		if len(entries) >= trustedProxyHops {
+			return entries[len(entries)-trustedProxyHops]
+		} else if len(entries) > 0 {
+			return entries[0]
+		}
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
 	}
+	return host
+}
 
-	log.Debugf("received response from function %v [code: %v, body: %v bytes]", functionName, statusCode, len(respBody))
-	return statusCode, &respBody, &resp.Headers, nil
+// resolveRegion determines the region of the function. A full function ARN
+// identifies its region, which the region header must match if it is set.
+// Otherwise the region header is used, falling back to the configured region.
+func resolveRegion(functionName string, headerRegion string) (string, error) {
+	if headerRegion != "" && !regionPattern.MatchString(headerRegion) {
+		return "", fmt.Errorf("invalid region: %v", headerRegion)
+	}
+	if strings.HasPrefix(functionName, "arn:") {
+		parts := strings.Split(functionName, ":")
+		if len(parts) < 4 || !regionPattern.MatchString(parts[3]) {
+			return "", fmt.Errorf("invalid function ARN region: %v", functionName)
+		}
+		if headerRegion != "" && headerRegion != parts[3] {
+			return "", fmt.Errorf("region %v does not match function region %v", headerRegion, parts[3])
+		}
+		return parts[3], nil
+	}
+	if headerRegion != "" {
+		return headerRegion, nil
+	}
+	return region, nil
 }
 
-func sendResponse(log *logrus.Entry, w http.ResponseWriter, headers *map[string]string, statusCode int, body *[]byte, client string) (err error) {
-	for responseHeaderKey, responseHeaderValue := range *headers {
-		w.Header().Add(responseHeaderKey, responseHeaderValue)
+func invoke(
+	ctx context.Context,
+	log *logrus.Entry,
+	client invoker,
+	proxyReq *proxyRequest,
+) (*proxyResponse, error) {
+	functionName := proxyReq.FunctionName
+	log.WithFields(logrus.Fields{
+		"functionName": functionName,
+		"requestBytes": len(proxyReq.Body),
+	}).Debugf("invoking function %v with %v %v", functionName, proxyReq.HTTPMethod, proxyReq.Path)
+
+	payload, err := marshalRequest(proxyReq)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling request: %v", err)
+	}
+
+	if dryRun {
+		log.Infof("dry run - skipping invocation of %v with payload: %s", functionName, payload)
+		return &proxyResponse{
+			StatusCode: http.StatusOK,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       payload,
+		}, nil
+	}
+
+	// the invocation is abandoned if the client disconnects or the timeout elapses
+	ctx, cancel := context.WithTimeout(ctx, proxyReq.Timeout)
+	defer cancel()
+
+	input := &lambda.InvokeInput{
+		FunctionName:   aws.String(functionName),
+		InvocationType: aws.String(proxyReq.InvocationType),
+		Payload:        payload,
+	}
+	if proxyReq.Qualifier != "" {
+		input.Qualifier = aws.String(proxyReq.Qualifier)
 	}
-	w.WriteHeader(statusCode)
-	_, err = w.Write(*body)
+	if (debugTiming || debugLogs) && proxyReq.InvocationType == lambda.InvocationTypeRequestResponse {
+		// the log tail includes the billed duration, and any error output
+		input.LogType = aws.String(lambda.LogTypeTail)
+	}
+	invokeStart := time.Now()
+	result, err := invokeWithRetry(ctx, log, client, input)
+	invokeDuration := time.Since(invokeStart)
+	stats.RecordInvocation(functionName, invokeDuration, err)
 	if err != nil {
-		return fmt.Errorf("error writing response: %v", err)
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("error calling %v: %w", functionName, ctx.Err())
+		}
+		return nil, fmt.Errorf("error calling %v: %w", functionName, err)
+	}
+
+	if proxyReq.InvocationType == lambda.InvocationTypeEvent {
+		// asynchronous invocations have no function response
+		log.Debugf("queued asynchronous invocation of function %v", functionName)
+		return &proxyResponse{StatusCode: http.StatusAccepted}, nil
+	}
+
+	if result.FunctionError != nil {
+		log.Errorf("function %v returned %v error: %s", functionName, *result.FunctionError, result.Payload)
+		logTail := decodeLogTail(result.LogResult)
+		if debugLogs && logTail != "" {
+			log.Errorf("function %v log tail:\n%v", functionName, logTail)
+		}
+		return nil, &functionError{functionName: functionName, errorType: *result.FunctionError, logTail: logTail}
+	}
+
+	resp, err := unmarshalResponse(result.Payload, proxyReq.RawResponse, proxyReq.StreamResponse)
+	if err != nil {
+		return nil, err
+	}
+	if maxResponseSize > 0 && int64(resp.bodyLen()) > maxResponseSize {
+		return nil, fmt.Errorf("%w: %v body is %v bytes, limit is %v bytes", errResponseTooLarge, functionName, resp.bodyLen(), maxResponseSize)
+	}
+	if debugTiming {
+		addTimingHeaders(resp, invokeDuration, result.LogResult)
+	}
+	if exposeExecutedVersion && result.ExecutedVersion != nil {
+		// identifies the version that served the request, such as when invoking an alias
+		resp.setHeader(executedVersionHeader, *result.ExecutedVersion)
 	}
 
-	log.Debugf("wrote response [code: %v, body %v bytes] to client %v", statusCode, len(*body), client)
-	return nil
+	log.WithFields(logrus.Fields{
+		"functionName":  functionName,
+		"statusCode":    resp.StatusCode,
+		"responseBytes": resp.bodyLen(),
+	}).Debugf("received response from function %v", functionName)
+	return resp, nil
 }
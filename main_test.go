@@ -0,0 +1,1693 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	b64 "encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"golang.org/x/net/http2"
+	"io"
+	"lambdahttpgw/config"
+	"lambdahttpgw/routing"
+	"lambdahttpgw/stats"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	stats.Init()
+	os.Exit(m.Run())
+}
+
+// serve handles the request with the gateway handler, invoking functions
+// with the clients.
+func serve(clients *clientCache, req *http.Request) *httptest.ResponseRecorder {
+	setReady()
+	w := httptest.NewRecorder()
+	handler(w, req, clients)
+	return w
+}
+
+// setString sets the variable to the value for the duration of the test.
+func setString(t testing.TB, variable *string, value string) {
+	previous := *variable
+	*variable = value
+	t.Cleanup(func() { *variable = previous })
+}
+
+func setBool(t testing.TB, variable *bool, value bool) {
+	previous := *variable
+	*variable = value
+	t.Cleanup(func() { *variable = previous })
+}
+
+func setInt(t testing.TB, variable *int, value int) {
+	previous := *variable
+	*variable = value
+	t.Cleanup(func() { *variable = previous })
+}
+
+func setInt64(t testing.TB, variable *int64, value int64) {
+	previous := *variable
+	*variable = value
+	t.Cleanup(func() { *variable = previous })
+}
+
+func setDuration(t testing.TB, variable *time.Duration, value time.Duration) {
+	previous := *variable
+	*variable = value
+	t.Cleanup(func() { *variable = previous })
+}
+
+func TestHandlerInvokesFunction(t *testing.T) {
+	fake := &fakeInvoker{respond: respondWith(proxyOutput(http.StatusCreated, map[string]string{"X-Result": "ok"}, `{"id":1}`), nil)}
+	req := httptest.NewRequest(http.MethodPost, "/orders-fn/orders?express=true", strings.NewReader(`{"item":"book"}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := serve(newFakeClients(fake), req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected function status code 201, got %v: %s", w.Code, w.Body)
+	}
+	if result := w.Header().Get("X-Result"); result != "ok" {
+		t.Errorf("expected function header, got %q", result)
+	}
+	if body := w.Body.String(); body != `{"id":1}` {
+		t.Errorf("expected function body, got %q", body)
+	}
+
+	inputs := fake.invocations()
+	if len(inputs) != 1 {
+		t.Fatalf("expected 1 invocation, got %v", len(inputs))
+	}
+	if name := aws.StringValue(inputs[0].FunctionName); name != "orders-fn" {
+		t.Errorf("expected orders-fn to be invoked, got %v", name)
+	}
+	if invocationType := aws.StringValue(inputs[0].InvocationType); invocationType != lambda.InvocationTypeRequestResponse {
+		t.Errorf("expected synchronous invocation, got %v", invocationType)
+	}
+	event := fake.lastEvent(t)
+	if event.HTTPMethod != http.MethodPost || event.Path != "/orders" || event.Body != `{"item":"book"}` {
+		t.Errorf("unexpected event: %v %v %q", event.HTTPMethod, event.Path, event.Body)
+	}
+	if express := event.QueryStringParameters["express"]; express != "true" {
+		t.Errorf("expected query parameter in event, got %q", express)
+	}
+}
+
+func TestInvokeErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		output     *lambda.InvokeOutput
+		err        error
+		statusCode int
+	}{
+		{name: "success", output: proxyOutput(http.StatusOK, nil, "ok"), statusCode: http.StatusOK},
+		{name: "invalid payload", output: &lambda.InvokeOutput{Payload: []byte("not json")}, statusCode: http.StatusBadGateway},
+		{name: "function error", output: &lambda.InvokeOutput{FunctionError: aws.String("Unhandled"), Payload: []byte(`{"errorMessage":"boom"}`)}, statusCode: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeInvoker{respond: respondWith(tt.output, tt.err)}
+			w := serve(newFakeClients(fake), httptest.NewRequest(http.MethodGet, "/fn/", nil))
+			if w.Code != tt.statusCode {
+				t.Errorf("expected %v, got %v: %s", tt.statusCode, w.Code, w.Body)
+			}
+		})
+	}
+}
+
+// BenchmarkHandler compares a client shared across requests with creating
+// the client cache, and so the session and client, for every request. The
+// SDK clients invoke a local endpoint, so the cost of client construction
+// and connection setup is included.
+func BenchmarkHandler(b *testing.B) {
+	b.Run("fake", func(b *testing.B) {
+		clients := newFakeClients(&fakeInvoker{})
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				serve(clients, httptest.NewRequest(http.MethodGet, "/fn/", nil))
+			}
+		})
+	})
+
+	b.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	b.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"statusCode":200,"body":"ok"}`))
+	}))
+	defer endpoint.Close()
+	setString(b, &awsEndpoint, endpoint.URL)
+	setString(b, &lambdaVPCEndpoint, "")
+
+	newClients := map[string]func(shared *clientCache) *clientCache{
+		"shared":      func(shared *clientCache) *clientCache { return shared },
+		"per request": func(*clientCache) *clientCache { return newClientCache() },
+	}
+	for name, clientsFor := range newClients {
+		b.Run(name, func(b *testing.B) {
+			shared := newClientCache()
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if w := serve(clientsFor(shared), httptest.NewRequest(http.MethodGet, "/fn/", nil)); w.Code != http.StatusOK {
+						b.Errorf("expected 200, got %v: %s", w.Code, w.Body)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	setInt64(t, &maxBodySize, 10)
+	tests := []struct {
+		name       string
+		header     string
+		value      string
+		body       string
+		err        error
+		statusCode int
+	}{
+		{name: "invalid request", header: timeoutHeader, value: "soon", statusCode: http.StatusBadRequest},
+		{name: "function not found", err: awserr.New(lambda.ErrCodeResourceNotFoundException, "not found", nil), statusCode: http.StatusNotFound},
+		{name: "body too large", body: "0123456789a", statusCode: http.StatusRequestEntityTooLarge},
+		{name: "invocation failure", err: errors.New("connection refused"), statusCode: http.StatusBadGateway},
+		{name: "timeout", err: context.DeadlineExceeded, statusCode: http.StatusGatewayTimeout},
+	}
+	for _, format := range []string{"json", "plain"} {
+		for _, tt := range tests {
+			t.Run(format+" "+tt.name, func(t *testing.T) {
+				setString(t, &errorFormat, format)
+				fake := &fakeInvoker{respond: respondWith(nil, tt.err)}
+				req := httptest.NewRequest(http.MethodPost, "/fn/", strings.NewReader(tt.body))
+				req.Header.Set(requestIdHeader, "req-123")
+				if tt.header != "" {
+					req.Header.Set(tt.header, tt.value)
+				}
+
+				w := serve(newFakeClients(fake), req)
+
+				if w.Code != tt.statusCode {
+					t.Fatalf("expected %v, got %v: %s", tt.statusCode, w.Code, w.Body)
+				}
+				if format == "plain" {
+					if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+						t.Errorf("expected a plain text error, got %v", contentType)
+					}
+					if message := strings.TrimSpace(w.Body.String()); message == "" || strings.HasPrefix(message, "{") {
+						t.Errorf("expected a plain text message, got %q", message)
+					}
+					return
+				}
+				var body errorResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+					t.Fatalf("expected JSON error body, got %q: %v", w.Body, err)
+				}
+				if body.RequestID != "req-123" || body.Status != tt.statusCode || body.Error == "" {
+					t.Errorf("unexpected error body: %+v", body)
+				}
+			})
+		}
+	}
+}
+
+func TestMultiValueRequestHeaders(t *testing.T) {
+	fake := &fakeInvoker{}
+	req := httptest.NewRequest(http.MethodGet, "/fn/", nil)
+	req.Header.Add("Accept", "text/html")
+	req.Header.Add("Accept", "application/json")
+
+	serve(newFakeClients(fake), req)
+
+	event := fake.lastEvent(t)
+	if accept := event.MultiValueHeaders["Accept"]; len(accept) != 2 || accept[0] != "text/html" || accept[1] != "application/json" {
+		t.Errorf("expected both Accept values, got %v", accept)
+	}
+	if accept := event.Headers["Accept"]; accept != "text/html" {
+		t.Errorf("expected the first Accept value in single-value headers, got %q", accept)
+	}
+}
+
+func TestQueryStringParameters(t *testing.T) {
+	fake := &fakeInvoker{}
+
+	serve(newFakeClients(fake), httptest.NewRequest(http.MethodGet, "/fn/?a=1&a=2&b=3", nil))
+
+	event := fake.lastEvent(t)
+	if !reflect.DeepEqual(event.QueryStringParameters, map[string]string{"a": "1", "b": "3"}) {
+		t.Errorf("unexpected query string parameters: %v", event.QueryStringParameters)
+	}
+	expected := map[string][]string{"a": {"1", "2"}, "b": {"3"}}
+	if !reflect.DeepEqual(event.MultiValueQueryStringParameters, expected) {
+		t.Errorf("unexpected multi-value query string parameters: %v", event.MultiValueQueryStringParameters)
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	fake := &fakeInvoker{}
+	clients := newFakeClients(fake)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", healthHandler)
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		handler(w, req, clients)
+	})
+
+	tests := []struct {
+		name       string
+		ready      int32
+		statusCode int
+		body       string
+	}{
+		{name: "starting", ready: 0, statusCode: http.StatusServiceUnavailable, body: `{"status":"starting"}`},
+		{name: "ready", ready: 1, statusCode: http.StatusOK, body: `{"status":"ok"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := atomic.LoadInt32(&ready)
+			atomic.StoreInt32(&ready, tt.ready)
+			t.Cleanup(func() { atomic.StoreInt32(&ready, previous) })
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+			if w.Code != tt.statusCode || w.Body.String() != tt.body {
+				t.Errorf("expected %v %v, got %v %v", tt.statusCode, tt.body, w.Code, w.Body)
+			}
+		})
+	}
+	if invocations := len(fake.invocations()); invocations != 0 {
+		t.Errorf("expected no invocations, got %v", invocations)
+	}
+}
+
+// blockUntilDone returns a respond function that blocks until the invocation
+// is abandoned, signalling once the invocation has started.
+func blockUntilDone(started chan<- struct{}) func(aws.Context, *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+	return func(ctx aws.Context, _ *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+		if started != nil {
+			close(started)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(5 * time.Second):
+			return proxyOutput(http.StatusOK, nil, ""), nil
+		}
+	}
+}
+
+func TestInvokeTimeout(t *testing.T) {
+	setDuration(t, &invokeTimeout, 20*time.Millisecond)
+	fake := &fakeInvoker{respond: blockUntilDone(nil)}
+
+	w := serve(newFakeClients(fake), httptest.NewRequest(http.MethodGet, "/fn/", nil))
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected 504, got %v: %s", w.Code, w.Body)
+	}
+}
+
+func TestInvokeAbandonedOnCancel(t *testing.T) {
+	started := make(chan struct{})
+	fake := &fakeInvoker{respond: blockUntilDone(started)}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	proxyReq := &proxyRequest{
+		FunctionName:   "fn",
+		InvocationType: lambda.InvocationTypeRequestResponse,
+		Timeout:        time.Minute,
+		HTTPMethod:     http.MethodGet,
+		Path:           "/",
+	}
+	_, err := invoke(ctx, logrus.NewEntry(logrus.StandardLogger()), fake, proxyReq)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	// registered first, so the signal does not terminate the test process
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	started := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		_, _ = w.Write([]byte("done"))
+	})}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = server.Serve(listener) }()
+
+	type result struct {
+		body string
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		results <- result{body: string(body), err: err}
+	}()
+	<-started
+
+	stopped := make(chan struct{})
+	go func() {
+		waitForShutdown(server)
+		close(stopped)
+	}()
+	// resent until received, as the shutdown handler may not yet be registered
+	for done := false; !done; {
+		if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-stopped:
+			done = true
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	r := <-results
+	if r.err != nil || r.body != "done" {
+		t.Errorf("expected in-flight request to complete, got %q: %v", r.body, r.err)
+	}
+}
+
+func TestJSONLogFormat(t *testing.T) {
+	logger := logrus.StandardLogger()
+	previousFormatter, previousOut := logger.Formatter, logger.Out
+	t.Cleanup(func() {
+		logger.SetFormatter(previousFormatter)
+		logger.SetOutput(previousOut)
+	})
+	var output bytes.Buffer
+	logger.SetOutput(&output)
+	setLogFormat("json")
+
+	req := httptest.NewRequest(http.MethodGet, "/fn/", nil)
+	req.Header.Set(requestIdHeader, "req-123")
+	serve(newFakeClients(&fakeInvoker{}), req)
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) == 0 || lines[0] == "" {
+		t.Fatal("expected log output")
+	}
+	for _, line := range lines {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("expected JSON log entry, got %q: %v", line, err)
+		}
+		if entry["requestId"] != "req-123" {
+			t.Errorf("expected requestId field, got %v", entry)
+		}
+	}
+}
+
+func TestMaxBodySize(t *testing.T) {
+	setInt64(t, &maxBodySize, 10)
+	tests := []struct {
+		name          string
+		body          string
+		contentLength int64
+		statusCode    int
+	}{
+		{name: "within limit", body: "0123456789", contentLength: 10, statusCode: http.StatusOK},
+		{name: "declared over limit", body: "0123456789a", contentLength: 11, statusCode: http.StatusRequestEntityTooLarge},
+		{name: "chunked over limit", body: "0123456789a", contentLength: -1, statusCode: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeInvoker{}
+			req := httptest.NewRequest(http.MethodPost, "/fn/", strings.NewReader(tt.body))
+			req.ContentLength = tt.contentLength
+
+			w := serve(newFakeClients(fake), req)
+
+			if w.Code != tt.statusCode {
+				t.Errorf("expected %v, got %v: %s", tt.statusCode, w.Code, w.Body)
+			}
+			if invoked := len(fake.invocations()) > 0; invoked != (tt.statusCode == http.StatusOK) {
+				t.Errorf("expected invoked to be %v", !invoked)
+			}
+		})
+	}
+}
+
+// countingReader returns n zero bytes, recording how many were read.
+type countingReader struct {
+	n    int64
+	read int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	if r.read >= r.n {
+		return 0, io.EOF
+	}
+	if remaining := r.n - r.read; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	for i := range p {
+		p[i] = 0
+	}
+	r.read += int64(len(p))
+	return len(p), nil
+}
+
+func TestStreamingBodyIsBounded(t *testing.T) {
+	const limit = 1 << 20
+	setInt64(t, &maxBodySize, limit)
+	tests := []struct {
+		name       string
+		size       int64
+		statusCode int
+	}{
+		{name: "within limit", size: limit, statusCode: http.StatusOK},
+		{name: "over limit", size: 256 * limit, statusCode: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := newFakeClients(&fakeInvoker{})
+			body := &countingReader{n: tt.size}
+			req := httptest.NewRequest(http.MethodPost, "/fn/", body)
+			req.ContentLength = -1
+			req.Header.Set("Content-Type", "application/octet-stream")
+
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			w := serve(clients, req)
+			runtime.ReadMemStats(&after)
+
+			if w.Code != tt.statusCode {
+				t.Fatalf("expected %v, got %v: %s", tt.statusCode, w.Code, w.Body)
+			}
+			// the reader is limited, so at most one read beyond the limit is made
+			if body.read > limit+64<<10 {
+				t.Errorf("expected at most %v bytes to be read, read %v", limit, body.read)
+			}
+			// the rejected stream is far larger than the limit, so allocations must not grow with it
+			if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 16*limit {
+				t.Errorf("expected allocations to be bounded by the limit, allocated %v bytes", allocated)
+			}
+		})
+	}
+}
+
+func TestDeclaredBodyLengthIsNotAllocated(t *testing.T) {
+	const limit = 16 << 20
+	setInt64(t, &maxBodySize, limit)
+	clients := newFakeClients(&fakeInvoker{})
+	req := httptest.NewRequest(http.MethodPost, "/fn/", strings.NewReader("short"))
+	req.ContentLength = limit
+	req.Header.Set("Content-Type", "text/plain")
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	w := serve(clients, req)
+	runtime.ReadMemStats(&after)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %v: %s", w.Code, w.Body)
+	}
+	// the client declares the length, so it must not be allocated before the body is sent
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > limit/4 {
+		t.Errorf("expected allocations to be bounded by the body read, allocated %v bytes", allocated)
+	}
+}
+
+func TestFunctionError(t *testing.T) {
+	fake := &fakeInvoker{respond: respondWith(&lambda.InvokeOutput{
+		StatusCode:    aws.Int64(http.StatusOK),
+		FunctionError: aws.String("Unhandled"),
+		Payload:       []byte(`{"errorMessage":"secret detail","errorType":"Error"}`),
+	}, nil)}
+
+	w := serve(newFakeClients(fake), httptest.NewRequest(http.MethodGet, "/fn/", nil))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %v: %s", w.Code, w.Body)
+	}
+	var body errorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error != errFunctionError.Error() {
+		t.Errorf("expected function error message, got %q", body.Error)
+	}
+	if strings.Contains(w.Body.String(), "secret detail") {
+		t.Errorf("expected the error payload not to be returned to the client: %s", w.Body)
+	}
+}
+
+// writeSelfSignedCert writes a certificate and key for 127.0.0.1 to the
+// directory, returning their paths and the certificate.
+func writeSelfSignedCert(t *testing.T, dir string) (certFile string, keyFile string, cert *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, cert
+}
+
+func TestListenAndServeTLS(t *testing.T) {
+	certFile, keyFile, cert := writeSelfSignedCert(t, t.TempDir())
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	_ = listener.Close()
+
+	server := &http.Server{Addr: addr, Handler: http.HandlerFunc(statusHandler)}
+	go func() { _ = listenAndServe(server, certFile, keyFile) }()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	var resp *http.Response
+	for attempt := 0; attempt < 50; attempt++ {
+		if resp, err = client.Get("https://" + addr + "/system/status"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("expected HTTPS round trip: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.TLS == nil {
+		t.Errorf("expected 200 over TLS, got %v", resp.StatusCode)
+	}
+}
+
+func TestRequestIdHeader(t *testing.T) {
+	tests := []struct {
+		name       string
+		suppliedId string
+		err        error
+		statusCode int
+	}{
+		{name: "generated on success", statusCode: http.StatusOK},
+		{name: "generated on error", err: errors.New("connection refused"), statusCode: http.StatusBadGateway},
+		{name: "supplied on success", suppliedId: "req-123", statusCode: http.StatusOK},
+		{name: "supplied on error", suppliedId: "req-123", err: errors.New("connection refused"), statusCode: http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeInvoker{}
+			if tt.err != nil {
+				fake.respond = respondWith(nil, tt.err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/fn/", nil)
+			if tt.suppliedId != "" {
+				req.Header.Set(requestIdHeader, tt.suppliedId)
+			}
+
+			w := serve(newFakeClients(fake), req)
+
+			if w.Code != tt.statusCode {
+				t.Fatalf("expected %v, got %v", tt.statusCode, w.Code)
+			}
+			requestId := w.Header().Get(requestIdHeader)
+			if tt.suppliedId != "" && requestId != tt.suppliedId {
+				t.Errorf("expected supplied request ID, got %q", requestId)
+			} else if requestId == "" {
+				t.Error("expected a generated request ID")
+			}
+			if len(fake.invocations()) > 0 {
+				if eventId := fake.lastEvent(t).RequestContext.RequestID; eventId != requestId {
+					t.Errorf("expected the same request ID in the event, got %q", eventId)
+				}
+			}
+		})
+	}
+}
+
+func TestQualifiedInvocation(t *testing.T) {
+	tests := []struct {
+		name            string
+		path            string
+		headerQualifier string
+		statusCode      int
+		qualifier       string
+	}{
+		{name: "no qualifier", path: "/fn/", statusCode: http.StatusOK},
+		{name: "path qualifier", path: "/fn:live/", statusCode: http.StatusOK, qualifier: "live"},
+		{name: "header qualifier", path: "/fn/", headerQualifier: "7", statusCode: http.StatusOK, qualifier: "7"},
+		{name: "path takes precedence", path: "/fn:live/", headerQualifier: "7", statusCode: http.StatusOK, qualifier: "live"},
+		{name: "invalid qualifier", path: "/fn/", headerQualifier: "not.valid", statusCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeInvoker{}
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.headerQualifier != "" {
+				req.Header.Set(qualifierHeader, tt.headerQualifier)
+			}
+
+			w := serve(newFakeClients(fake), req)
+
+			if w.Code != tt.statusCode {
+				t.Fatalf("expected %v, got %v: %s", tt.statusCode, w.Code, w.Body)
+			}
+			if tt.statusCode != http.StatusOK {
+				return
+			}
+			input := fake.invocations()[0]
+			if name := aws.StringValue(input.FunctionName); name != "fn" {
+				t.Errorf("expected function name without qualifier, got %v", name)
+			}
+			if qualifier := aws.StringValue(input.Qualifier); qualifier != tt.qualifier {
+				t.Errorf("expected qualifier %q, got %q", tt.qualifier, qualifier)
+			}
+		})
+	}
+}
+
+func TestInvokeByArn(t *testing.T) {
+	tests := []struct {
+		name         string
+		function     string
+		statusCode   int
+		functionName string
+		qualifier    string
+		region       string
+	}{
+		{name: "name", function: "fn", statusCode: http.StatusOK, functionName: "fn", region: region},
+		{name: "partial ARN", function: "123456789012:function:fn", statusCode: http.StatusOK, functionName: "123456789012:function:fn", region: region},
+		{
+			name:         "full ARN",
+			function:     "arn:aws:lambda:us-west-2:123456789012:function:fn",
+			statusCode:   http.StatusOK,
+			functionName: "arn:aws:lambda:us-west-2:123456789012:function:fn",
+			region:       "us-west-2",
+		},
+		{
+			name:         "full ARN with qualifier",
+			function:     "arn:aws:lambda:us-west-2:123456789012:function:fn:live",
+			statusCode:   http.StatusOK,
+			functionName: "arn:aws:lambda:us-west-2:123456789012:function:fn",
+			qualifier:    "live",
+			region:       "us-west-2",
+		},
+		{name: "invalid ARN", function: "arn:aws:s3:::bucket", statusCode: http.StatusBadRequest},
+		{name: "truncated ARN", function: "arn:x", statusCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeInvoker{}
+			req := httptest.NewRequest(http.MethodGet, "/items", nil)
+			req.Header.Set(functionHeader, tt.function)
+
+			w := serve(newFakeClients(fake), req)
+
+			if w.Code != tt.statusCode {
+				t.Fatalf("expected %v, got %v: %s", tt.statusCode, w.Code, w.Body)
+			}
+			if tt.statusCode != http.StatusOK {
+				return
+			}
+			input := fake.invocations()[0]
+			if functionName := aws.StringValue(input.FunctionName); functionName != tt.functionName {
+				t.Errorf("expected function %v, got %v", tt.functionName, functionName)
+			}
+			if qualifier := aws.StringValue(input.Qualifier); qualifier != tt.qualifier {
+				t.Errorf("expected qualifier %q, got %q", tt.qualifier, qualifier)
+			}
+			if clientRegion := aws.StringValue(fake.configs[0].Region); clientRegion != tt.region {
+				t.Errorf("expected client for %v, got %v", tt.region, clientRegion)
+			}
+			if path := fake.lastEvent(t).Path; path != "/items" {
+				t.Errorf("expected the full path, got %v", path)
+			}
+		})
+	}
+}
+
+func TestResolveRegion(t *testing.T) {
+	arn := "arn:aws:lambda:us-west-2:123456789012:function:fn"
+	tests := []struct {
+		name         string
+		functionName string
+		headerRegion string
+		region       string
+		wantErr      bool
+	}{
+		{name: "name", functionName: "fn", region: region},
+		{name: "name with region header", functionName: "fn", headerRegion: "ap-southeast-2", region: "ap-southeast-2"},
+		{name: "invalid region header", functionName: "fn", headerRegion: "not a region", wantErr: true},
+		{name: "ARN", functionName: arn, region: "us-west-2"},
+		{name: "ARN with matching region header", functionName: arn, headerRegion: "us-west-2", region: "us-west-2"},
+		{name: "ARN with conflicting region header", functionName: arn, headerRegion: "eu-west-1", wantErr: true},
+		{name: "ARN without region", functionName: "arn:x", wantErr: true},
+		{name: "ARN with invalid region", functionName: "arn:aws:lambda:/:123456789012:function:fn", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			functionRegion, err := resolveRegion(tt.functionName, tt.headerRegion)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error to be %v, got %v", tt.wantErr, err)
+			}
+			if functionRegion != tt.region {
+				t.Errorf("expected region %q, got %q", tt.region, functionRegion)
+			}
+		})
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	setBool(t, &dryRun, true)
+	fake := &fakeInvoker{}
+	req := httptest.NewRequest(http.MethodPost, "/fn/items?a=1", strings.NewReader(`{"a":1}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := serve(newFakeClients(fake), req)
+
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected 200 JSON, got %v %v", w.Code, w.Header().Get("Content-Type"))
+	}
+	if invocations := len(fake.invocations()); invocations != 0 {
+		t.Errorf("expected no invocations, got %v", invocations)
+	}
+	var event events.APIGatewayProxyRequest
+	if err := json.Unmarshal(w.Body.Bytes(), &event); err != nil {
+		t.Fatalf("expected the event as the response body: %v", err)
+	}
+	if event.HTTPMethod != http.MethodPost || event.Path != "/items" || event.Body != `{"a":1}` || event.QueryStringParameters["a"] != "1" {
+		t.Errorf("unexpected echoed event: %+v", event)
+	}
+}
+
+func TestInvocationType(t *testing.T) {
+	tests := []struct {
+		name           string
+		invocationType string
+		statusCode     int
+		body           string
+	}{
+		{name: "default", statusCode: http.StatusOK, body: "ok"},
+		{name: "synchronous", invocationType: lambda.InvocationTypeRequestResponse, statusCode: http.StatusOK, body: "ok"},
+		{name: "event", invocationType: lambda.InvocationTypeEvent, statusCode: http.StatusAccepted},
+		{name: "invalid", invocationType: lambda.InvocationTypeDryRun, statusCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeInvoker{respond: func(_ aws.Context, input *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+				if aws.StringValue(input.InvocationType) == lambda.InvocationTypeEvent {
+					return &lambda.InvokeOutput{StatusCode: aws.Int64(http.StatusAccepted)}, nil
+				}
+				return proxyOutput(http.StatusOK, nil, "ok"), nil
+			}}
+			req := httptest.NewRequest(http.MethodPost, "/fn/", nil)
+			if tt.invocationType != "" {
+				req.Header.Set(invocationTypeHeader, tt.invocationType)
+			}
+
+			w := serve(newFakeClients(fake), req)
+
+			if w.Code != tt.statusCode || (tt.body != "" && w.Body.String() != tt.body) {
+				t.Errorf("expected %v %q, got %v %q", tt.statusCode, tt.body, w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestFunctionNameInPath(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		statusCode int
+		eventPath  string
+	}{
+		{name: "no function", path: "/", statusCode: http.StatusBadRequest},
+		{name: "no trailing path", path: "/fn", statusCode: http.StatusOK, eventPath: "/"},
+		{name: "trailing path", path: "/fn/path", statusCode: http.StatusOK, eventPath: "/path"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeInvoker{}
+
+			w := serve(newFakeClients(fake), httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if w.Code != tt.statusCode {
+				t.Fatalf("expected %v, got %v: %s", tt.statusCode, w.Code, w.Body)
+			}
+			if tt.eventPath != "" {
+				if path := fake.lastEvent(t).Path; path != tt.eventPath {
+					t.Errorf("expected function path %v, got %v", tt.eventPath, path)
+				}
+			}
+		})
+	}
+}
+
+func TestFunctionWithoutTrailingPath(t *testing.T) {
+	fake := &fakeInvoker{}
+
+	w := serve(newFakeClients(fake), httptest.NewRequest(http.MethodGet, "/myfunction", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %v: %s", w.Code, w.Body)
+	}
+	if name := aws.StringValue(fake.invocations()[0].FunctionName); name != "myfunction" {
+		t.Errorf("expected myfunction to be invoked, got %v", name)
+	}
+	if path := fake.lastEvent(t).Path; path != "/" {
+		t.Errorf("expected the root path, got %v", path)
+	}
+}
+
+func TestEncodedSlashesPreserved(t *testing.T) {
+	fake := &fakeInvoker{}
+
+	serve(newFakeClients(fake), httptest.NewRequest(http.MethodGet, "/fn/a%2Fb/c", nil))
+
+	if path := fake.lastEvent(t).Path; path != "/a%2Fb/c" {
+		t.Errorf("expected the encoded path, got %v", path)
+	}
+}
+
+func TestHostRouting(t *testing.T) {
+	setBool(t, &hostRouting, true)
+	fake := &fakeInvoker{}
+	req := httptest.NewRequest(http.MethodGet, "http://users.api.example.com/accounts/1", nil)
+
+	serve(newFakeClients(fake), req)
+
+	if name := aws.StringValue(fake.invocations()[0].FunctionName); name != "users" {
+		t.Errorf("expected users to be invoked, got %v", name)
+	}
+	if path := fake.lastEvent(t).Path; path != "/accounts/1" {
+		t.Errorf("expected the full path, got %v", path)
+	}
+}
+
+func TestServerTimeouts(t *testing.T) {
+	tests := []struct {
+		name              string
+		env               map[string]string
+		readTimeout       time.Duration
+		readHeaderTimeout time.Duration
+		writeTimeout      time.Duration
+		idleTimeout       time.Duration
+	}{
+		{
+			name:              "defaults",
+			readTimeout:       60 * time.Second,
+			readHeaderTimeout: 10 * time.Second,
+			writeTimeout:      config.GetMaxInvokeTimeout() + 10*time.Second,
+			idleTimeout:       120 * time.Second,
+		},
+		{
+			name: "configured",
+			env: map[string]string{
+				"READ_TIMEOUT":        "5s",
+				"READ_HEADER_TIMEOUT": "2s",
+				"WRITE_TIMEOUT":       "30s",
+				"IDLE_TIMEOUT":        "1m",
+			},
+			readTimeout:       5 * time.Second,
+			readHeaderTimeout: 2 * time.Second,
+			writeTimeout:      30 * time.Second,
+			idleTimeout:       time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			server := newServer(":8080", http.DefaultServeMux)
+			if server.ReadTimeout != tt.readTimeout || server.ReadHeaderTimeout != tt.readHeaderTimeout ||
+				server.WriteTimeout != tt.writeTimeout || server.IdleTimeout != tt.idleTimeout {
+				t.Errorf("unexpected timeouts: read %v, read header %v, write %v, idle %v",
+					server.ReadTimeout, server.ReadHeaderTimeout, server.WriteTimeout, server.IdleTimeout)
+			}
+		})
+	}
+}
+
+func TestStructuredLogFields(t *testing.T) {
+	logger := logrus.StandardLogger()
+	hook := logtest.NewLocal(logger)
+	t.Cleanup(func() { logger.ReplaceHooks(make(logrus.LevelHooks)) })
+	fake := &fakeInvoker{respond: respondWith(proxyOutput(http.StatusCreated, nil, "created"), nil)}
+
+	serve(newFakeClients(fake), httptest.NewRequest(http.MethodPost, "/logged-fn/", strings.NewReader("hello")))
+
+	var entry *logrus.Entry
+	for _, e := range hook.AllEntries() {
+		if strings.HasPrefix(e.Message, "proxied request") {
+			entry = e
+		}
+	}
+	if entry == nil {
+		t.Fatal("expected a log entry for the proxied request")
+	}
+	expected := logrus.Fields{
+		"functionName":  "logged-fn",
+		"statusCode":    http.StatusCreated,
+		"requestBytes":  5,
+		"responseBytes": 7,
+	}
+	for name, value := range expected {
+		if entry.Data[name] != value {
+			t.Errorf("expected %v field to be %v, got %v", name, value, entry.Data[name])
+		}
+	}
+	for _, name := range []string{"durationMs", "requestId"} {
+		if _, exists := entry.Data[name]; !exists {
+			t.Errorf("expected %v field", name)
+		}
+	}
+}
+
+func TestPathPrefix(t *testing.T) {
+	setString(t, &pathPrefix, "/gateway")
+	tests := []struct {
+		name         string
+		path         string
+		statusCode   int
+		functionName string
+		eventPath    string
+	}{
+		{name: "prefixed", path: "/gateway/fn/items", statusCode: http.StatusOK, functionName: "fn", eventPath: "/items"},
+		{name: "not prefixed", path: "/fn/items", statusCode: http.StatusNotFound},
+		{name: "prefix not on a segment boundary", path: "/gatewayfn/items", statusCode: http.StatusNotFound},
+		{name: "prefix only", path: "/gateway", statusCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeInvoker{}
+
+			w := serve(newFakeClients(fake), httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if w.Code != tt.statusCode {
+				t.Fatalf("expected %v, got %v: %s", tt.statusCode, w.Code, w.Body)
+			}
+			if tt.statusCode != http.StatusOK {
+				return
+			}
+			if name := aws.StringValue(fake.invocations()[0].FunctionName); name != tt.functionName {
+				t.Errorf("expected %v to be invoked, got %v", tt.functionName, name)
+			}
+			if path := fake.lastEvent(t).Path; path != tt.eventPath {
+				t.Errorf("expected function path %v, got %v", tt.eventPath, path)
+			}
+		})
+	}
+}
+
+func TestContentLengthValidation(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		contentLength int64
+		statusCode    int
+	}{
+		{name: "matching", body: "hello", contentLength: 5, statusCode: http.StatusOK},
+		{name: "body too short", body: "hel", contentLength: 5, statusCode: http.StatusBadRequest},
+		{name: "missing", body: "hello", contentLength: -1, statusCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeInvoker{}
+			req := httptest.NewRequest(http.MethodPost, "/fn/", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "text/plain")
+			req.ContentLength = tt.contentLength
+
+			w := serve(newFakeClients(fake), req)
+
+			if w.Code != tt.statusCode {
+				t.Fatalf("expected %v, got %v: %s", tt.statusCode, w.Code, w.Body)
+			}
+			if tt.statusCode == http.StatusOK && fake.lastEvent(t).Body != tt.body {
+				t.Errorf("expected the body to be forwarded, got %q", fake.lastEvent(t).Body)
+			}
+		})
+	}
+}
+
+func TestH2C(t *testing.T) {
+	t.Setenv("ENABLE_H2C", "true")
+	fake := &fakeInvoker{respond: func(_ aws.Context, input *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+		var event events.APIGatewayProxyRequest
+		if err := json.Unmarshal(input.Payload, &event); err != nil {
+			return nil, err
+		}
+		return proxyOutput(http.StatusOK, nil, event.Body), nil
+	}}
+	clients := newFakeClients(fake)
+	setReady()
+	server := newServer("", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		handler(w, req, clients)
+	}))
+	testServer := httptest.NewServer(server.Handler)
+	defer testServer.Close()
+
+	client := &http.Client{Transport: &http2.Transport{
+		// connects without TLS, using HTTP/2 prior knowledge
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	req, err := http.NewRequest(http.MethodPost, testServer.URL+"/fn/", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ProtoMajor != 2 {
+		t.Errorf("expected HTTP/2, got %v", resp.Proto)
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != "hello" {
+		t.Errorf("expected the request body to be proxied, got %v %q", resp.StatusCode, body)
+	}
+}
+
+// setRouteMap loads the route map for the duration of the test.
+func setRouteMap(t *testing.T, routeMap string) {
+	// registered first, so the routes are reloaded once the environment is restored
+	t.Cleanup(routing.Init)
+	t.Setenv("ROUTE_MAP", routeMap)
+	routing.Init()
+}
+
+func TestPathParameters(t *testing.T) {
+	setRouteMap(t, `{"/users/{id}":"user-fn"}`)
+	fake := &fakeInvoker{}
+
+	serve(newFakeClients(fake), httptest.NewRequest(http.MethodGet, "/users/123/profile", nil))
+
+	event := fake.lastEvent(t)
+	if !reflect.DeepEqual(event.PathParameters, map[string]string{"id": "123"}) {
+		t.Errorf("unexpected path parameters: %v", event.PathParameters)
+	}
+	if event.Resource != "/users/{id}" || event.RequestContext.ResourcePath != "/users/{id}" {
+		t.Errorf("expected the route template as the resource, got %v %v", event.Resource, event.RequestContext.ResourcePath)
+	}
+	if event.Path != "/profile" {
+		t.Errorf("expected the remaining path, got %v", event.Path)
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	setRouteMap(t, `{"/orders":{"function":"order-fn","methods":["get","POST"]}}`)
+	tests := []struct {
+		method     string
+		statusCode int
+	}{
+		{method: http.MethodGet, statusCode: http.StatusOK},
+		{method: http.MethodPost, statusCode: http.StatusOK},
+		{method: http.MethodDelete, statusCode: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			fake := &fakeInvoker{}
+
+			w := serve(newFakeClients(fake), httptest.NewRequest(tt.method, "/orders/1", nil))
+
+			if w.Code != tt.statusCode {
+				t.Fatalf("expected %v, got %v: %s", tt.statusCode, w.Code, w.Body)
+			}
+			if tt.statusCode == http.StatusOK {
+				return
+			}
+			if allow := w.Header().Get("Allow"); allow != "GET, POST" {
+				t.Errorf("expected Allow header, got %q", allow)
+			}
+			if invocations := len(fake.invocations()); invocations != 0 {
+				t.Errorf("expected no invocations, got %v", invocations)
+			}
+		})
+	}
+}
+
+func TestTimeoutHeader(t *testing.T) {
+	setDuration(t, &invokeTimeout, 30*time.Second)
+	setDuration(t, &maxInvokeTimeout, time.Minute)
+	tests := []struct {
+		name       string
+		header     string
+		statusCode int
+		timeout    time.Duration
+	}{
+		{name: "default", statusCode: http.StatusOK, timeout: 30 * time.Second},
+		{name: "override", header: "1500", statusCode: http.StatusOK, timeout: 1500 * time.Millisecond},
+		{name: "at ceiling", header: "60000", statusCode: http.StatusOK, timeout: time.Minute},
+		{name: "over ceiling", header: "60001", statusCode: http.StatusBadRequest},
+		{name: "invalid", header: "-1", statusCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var remaining time.Duration
+			fake := &fakeInvoker{respond: func(ctx aws.Context, _ *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+				deadline, _ := ctx.Deadline()
+				remaining = time.Until(deadline)
+				return proxyOutput(http.StatusOK, nil, ""), nil
+			}}
+			req := httptest.NewRequest(http.MethodGet, "/fn/", nil)
+			if tt.header != "" {
+				req.Header.Set(timeoutHeader, tt.header)
+			}
+
+			w := serve(newFakeClients(fake), req)
+
+			if w.Code != tt.statusCode {
+				t.Fatalf("expected %v, got %v: %s", tt.statusCode, w.Code, w.Body)
+			}
+			if tt.statusCode == http.StatusOK && (remaining > tt.timeout || remaining < tt.timeout-time.Second) {
+				t.Errorf("expected a timeout of %v, got %v remaining", tt.timeout, remaining)
+			}
+		})
+	}
+}
+
+func TestFunctionTimeout(t *testing.T) {
+	setDuration(t, &invokeTimeout, 30*time.Second)
+	setDuration(t, &maxInvokeTimeout, 2*time.Minute)
+	previous := functionConfigs
+	functionConfigs = map[string]config.FunctionConfig{"slow-fn": {Timeout: config.Duration{Duration: time.Minute}}}
+	t.Cleanup(func() { functionConfigs = previous })
+	tests := []struct {
+		name    string
+		path    string
+		header  string
+		timeout time.Duration
+	}{
+		{name: "per-function", path: "/slow-fn/", timeout: time.Minute},
+		{name: "global default", path: "/fast-fn/", timeout: 30 * time.Second},
+		{name: "header overrides per-function", path: "/slow-fn/", header: "1500", timeout: 1500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var remaining time.Duration
+			fake := &fakeInvoker{respond: func(ctx aws.Context, _ *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+				deadline, _ := ctx.Deadline()
+				remaining = time.Until(deadline)
+				return proxyOutput(http.StatusOK, nil, ""), nil
+			}}
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set(timeoutHeader, tt.header)
+			}
+
+			if w := serve(newFakeClients(fake), req); w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %v: %s", w.Code, w.Body)
+			}
+			if remaining > tt.timeout || remaining < tt.timeout-time.Second {
+				t.Errorf("expected a timeout of %v, got %v remaining", tt.timeout, remaining)
+			}
+		})
+	}
+}
+
+// fakeIdentityClient returns the caller identity, or the error, if set.
+type fakeIdentityClient struct {
+	arn string
+	err error
+}
+
+func (f fakeIdentityClient) GetCallerIdentityWithContext(aws.Context, *sts.GetCallerIdentityInput, ...request.Option) (*sts.GetCallerIdentityOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &sts.GetCallerIdentityOutput{Arn: aws.String(f.arn)}, nil
+}
+
+func TestVerifyCredentials(t *testing.T) {
+	authErr := awserr.New("InvalidClientTokenId", "The security token included in the request is invalid.", nil)
+	tests := []struct {
+		name       string
+		identity   fakeIdentityClient
+		failOnBad  string
+		wantLevel  logrus.Level
+		wantExited bool
+	}{
+		{name: "valid", identity: fakeIdentityClient{arn: "arn:aws:iam::123456789012:user/gw"}, wantLevel: logrus.InfoLevel},
+		{name: "invalid", identity: fakeIdentityClient{err: authErr}, wantLevel: logrus.ErrorLevel},
+		{name: "invalid fail fast", identity: fakeIdentityClient{err: authErr}, failOnBad: "true", wantLevel: logrus.FatalLevel, wantExited: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("FAIL_ON_BAD_CREDS", tt.failOnBad)
+			logger := logrus.StandardLogger()
+			hook := logtest.NewLocal(logger)
+			exited := false
+			previousExit := logger.ExitFunc
+			logger.ExitFunc = func(int) { exited = true }
+			t.Cleanup(func() {
+				logger.ExitFunc = previousExit
+				logger.ReplaceHooks(make(logrus.LevelHooks))
+			})
+			clients := newFakeClients(&fakeInvoker{})
+			clients.newIdentityClient = func(*aws.Config) identityClient { return tt.identity }
+
+			verifyCredentials(clients)
+
+			if exited != tt.wantExited {
+				t.Errorf("expected exit %v, got %v", tt.wantExited, exited)
+			}
+			// the fake exit returns, so the first entry is the one logged before exiting
+			entries := hook.AllEntries()
+			if len(entries) == 0 || entries[0].Level != tt.wantLevel {
+				t.Fatalf("expected a %v log entry, got %v", tt.wantLevel, entries)
+			}
+			entry := entries[0]
+			if tt.identity.err != nil && !strings.Contains(entry.Message, "InvalidClientTokenId") {
+				t.Errorf("expected the auth error to be logged, got %q", entry.Message)
+			}
+			if tt.identity.arn != "" && !strings.Contains(entry.Message, tt.identity.arn) {
+				t.Errorf("expected the caller to be logged, got %q", entry.Message)
+			}
+		})
+	}
+}
+
+func TestVersionHandler(t *testing.T) {
+	setString(t, &version, "1.2.3")
+	setString(t, &commit, "abc123")
+	setString(t, &date, "2026-01-02T03:04:05Z")
+	fake := &fakeInvoker{respond: respondWith(proxyOutput(http.StatusOK, nil, "from function"), nil)}
+	clients := newFakeClients(fake)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/system/version", versionHandler)
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		handler(w, req, clients)
+	})
+	setReady()
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{name: "version path", path: "/system/version", want: `{"commit":"abc123","date":"2026-01-02T03:04:05Z","version":"1.2.3"}`},
+		{name: "function path", path: "/system/version-fn/", want: "from function"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if body := strings.TrimSpace(w.Body.String()); w.Code != http.StatusOK || body != tt.want {
+				t.Errorf("expected %v, got %v %v", tt.want, w.Code, body)
+			}
+		})
+	}
+}
+
+// TestVersionLdflags builds the gateway with build information set using
+// '-ldflags', and requests it from the configured version path.
+func TestVersionLdflags(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the gateway")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go is not available")
+	}
+	binary := filepath.Join(t.TempDir(), "gateway")
+	build := exec.Command(goBin, "build", "-o", binary,
+		"-ldflags", "-X main.version=9.8.7 -X main.commit=def456 -X main.date=2026-02-03T04:05:06Z", ".")
+	if output, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("build failed: %v\n%s", err, output)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	_ = listener.Close()
+
+	gateway := exec.Command(binary)
+	gateway.Env = append(os.Environ(), "PORT="+port, "DRY_RUN=true", "AWS_REGION=eu-west-1", "VERSION_PATH=/build")
+	if err := gateway.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = gateway.Process.Kill()
+		_ = gateway.Wait()
+	})
+
+	var info map[string]string
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		resp, err := http.Get("http://127.0.0.1:" + port + "/build")
+		if err == nil {
+			err = json.NewDecoder(resp.Body).Decode(&info)
+			_ = resp.Body.Close()
+		}
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("gateway did not start: %v", err)
+		}
+	}
+	want := map[string]string{"version": "9.8.7", "commit": "def456", "date": "2026-02-03T04:05:06Z"}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("expected build information %v, got %v", want, info)
+	}
+}
+
+func TestEncodedFunctionName(t *testing.T) {
+	arn := "arn:aws:lambda:eu-west-1:123456789012:function:my-fn"
+	tests := []struct {
+		name         string
+		segment      string
+		statusCode   int
+		functionName string
+		qualifier    string
+		message      string
+	}{
+		{name: "plain", segment: "my-fn", statusCode: http.StatusOK, functionName: "my-fn"},
+		{name: "encoded", segment: "@" + b64.RawURLEncoding.EncodeToString([]byte(arn)), statusCode: http.StatusOK, functionName: arn},
+		{name: "encoded with qualifier", segment: "@" + b64.RawURLEncoding.EncodeToString([]byte(arn+":live")), statusCode: http.StatusOK, functionName: arn, qualifier: "live"},
+		{name: "encoded with padding", segment: "@" + b64.URLEncoding.EncodeToString([]byte("fn")), statusCode: http.StatusOK, functionName: "fn"},
+		{name: "not base64url", segment: "@not*base64", statusCode: http.StatusBadRequest, message: "names prefixed with '@' must be base64url encoded"},
+		{name: "standard base64", segment: "@" + b64.RawStdEncoding.EncodeToString([]byte("fn>?")), statusCode: http.StatusBadRequest, message: "must be base64url encoded"},
+		{name: "empty", segment: "@", statusCode: http.StatusBadRequest, message: "invalid encoded function name"},
+		{name: "decoded slash", segment: "@" + b64.RawURLEncoding.EncodeToString([]byte("fn/other")), statusCode: http.StatusBadRequest, message: "invalid encoded function name"},
+		{name: "decoded invalid UTF-8", segment: "@" + b64.RawURLEncoding.EncodeToString([]byte{0xff, 0xfe}), statusCode: http.StatusBadRequest, message: "invalid encoded function name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeInvoker{}
+
+			w := serve(newFakeClients(fake), httptest.NewRequest(http.MethodGet, "/"+tt.segment+"/items", nil))
+
+			if w.Code != tt.statusCode {
+				t.Fatalf("expected %v, got %v: %s", tt.statusCode, w.Code, w.Body)
+			}
+			if tt.statusCode != http.StatusOK {
+				if len(fake.invocations()) > 0 {
+					t.Error("expected the function not to be invoked")
+				}
+				if !strings.Contains(w.Body.String(), tt.message) {
+					t.Errorf("expected the error to contain %q, got %s", tt.message, w.Body)
+				}
+				return
+			}
+			input := fake.invocations()[0]
+			if functionName := aws.StringValue(input.FunctionName); functionName != tt.functionName {
+				t.Errorf("expected function %v, got %v", tt.functionName, functionName)
+			}
+			if qualifier := aws.StringValue(input.Qualifier); qualifier != tt.qualifier {
+				t.Errorf("expected qualifier %q, got %q", tt.qualifier, qualifier)
+			}
+			if path := fake.lastEvent(t).Path; path != "/items" {
+				t.Errorf("expected path /items, got %v", path)
+			}
+		})
+	}
+}
+
+func TestHostHeaderForwarded(t *testing.T) {
+	tests := []struct {
+		name          string
+		trustProxy    bool
+		forwardedHost string
+		want          string
+	}{
+		{name: "direct", want: "api.example.com"},
+		{name: "behind proxy", trustProxy: true, forwardedHost: "public.example.com", want: "public.example.com"},
+		{name: "untrusted client", forwardedHost: "public.example.com", want: "api.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setBool(t, &trustProxy, tt.trustProxy)
+			fake := &fakeInvoker{}
+			req := httptest.NewRequest(http.MethodGet, "http://api.example.com/fn/", nil)
+			if tt.forwardedHost != "" {
+				req.Header.Set("X-Forwarded-Host", tt.forwardedHost)
+			}
+
+			serve(newFakeClients(fake), req)
+
+			event := fake.lastEvent(t)
+			if host := event.Headers["Host"]; host != "api.example.com" {
+				t.Errorf("expected Host header api.example.com, got %q", host)
+			}
+			if forwardedHost := event.Headers["X-Forwarded-Host"]; forwardedHost != tt.want {
+				t.Errorf("expected X-Forwarded-Host %v, got %q", tt.want, forwardedHost)
+			}
+			if forwardedHost := event.MultiValueHeaders["X-Forwarded-Host"]; !reflect.DeepEqual(forwardedHost, []string{tt.want}) {
+				t.Errorf("expected multi-value X-Forwarded-Host %v, got %v", tt.want, forwardedHost)
+			}
+		})
+	}
+}
+
+func TestForwardedHeaders(t *testing.T) {
+	tests := []struct {
+		name       string
+		enabled    bool
+		trustProxy bool
+		tls        bool
+		headers    map[string]string
+		want       map[string]string
+	}{
+		{
+			name: "disabled",
+			want: map[string]string{"X-Forwarded-For": "", "X-Forwarded-Proto": "", "X-Forwarded-Port": ""},
+		},
+		{
+			name:    "http",
+			enabled: true,
+			want:    map[string]string{"X-Forwarded-For": "192.0.2.1", "X-Forwarded-Proto": "http", "X-Forwarded-Port": "8080"},
+		},
+		{
+			name:    "https",
+			enabled: true,
+			tls:     true,
+			want:    map[string]string{"X-Forwarded-For": "192.0.2.1", "X-Forwarded-Proto": "https", "X-Forwarded-Port": "8080"},
+		},
+		{
+			name:    "appends to chain",
+			enabled: true,
+			headers: map[string]string{"X-Forwarded-For": "203.0.113.7, 198.51.100.2", "X-Forwarded-Proto": "https"},
+			want:    map[string]string{"X-Forwarded-For": "203.0.113.7, 198.51.100.2, 192.0.2.1", "X-Forwarded-Proto": "http"},
+		},
+		{
+			name:       "trusted proxy scheme and port",
+			enabled:    true,
+			trustProxy: true,
+			headers:    map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Port": "443"},
+			want:       map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Port": "443"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setBool(t, &setForwardedHeaders, tt.enabled)
+			setBool(t, &trustProxy, tt.trustProxy)
+			fake := &fakeInvoker{}
+			req := httptest.NewRequest(http.MethodGet, "/fn/", nil)
+			req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 8080}))
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+
+			serve(newFakeClients(fake), req)
+
+			event := fake.lastEvent(t)
+			for name, want := range tt.want {
+				if value := event.Headers[name]; value != want {
+					t.Errorf("expected %v %q, got %q", name, want, value)
+				}
+			}
+		})
+	}
+}
+
+func TestTrailingSlash(t *testing.T) {
+	tests := []struct {
+		mode string
+		path string
+		want string
+	}{
+		{mode: "keep", path: "/fn/items", want: "/items"},
+		{mode: "keep", path: "/fn/items/", want: "/items/"},
+		{mode: "keep", path: "/fn/", want: "/"},
+		{mode: "strip", path: "/fn/items/", want: "/items"},
+		{mode: "strip", path: "/fn/items//", want: "/items"},
+		{mode: "strip", path: "/fn/items", want: "/items"},
+		{mode: "strip", path: "/fn/", want: "/"},
+		{mode: "add", path: "/fn/items", want: "/items/"},
+		{mode: "add", path: "/fn/items/", want: "/items/"},
+		{mode: "add", path: "/fn/", want: "/"},
+		{mode: "add", path: "/fn", want: "/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode+" "+tt.path, func(t *testing.T) {
+			setString(t, &trailingSlash, tt.mode)
+			fake := &fakeInvoker{}
+
+			serve(newFakeClients(fake), httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if path := fake.lastEvent(t).Path; path != tt.want {
+				t.Errorf("expected path %v, got %v", tt.want, path)
+			}
+		})
+	}
+}
+
+func TestExecutedVersionHeader(t *testing.T) {
+	tests := []struct {
+		name            string
+		expose          bool
+		executedVersion *string
+		want            string
+	}{
+		{name: "exposed", expose: true, executedVersion: aws.String("7"), want: "7"},
+		{name: "not exposed", executedVersion: aws.String("7")},
+		{name: "no executed version", expose: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setBool(t, &exposeExecutedVersion, tt.expose)
+			output := proxyOutput(http.StatusOK, nil, "")
+			output.ExecutedVersion = tt.executedVersion
+			fake := &fakeInvoker{respond: respondWith(output, nil)}
+
+			w := serve(newFakeClients(fake), httptest.NewRequest(http.MethodGet, "/fn:live/", nil))
+
+			if executedVersion := w.Header().Get(executedVersionHeader); executedVersion != tt.want {
+				t.Errorf("expected executed version %q, got %q", tt.want, executedVersion)
+			}
+		})
+	}
+}
+
+func TestFilterRequestHeaders(t *testing.T) {
+	tests := []struct {
+		name      string
+		forward   []string
+		drop      []string
+		forwarded []string
+		dropped   []string
+	}{
+		{name: "unset", forwarded: []string{"Accept", "Cookie", "X-Internal-Token"}},
+		{name: "allowlist", forward: []string{"accept", "x-internal-token"}, forwarded: []string{"Accept", "X-Internal-Token"}, dropped: []string{"Cookie"}},
+		{name: "denylist", drop: []string{"cookie"}, forwarded: []string{"Accept", "X-Internal-Token"}, dropped: []string{"Cookie"}},
+		{name: "combined", forward: []string{"Accept", "X-Internal-Token"}, drop: []string{"X-Internal-Token"}, forwarded: []string{"Accept"}, dropped: []string{"Cookie", "X-Internal-Token"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previousForward, previousDrop := forwardHeaders, dropHeaders
+			forwardHeaders, dropHeaders = toHeaderSet(tt.forward), toHeaderSet(tt.drop)
+			t.Cleanup(func() { forwardHeaders, dropHeaders = previousForward, previousDrop })
+			fake := &fakeInvoker{}
+			req := httptest.NewRequest(http.MethodGet, "/fn/", nil)
+			req.Header.Set("Accept", "application/json")
+			req.Header.Set("Cookie", "session=1")
+			req.Header.Set("X-Internal-Token", "secret")
+
+			serve(newFakeClients(fake), req)
+
+			event := fake.lastEvent(t)
+			for _, name := range tt.forwarded {
+				if _, exists := event.MultiValueHeaders[name]; !exists {
+					t.Errorf("expected %v to be forwarded", name)
+				}
+			}
+			for _, name := range tt.dropped {
+				if _, exists := event.Headers[name]; exists {
+					t.Errorf("expected %v not to be forwarded", name)
+				}
+				if _, exists := event.MultiValueHeaders[name]; exists {
+					t.Errorf("expected multi-value %v not to be forwarded", name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	fake := &fakeInvoker{respond: func(_ aws.Context, input *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+		if aws.StringValue(input.FunctionName) == "metrics-failing-fn" {
+			return nil, errors.New("connection refused")
+		}
+		return proxyOutput(http.StatusTeapot, nil, ""), nil
+	}}
+	clients := newFakeClients(fake)
+	setReady()
+	proxied := instrument(func(w http.ResponseWriter, req *http.Request) {
+		handler(w, req, clients)
+	})
+	for _, path := range []string{"/metrics-fn/", "/metrics-fn/", "/metrics-failing-fn/"} {
+		proxied(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	w := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	scraped := w.Body.String()
+	for _, expected := range []string{
+		`http_requests_total{code="418"} 2`,
+		`http_request_duration_seconds_count`,
+		`functions_invoke_duration_seconds_count{function="metrics-fn"} 2`,
+		`functions_invoke_duration_seconds_count{function="metrics-failing-fn"} 1`,
+		`functions_error_count{function="metrics-failing-fn"} 1`,
+	} {
+		if !strings.Contains(scraped, expected) {
+			t.Errorf("expected metrics to include %v", expected)
+		}
+	}
+	if strings.Contains(scraped, `functions_error_count{function="metrics-fn"}`) {
+		t.Error("expected no errors for successful invocations")
+	}
+}
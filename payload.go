@@ -0,0 +1,259 @@
+package main
+
+import (
+	b64 "encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/sirupsen/logrus"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// v2Request is a version 2.0 event that always includes the body, which is
+// omitted from the events type when empty, so functions receive an empty
+// string rather than no body, as with version 1.0 events.
+type v2Request struct {
+	events.APIGatewayV2HTTPRequest
+	Body string `json:"body"`
+}
+
+// marshalRequest builds the event sent to the function, in the configured payload format.
+func marshalRequest(proxyReq *proxyRequest) ([]byte, error) {
+	body, isBase64Encoded := encodeBody(proxyReq)
+
+	if payloadVersion == "2.0" {
+		return json.Marshal(v2Request{Body: body, APIGatewayV2HTTPRequest: events.APIGatewayV2HTTPRequest{
+			Version:               "2.0",
+			RouteKey:              "$default",
+			RawPath:               proxyReq.Path,
+			RawQueryString:        proxyReq.RawQueryString,
+			Cookies:               parseCookies(proxyReq.MultiValueHeaders["Cookie"]),
+			Headers:               joinHeaderValues(proxyReq.MultiValueHeaders),
+			QueryStringParameters: proxyReq.QueryStringParameters,
+			PathParameters:        proxyReq.PathParameters,
+			RequestContext: events.APIGatewayV2HTTPRequestContext{
+				RouteKey:  "$default",
+				Stage:     stage,
+				RequestID: proxyReq.RequestID,
+				HTTP: events.APIGatewayV2HTTPRequestContextHTTPDescription{
+					Method:    proxyReq.HTTPMethod,
+					Path:      proxyReq.Path,
+					Protocol:  proxyReq.Protocol,
+					SourceIP:  proxyReq.SourceIP,
+					UserAgent: proxyReq.UserAgent,
+				},
+			},
+			IsBase64Encoded: isBase64Encoded,
+		}})
+	}
+
+	// the resource is the matched route template, or otherwise the path
+	resource := proxyReq.Resource
+	if resource == "" {
+		resource = proxyReq.Path
+	}
+	return json.Marshal(events.APIGatewayProxyRequest{
+		Resource:                        resource,
+		HTTPMethod:                      proxyReq.HTTPMethod,
+		Path:                            proxyReq.Path,
+		PathParameters:                  proxyReq.PathParameters,
+		Headers:                         proxyReq.Headers,
+		MultiValueHeaders:               proxyReq.MultiValueHeaders,
+		QueryStringParameters:           proxyReq.QueryStringParameters,
+		MultiValueQueryStringParameters: proxyReq.MultiValueQueryStringParameters,
+		RequestContext: events.APIGatewayProxyRequestContext{
+			Stage:        stage,
+			RequestID:    proxyReq.RequestID,
+			HTTPMethod:   proxyReq.HTTPMethod,
+			ResourcePath: resource,
+			Protocol:     proxyReq.Protocol,
+			Identity: events.APIGatewayRequestIdentity{
+				SourceIP:  proxyReq.SourceIP,
+				UserAgent: proxyReq.UserAgent,
+			},
+		},
+		Body:            body,
+		IsBase64Encoded: isBase64Encoded,
+	})
+}
+
+// encodeBody returns the request body as a plain string if its content type is
+// text-like, otherwise as a base64 encoded string. Bodies that are not valid
+// UTF-8 are always base64 encoded, as they cannot be represented in JSON.
+// Requests without a body, such as most GET requests, have an empty body,
+// which is not flagged as base64 encoded.
+func encodeBody(proxyReq *proxyRequest) (body string, isBase64Encoded bool) {
+	if len(proxyReq.Body) == 0 {
+		return "", false
+	}
+	if isTextMimeType(proxyReq.MultiValueHeaders["Content-Type"]) && utf8.Valid(proxyReq.Body) {
+		return string(proxyReq.Body), false
+	}
+	return b64.StdEncoding.EncodeToString(proxyReq.Body), true
+}
+
+func isTextMimeType(contentType []string) bool {
+	if len(contentType) == 0 {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType[0])
+	if err != nil {
+		return false
+	}
+	for _, textMimeType := range textMimeTypes {
+		if strings.HasSuffix(textMimeType, "/*") {
+			if strings.HasPrefix(mediaType, strings.TrimSuffix(textMimeType, "*")) {
+				return true
+			}
+		} else if mediaType == textMimeType {
+			return true
+		}
+	}
+	return false
+}
+
+// isStreamed determines whether the base64 encoded body is decoded as it is
+// written to the client. Only large binary bodies are streamed, as text bodies
+// may be compressed, which requires the decoded body.
+func isStreamed(resp *proxyResponse) bool {
+	if streamThreshold <= 0 || len(resp.encodedBody) <= streamThreshold {
+		return false
+	}
+	contentType := resp.getHeader("Content-Type")
+	return contentType != "" && !isTextMimeType([]string{contentType})
+}
+
+// base64EncodedHeader indicates whether a raw response body is base64 encoded.
+const base64EncodedHeader = "X-Base64-Encoded"
+
+// unmarshalResponse parses the function response, in the configured payload format.
+// If raw is true, base64 encoded bodies are returned without decoding. If
+// stream is true, large binary bodies are decoded when written to the client.
+func unmarshalResponse(payload []byte, raw bool, stream bool) (*proxyResponse, error) {
+	var resp events.APIGatewayProxyResponse
+	var err error
+
+	if payloadVersion == "2.0" {
+		var v2Resp events.APIGatewayV2HTTPResponse
+		err = json.Unmarshal(payload, &v2Resp)
+		resp = events.APIGatewayProxyResponse{
+			StatusCode:        v2Resp.StatusCode,
+			Headers:           v2Resp.Headers,
+			MultiValueHeaders: appendSetCookies(v2Resp.MultiValueHeaders, v2Resp.Cookies),
+			Body:              v2Resp.Body,
+			IsBase64Encoded:   v2Resp.IsBase64Encoded,
+		}
+	} else {
+		err = json.Unmarshal(payload, &resp)
+	}
+
+	statusCode := resp.StatusCode
+	if err != nil || statusCode == 0 {
+		if passthroughResponse {
+			// the function is not proxy-aware, so its output is the response body
+			return &proxyResponse{
+				StatusCode: http.StatusOK,
+				Headers:    map[string]string{"Content-Type": "application/json"},
+				Body:       payload,
+			}, nil
+		}
+		return nil, fmt.Errorf("error unmarshalling response: %v", err)
+	}
+
+	if resp.IsBase64Encoded && !raw && stream {
+		streamed := &proxyResponse{
+			StatusCode:        statusCode,
+			Headers:           resp.Headers,
+			MultiValueHeaders: resp.MultiValueHeaders,
+			encodedBody:       resp.Body,
+		}
+		if isStreamed(streamed) {
+			return streamed, nil
+		}
+	}
+
+	var respBody []byte
+	if resp.IsBase64Encoded && !raw {
+		respBody, err = b64.StdEncoding.DecodeString(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding body %v: %v", resp.Body, err)
+		}
+	} else {
+		respBody = []byte(resp.Body)
+	}
+
+	proxyResp := &proxyResponse{
+		StatusCode:        statusCode,
+		Headers:           resp.Headers,
+		MultiValueHeaders: resp.MultiValueHeaders,
+		Body:              respBody,
+	}
+	if raw {
+		proxyResp.setHeader(base64EncodedHeader, strconv.FormatBool(resp.IsBase64Encoded))
+	} else if !resp.IsBase64Encoded {
+		checkUnflaggedBinary(proxyResp)
+	}
+	return proxyResp, nil
+}
+
+// checkUnflaggedBinary warns if the response has a binary content type, but
+// the body is not flagged as base64 encoded, which usually indicates a bug in
+// the function. If detection is enabled, a body that is valid base64 is
+// decoded, as if it had been flagged.
+func checkUnflaggedBinary(resp *proxyResponse) {
+	contentType := resp.getHeader("Content-Type")
+	if contentType == "" || isTextMimeType([]string{contentType}) {
+		return
+	}
+	if detectBase64Response {
+		if decoded, err := b64.StdEncoding.DecodeString(string(resp.Body)); err == nil {
+			logrus.Debugf("decoded unflagged base64 response body with content type %v", contentType)
+			resp.Body = decoded
+			return
+		}
+	}
+	logrus.Warnf("function response has binary content type %v but is not flagged as base64 encoded", contentType)
+}
+
+// joinHeaderValues combines repeated headers into a single comma-separated
+// value, as the 2.0 payload format has no multi-value headers. Cookies are
+// omitted, as they are sent separately in the 2.0 payload format.
+func joinHeaderValues(multiValueHeaders map[string][]string) map[string]string {
+	headers := make(map[string]string)
+	for key, values := range multiValueHeaders {
+		if strings.EqualFold(key, "Cookie") {
+			continue
+		}
+		headers[strings.ToLower(key)] = strings.Join(values, ",")
+	}
+	return headers
+}
+
+// parseCookies splits the Cookie request headers into individual 'name=value' pairs.
+func parseCookies(cookieHeaders []string) []string {
+	var cookies []string
+	for _, cookieHeader := range cookieHeaders {
+		for _, cookie := range strings.Split(cookieHeader, ";") {
+			if cookie = strings.TrimSpace(cookie); cookie != "" {
+				cookies = append(cookies, cookie)
+			}
+		}
+	}
+	return cookies
+}
+
+// appendSetCookies adds the cookies from a 2.0 format response as Set-Cookie headers.
+func appendSetCookies(multiValueHeaders map[string][]string, cookies []string) map[string][]string {
+	if len(cookies) == 0 {
+		return multiValueHeaders
+	}
+	if multiValueHeaders == nil {
+		multiValueHeaders = make(map[string][]string)
+	}
+	multiValueHeaders["Set-Cookie"] = append(multiValueHeaders["Set-Cookie"], cookies...)
+	return multiValueHeaders
+}
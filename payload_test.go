@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPayloadVersions(t *testing.T) {
+	tests := []struct {
+		version    string
+		response   interface{}
+		checkEvent func(t *testing.T, fake *fakeInvoker)
+	}{
+		{
+			version:  "1.0",
+			response: events.APIGatewayProxyResponse{StatusCode: http.StatusCreated, Body: "created"},
+			checkEvent: func(t *testing.T, fake *fakeInvoker) {
+				event := fake.lastEvent(t)
+				if event.HTTPMethod != http.MethodPost || event.Path != "/items" || event.Body != `{"a":1}` {
+					t.Errorf("unexpected event: %v %v %q", event.HTTPMethod, event.Path, event.Body)
+				}
+				if q := event.QueryStringParameters["q"]; q != "x" {
+					t.Errorf("expected query parameter, got %q", q)
+				}
+			},
+		},
+		{
+			version:  "2.0",
+			response: events.APIGatewayV2HTTPResponse{StatusCode: http.StatusCreated, Body: "created"},
+			checkEvent: func(t *testing.T, fake *fakeInvoker) {
+				var event events.APIGatewayV2HTTPRequest
+				fake.unmarshalLastPayload(t, &event)
+				if event.Version != "2.0" || event.RawPath != "/items" || event.RawQueryString != "q=x" || event.Body != `{"a":1}` {
+					t.Errorf("unexpected event: %v %v %v %q", event.Version, event.RawPath, event.RawQueryString, event.Body)
+				}
+				if httpContext := event.RequestContext.HTTP; httpContext.Method != http.MethodPost || httpContext.Path != "/items" {
+					t.Errorf("unexpected request context: %+v", httpContext)
+				}
+				if contentType := event.Headers["content-type"]; contentType != "application/json" {
+					t.Errorf("expected lower case headers, got %v", event.Headers)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			setString(t, &payloadVersion, tt.version)
+			fake := &fakeInvoker{respond: respondWith(payloadOutput(tt.response), nil)}
+			req := httptest.NewRequest(http.MethodPost, "/fn/items?q=x", strings.NewReader(`{"a":1}`))
+			req.Header.Set("Content-Type", "application/json")
+
+			w := serve(newFakeClients(fake), req)
+
+			if w.Code != http.StatusCreated || w.Body.String() != "created" {
+				t.Errorf("expected function response, got %v %q", w.Code, w.Body)
+			}
+			tt.checkEvent(t, fake)
+		})
+	}
+}
+
+func TestV2ResponseCookies(t *testing.T) {
+	setString(t, &payloadVersion, "2.0")
+	fake := &fakeInvoker{respond: respondWith(payloadOutput(events.APIGatewayV2HTTPResponse{
+		StatusCode: http.StatusOK,
+		Cookies:    []string{"a=1", "b=2"},
+	}), nil)}
+
+	w := serve(newFakeClients(fake), httptest.NewRequest(http.MethodGet, "/fn/", nil))
+
+	if cookies := w.Header().Values("Set-Cookie"); !reflect.DeepEqual(cookies, []string{"a=1", "b=2"}) {
+		t.Errorf("expected cookies as Set-Cookie headers, got %v", cookies)
+	}
+}
+
+func TestRequestContext(t *testing.T) {
+	setString(t, &stage, "prod")
+	fake := &fakeInvoker{}
+	req := httptest.NewRequest(http.MethodPut, "/fn/items/1", nil)
+	req.Header.Set(requestIdHeader, "req-123")
+
+	serve(newFakeClients(fake), req)
+
+	requestContext := fake.lastEvent(t).RequestContext
+	expected := events.APIGatewayProxyRequestContext{
+		Stage:        "prod",
+		RequestID:    "req-123",
+		HTTPMethod:   http.MethodPut,
+		ResourcePath: "/items/1",
+		Protocol:     "HTTP/1.1",
+		Identity:     events.APIGatewayRequestIdentity{SourceIP: "192.0.2.1"},
+	}
+	if !reflect.DeepEqual(requestContext, expected) {
+		t.Errorf("expected request context %+v, got %+v", expected, requestContext)
+	}
+}
+
+func TestEncodeBody(t *testing.T) {
+	tests := []struct {
+		name            string
+		contentType     string
+		body            []byte
+		expected        string
+		isBase64Encoded bool
+	}{
+		{name: "json", contentType: "application/json", body: []byte(`{"a":1}`), expected: `{"a":1}`},
+		{name: "text with charset", contentType: "text/plain; charset=utf-8", body: []byte("hello"), expected: "hello"},
+		{name: "form", contentType: "application/x-www-form-urlencoded", body: []byte("a=1"), expected: "a=1"},
+		{name: "image", contentType: "image/png", body: []byte{0x89, 'P', 'N', 'G'}, expected: "iVBORw==", isBase64Encoded: true},
+		{name: "no content type", body: []byte("hello"), expected: "aGVsbG8=", isBase64Encoded: true},
+		{name: "invalid utf-8 text", contentType: "text/plain", body: []byte{0xff, 0xfe}, expected: "//4=", isBase64Encoded: true},
+		{name: "empty", contentType: "image/png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxyReq := &proxyRequest{Body: tt.body, MultiValueHeaders: map[string][]string{}}
+			if tt.contentType != "" {
+				proxyReq.MultiValueHeaders["Content-Type"] = []string{tt.contentType}
+			}
+			body, isBase64Encoded := encodeBody(proxyReq)
+			if body != tt.expected || isBase64Encoded != tt.isBase64Encoded {
+				t.Errorf("expected %q (base64 %v), got %q (base64 %v)", tt.expected, tt.isBase64Encoded, body, isBase64Encoded)
+			}
+		})
+	}
+}
+
+func TestPassthroughResponse(t *testing.T) {
+	tests := []struct {
+		name        string
+		passthrough bool
+		payload     string
+		statusCode  int
+		body        string
+	}{
+		{name: "proxy response", passthrough: true, payload: `{"statusCode":201,"body":"created"}`, statusCode: http.StatusCreated, body: "created"},
+		{name: "raw object", passthrough: true, payload: `{"id":1}`, statusCode: http.StatusOK, body: `{"id":1}`},
+		{name: "raw string", passthrough: true, payload: `"hello"`, statusCode: http.StatusOK, body: `"hello"`},
+		{name: "raw object without passthrough", payload: `{"id":1}`, statusCode: http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setBool(t, &passthroughResponse, tt.passthrough)
+			fake := &fakeInvoker{respond: respondWith(&lambda.InvokeOutput{Payload: []byte(tt.payload)}, nil)}
+
+			w := serve(newFakeClients(fake), httptest.NewRequest(http.MethodGet, "/fn/", nil))
+
+			if w.Code != tt.statusCode {
+				t.Fatalf("expected %v, got %v: %s", tt.statusCode, w.Code, w.Body)
+			}
+			if tt.body != "" && w.Body.String() != tt.body {
+				t.Errorf("expected body %q, got %q", tt.body, w.Body)
+			}
+			if tt.passthrough && tt.statusCode == http.StatusOK && w.Header().Get("Content-Type") != "application/json" {
+				t.Errorf("expected JSON content type, got %q", w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestV2CookieRoundTrip(t *testing.T) {
+	setString(t, &payloadVersion, "2.0")
+	fake := &fakeInvoker{respond: func(_ aws.Context, input *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+		var event events.APIGatewayV2HTTPRequest
+		if err := json.Unmarshal(input.Payload, &event); err != nil {
+			return nil, err
+		}
+		// echoes the request cookies back to the client
+		return payloadOutput(events.APIGatewayV2HTTPResponse{StatusCode: http.StatusOK, Cookies: event.Cookies}), nil
+	}}
+	req := httptest.NewRequest(http.MethodGet, "/fn/", nil)
+	req.Header.Add("Cookie", "a=1; b=2")
+	req.Header.Add("Cookie", "c=3")
+
+	w := serve(newFakeClients(fake), req)
+
+	var event events.APIGatewayV2HTTPRequest
+	fake.unmarshalLastPayload(t, &event)
+	if expected := []string{"a=1", "b=2", "c=3"}; !reflect.DeepEqual(event.Cookies, expected) {
+		t.Errorf("expected cookies %v, got %v", expected, event.Cookies)
+	}
+	if cookie, exists := event.Headers["cookie"]; exists {
+		t.Errorf("expected no cookie header, got %q", cookie)
+	}
+	if setCookies := w.Header().Values("Set-Cookie"); !reflect.DeepEqual(setCookies, []string{"a=1", "b=2", "c=3"}) {
+		t.Errorf("expected the cookies as Set-Cookie headers, got %v", setCookies)
+	}
+}
+
+func TestRawBase64Response(t *testing.T) {
+	tests := []struct {
+		name          string
+		rawHeader     string
+		base64Encoded bool
+		body          string
+		flagHeader    string
+	}{
+		{name: "decoded", base64Encoded: true, body: "hello"},
+		{name: "raw", rawHeader: "true", base64Encoded: true, body: "aGVsbG8=", flagHeader: "true"},
+		{name: "raw unencoded", rawHeader: "true", body: "aGVsbG8=", flagHeader: "false"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeInvoker{respond: respondWith(payloadOutput(events.APIGatewayProxyResponse{
+				StatusCode:      http.StatusOK,
+				Headers:         map[string]string{"Content-Type": "text/plain"},
+				Body:            "aGVsbG8=",
+				IsBase64Encoded: tt.base64Encoded,
+			}), nil)}
+			req := httptest.NewRequest(http.MethodGet, "/fn/", nil)
+			if tt.rawHeader != "" {
+				req.Header.Set(rawResponseHeader, tt.rawHeader)
+			}
+
+			w := serve(newFakeClients(fake), req)
+
+			if w.Body.String() != tt.body {
+				t.Errorf("expected body %q, got %q", tt.body, w.Body)
+			}
+			if flag := w.Header().Get(base64EncodedHeader); flag != tt.flagHeader {
+				t.Errorf("expected %v to be %q, got %q", base64EncodedHeader, tt.flagHeader, flag)
+			}
+		})
+	}
+}
+
+func TestInvalidUTF8TextBody(t *testing.T) {
+	fake := &fakeInvoker{}
+	req := httptest.NewRequest(http.MethodPost, "/fn/", strings.NewReader("caf\xe9"))
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	serve(newFakeClients(fake), req)
+
+	event := fake.lastEvent(t)
+	if !event.IsBase64Encoded || event.Body != "Y2Fm6Q==" {
+		t.Errorf("expected a base64 encoded body, got %q (base64 %v)", event.Body, event.IsBase64Encoded)
+	}
+}
+
+func TestRequestBodyForMethods(t *testing.T) {
+	methods := []string{http.MethodGet, http.MethodDelete, http.MethodPut, http.MethodPatch, http.MethodPost}
+	for _, version := range []string{"1.0", "2.0"} {
+		for _, method := range methods {
+			for _, body := range []string{"", `{"id":1}`} {
+				name := version + " " + method + " without body"
+				if body != "" {
+					name = version + " " + method + " with body"
+				}
+				t.Run(name, func(t *testing.T) {
+					setString(t, &payloadVersion, version)
+					fake := &fakeInvoker{}
+					req := httptest.NewRequest(method, "/fn/items", strings.NewReader(body))
+					req.Header.Set("Content-Type", "application/json")
+
+					serve(newFakeClients(fake), req)
+
+					var event map[string]interface{}
+					fake.unmarshalLastPayload(t, &event)
+					// an empty body is sent as an empty string, rather than null or omitted
+					if eventBody, ok := event["body"].(string); !ok || eventBody != body {
+						t.Errorf("expected body %q, got %#v", body, event["body"])
+					}
+					if encoded, _ := event["isBase64Encoded"].(bool); encoded {
+						t.Error("expected the body not to be base64 encoded")
+					}
+					if version == "1.0" && event["httpMethod"] != method {
+						t.Errorf("expected method %v, got %v", method, event["httpMethod"])
+					}
+				})
+			}
+		}
+	}
+}
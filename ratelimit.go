@@ -0,0 +1,107 @@
+package main
+
+import (
+	"lambdahttpgw/config"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	limiter      = newRateLimiter(config.GetRateLimit(), config.GetRateBurst())
+	rateLimitKey = config.GetRateLimitKey()
+)
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimiter limits the rate of requests from each client using a token
+// bucket, which holds up to the burst size and refills at the rate per second.
+// Buckets that have refilled are evicted, as they are equivalent to new ones.
+type rateLimiter struct {
+	lock      sync.Mutex
+	rate      float64
+	burst     int
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst <= 0 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	return &rateLimiter{
+		rate:      rate,
+		burst:     burst,
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// allow takes a token from the client's bucket, if available. It returns
+// the remaining tokens, and if rejected, the duration until a token is available.
+func (l *rateLimiter) allow(client string) (allowed bool, remaining int, retryAfter time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	bucket, exists := l.buckets[client]
+	if !exists {
+		bucket = &tokenBucket{tokens: float64(l.burst), updated: now}
+		l.buckets[client] = bucket
+	}
+	bucket.tokens = math.Min(float64(l.burst), bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate)
+	bucket.updated = now
+
+	if bucket.tokens < 1 {
+		wait := (1 - bucket.tokens) / l.rate
+		return false, 0, time.Duration(wait * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, int(bucket.tokens), 0
+}
+
+// sweep evicts full buckets, at most once per refill period.
+func (l *rateLimiter) sweep(now time.Time) {
+	refillPeriod := time.Duration(float64(l.burst) / l.rate * float64(time.Second))
+	if now.Sub(l.lastSweep) < refillPeriod {
+		return
+	}
+	for client, bucket := range l.buckets {
+		if now.Sub(bucket.updated) >= refillPeriod {
+			delete(l.buckets, client)
+		}
+	}
+	l.lastSweep = now
+}
+
+// applyRateLimit sets the rate limit headers on the response, and determines
+// whether the request may proceed. Requests are limited by client IP address,
+// or by API key if configured. API keys are only used if they are validated,
+// as clients could otherwise avoid the limit by changing their key. All
+// requests are allowed if rate limiting is disabled.
+func applyRateLimit(w http.ResponseWriter, req *http.Request) bool {
+	if limiter.rate <= 0 {
+		return true
+	}
+	client := getSourceIP(req)
+	if rateLimitKey == "apikey" && len(apiKeys) > 0 {
+		if apiKey := req.Header.Get(apiKeyHeader); apiKey != "" {
+			client = apiKey
+		}
+	}
+	allowed, remaining, retryAfter := limiter.allow(client)
+
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limiter.burst))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	if !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	}
+	return allowed
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func setRateLimit(t *testing.T, rate float64, burst int, key string, keys []string) {
+	previousLimiter, previousKey, previousKeys := limiter, rateLimitKey, apiKeys
+	limiter, rateLimitKey, apiKeys = newRateLimiter(rate, burst), key, keys
+	t.Cleanup(func() {
+		limiter, rateLimitKey, apiKeys = previousLimiter, previousKey, previousKeys
+	})
+}
+
+func TestApplyRateLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		apiKeys  []string
+		requests []rateLimitedRequest
+	}{
+		{
+			name: "ip exceeds limit",
+			key:  "ip",
+			requests: []rateLimitedRequest{
+				{remoteAddr: "192.0.2.1:1000", expectAllowed: true, expectRemaining: "1"},
+				{remoteAddr: "192.0.2.1:1001", expectAllowed: true, expectRemaining: "0"},
+				{remoteAddr: "192.0.2.1:1002", expectAllowed: false, expectRemaining: "0"},
+				{remoteAddr: "192.0.2.2:1000", expectAllowed: true, expectRemaining: "1"},
+			},
+		},
+		{
+			name:    "api key exceeds limit",
+			key:     "apikey",
+			apiKeys: []string{"a", "b"},
+			requests: []rateLimitedRequest{
+				{remoteAddr: "192.0.2.1:1000", apiKey: "a", expectAllowed: true, expectRemaining: "1"},
+				{remoteAddr: "192.0.2.2:1000", apiKey: "a", expectAllowed: true, expectRemaining: "0"},
+				{remoteAddr: "192.0.2.3:1000", apiKey: "a", expectAllowed: false, expectRemaining: "0"},
+				{remoteAddr: "192.0.2.3:1000", apiKey: "b", expectAllowed: true, expectRemaining: "1"},
+			},
+		},
+		{
+			name: "unvalidated api keys use ip",
+			key:  "apikey",
+			requests: []rateLimitedRequest{
+				{remoteAddr: "192.0.2.1:1000", apiKey: "x", expectAllowed: true, expectRemaining: "1"},
+				{remoteAddr: "192.0.2.1:1000", apiKey: "y", expectAllowed: true, expectRemaining: "0"},
+				{remoteAddr: "192.0.2.1:1000", apiKey: "z", expectAllowed: false, expectRemaining: "0"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRateLimit(t, 0.001, 2, tt.key, tt.apiKeys)
+			for i, r := range tt.requests {
+				req := httptest.NewRequest(http.MethodGet, "/fn/", nil)
+				req.RemoteAddr = r.remoteAddr
+				if r.apiKey != "" {
+					req.Header.Set(apiKeyHeader, r.apiKey)
+				}
+				w := httptest.NewRecorder()
+				if allowed := applyRateLimit(w, req); allowed != r.expectAllowed {
+					t.Fatalf("request %d: expected allowed %v, got %v", i, r.expectAllowed, allowed)
+				}
+				if limit := w.Header().Get("X-RateLimit-Limit"); limit != "2" {
+					t.Errorf("request %d: expected limit header 2, got %q", i, limit)
+				}
+				if remaining := w.Header().Get("X-RateLimit-Remaining"); remaining != r.expectRemaining {
+					t.Errorf("request %d: expected remaining %v, got %q", i, r.expectRemaining, remaining)
+				}
+				if retryAfter := w.Header().Get("Retry-After"); (retryAfter != "") == r.expectAllowed {
+					t.Errorf("request %d: unexpected Retry-After %q", i, retryAfter)
+				}
+			}
+		})
+	}
+}
+
+type rateLimitedRequest struct {
+	remoteAddr      string
+	apiKey          string
+	expectAllowed   bool
+	expectRemaining string
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	setRateLimit(t, 0, 0, "ip", nil)
+	for i := 0; i < 100; i++ {
+		w := httptest.NewRecorder()
+		if !applyRateLimit(w, httptest.NewRequest(http.MethodGet, "/fn/", nil)) {
+			t.Fatal("expected all requests to be allowed when rate limiting is disabled")
+		}
+		if w.Header().Get("X-RateLimit-Limit") != "" {
+			t.Fatal("expected no rate limit headers when rate limiting is disabled")
+		}
+	}
+}
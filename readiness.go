@@ -0,0 +1,15 @@
+package main
+
+import "sync/atomic"
+
+// ready is set once startup has completed, such as verifying credentials,
+// so requests received before then can be rejected with a 503.
+var ready int32
+
+func setReady() {
+	atomic.StoreInt32(&ready, 1)
+}
+
+func isReady() bool {
+	return atomic.LoadInt32(&ready) == 1
+}
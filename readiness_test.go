@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHandlerBeforeReady(t *testing.T) {
+	tests := []struct {
+		name       string
+		ready      int32
+		statusCode int
+		retryAfter string
+	}{
+		{name: "starting", ready: 0, statusCode: http.StatusServiceUnavailable, retryAfter: "1"},
+		{name: "ready", ready: 1, statusCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := atomic.LoadInt32(&ready)
+			atomic.StoreInt32(&ready, tt.ready)
+			t.Cleanup(func() { atomic.StoreInt32(&ready, previous) })
+			fake := &fakeInvoker{}
+
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest(http.MethodGet, "/fn/", nil), newFakeClients(fake))
+
+			if w.Code != tt.statusCode {
+				t.Fatalf("expected %v, got %v: %s", tt.statusCode, w.Code, w.Body)
+			}
+			if retryAfter := w.Header().Get("Retry-After"); retryAfter != tt.retryAfter {
+				t.Errorf("expected Retry-After %q, got %q", tt.retryAfter, retryAfter)
+			}
+			if invoked := len(fake.invocations()) > 0; invoked != (tt.ready == 1) {
+				t.Errorf("expected invoked to be %v", !invoked)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"lambdahttpgw/config"
+	"time"
+)
+
+// crockfordAlphabet is the base32 alphabet used to encode ULIDs.
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// requestIdGenerator generates the IDs of requests that do not have one.
+type requestIdGenerator interface {
+	NewID() string
+}
+
+var requestIds = newRequestIdGenerator(config.GetRequestIdFormat())
+
+// newRequestIdGenerator returns the generator for the given format:
+// "uuid", "ulid" or "short".
+func newRequestIdGenerator(format string) requestIdGenerator {
+	switch format {
+	case "uuid":
+		return uuidGenerator{}
+	case "ulid":
+		return ulidGenerator{}
+	case "short":
+		return shortGenerator{}
+	}
+	logrus.Warnf("unknown request ID format %v - using uuid", format)
+	return uuidGenerator{}
+}
+
+// uuidGenerator generates random (version 4) UUIDs.
+type uuidGenerator struct{}
+
+func (uuidGenerator) NewID() string {
+	return uuid.NewString()
+}
+
+// ulidGenerator generates ULIDs, which sort by creation time.
+type ulidGenerator struct{}
+
+func (ulidGenerator) NewID() string {
+	var id [16]byte
+	// the first 48 bits are the timestamp in milliseconds, followed by 80 random bits
+	millis := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	binary.BigEndian.PutUint16(id[0:2], uint16(millis>>32))
+	binary.BigEndian.PutUint32(id[2:6], uint32(millis))
+	_, _ = rand.Read(id[6:])
+
+	hi, lo := binary.BigEndian.Uint64(id[:8]), binary.BigEndian.Uint64(id[8:])
+	encoded := make([]byte, 26)
+	for i := range encoded {
+		shift := uint(5 * (25 - i))
+		var bits uint64
+		switch {
+		case shift >= 64:
+			bits = hi >> (shift - 64)
+		case shift == 0:
+			bits = lo
+		default:
+			bits = lo>>shift | hi<<(64-shift)
+		}
+		encoded[i] = crockfordAlphabet[bits&31]
+	}
+	return string(encoded)
+}
+
+// shortGenerator generates 16 character random hex IDs.
+type shortGenerator struct{}
+
+func (shortGenerator) NewID() string {
+	var id [8]byte
+	_, _ = rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"github.com/google/uuid"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestRequestIdFormats(t *testing.T) {
+	tests := []struct {
+		format  string
+		pattern *regexp.Regexp
+	}{
+		{format: "uuid", pattern: regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)},
+		// the first character encodes only 3 bits of the 128 bit value
+		{format: "ulid", pattern: regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)},
+		{format: "short", pattern: regexp.MustCompile(`^[0-9a-f]{16}$`)},
+		{format: "unknown", pattern: regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			generator := newRequestIdGenerator(tt.format)
+			seen := make(map[string]bool)
+			for i := 0; i < 100; i++ {
+				id := generator.NewID()
+				if !tt.pattern.MatchString(id) {
+					t.Fatalf("invalid %v request ID %q", tt.format, id)
+				}
+				if seen[id] {
+					t.Fatalf("duplicate request ID %q", id)
+				}
+				seen[id] = true
+			}
+			if tt.format == "uuid" {
+				if _, err := uuid.Parse(generator.NewID()); err != nil {
+					t.Errorf("expected a valid UUID: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestSuppliedRequestIdWins(t *testing.T) {
+	for _, format := range []string{"uuid", "ulid", "short"} {
+		t.Run(format, func(t *testing.T) {
+			previous := requestIds
+			requestIds = newRequestIdGenerator(format)
+			t.Cleanup(func() { requestIds = previous })
+			fake := &fakeInvoker{}
+			req := httptest.NewRequest(http.MethodGet, "/fn/", nil)
+			req.Header.Set(requestIdHeader, "client-id")
+
+			w := serve(newFakeClients(fake), req)
+
+			if requestId := w.Header().Get(requestIdHeader); requestId != "client-id" {
+				t.Errorf("expected the supplied request ID, got %q", requestId)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	b64 "encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"lambdahttpgw/config"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// hopByHopHeaders apply to a single connection, so are never forwarded, per RFC 7230.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// the status override header is only used by the gateway, so is not returned to the client
+var strippedResponseHeaders = buildStrippedHeaders(append(config.GetStripResponseHeaders(), statusOverrideHeader))
+
+// errorFormat is the format of gateway error responses: "json" or "plain".
+var errorFormat = config.GetErrorFormat()
+
+// errorResponse is the body of gateway error responses, in JSON format.
+type errorResponse struct {
+	Error     string `json:"error"`
+	RequestID string `json:"requestId"`
+	Status    int    `json:"status"`
+}
+
+// defaultContentType is used when the function response has a body but no
+// content type, instead of the type being sniffed from the body.
+var defaultContentType = config.GetDefaultContentType()
+
+func buildStrippedHeaders(configured []string) map[string]bool {
+	stripped := make(map[string]bool)
+	for _, header := range append(hopByHopHeaders, configured...) {
+		stripped[http.CanonicalHeaderKey(header)] = true
+	}
+	return stripped
+}
+
+func sendResponse(log *logrus.Entry, w http.ResponseWriter, resp *proxyResponse, client string) (err error) {
+	applyStatusOverride(resp)
+	for responseHeaderKey, responseHeaderValues := range mergeHeaders(resp) {
+		if http.CanonicalHeaderKey(responseHeaderKey) == "Vary" {
+			// keep any Vary values set by the gateway, such as Origin for CORS
+			for _, value := range responseHeaderValues {
+				w.Header().Add("Vary", value)
+			}
+			continue
+		}
+		w.Header()[responseHeaderKey] = responseHeaderValues
+	}
+	if resp.bodyLen() > 0 && w.Header().Get("Content-Type") == "" && defaultContentType != "" {
+		w.Header().Set("Content-Type", defaultContentType)
+	}
+	w.WriteHeader(resp.StatusCode)
+	if resp.encodedBody != "" {
+		err = writeStreamed(w, resp.encodedBody)
+	} else {
+		_, err = w.Write(resp.Body)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing response: %v", err)
+	}
+
+	log.WithFields(logrus.Fields{
+		"statusCode":    resp.StatusCode,
+		"responseBytes": resp.bodyLen(),
+	}).Debugf("wrote response to client %v", client)
+	return nil
+}
+
+// getHeader returns the first value of the named response header,
+// matching the name case-insensitively.
+// bodyLen returns the size of the decoded response body.
+func (r *proxyResponse) bodyLen() int {
+	if r.encodedBody == "" {
+		return len(r.Body)
+	}
+	padding := len(r.encodedBody) - len(strings.TrimRight(r.encodedBody, "="))
+	return b64.StdEncoding.DecodedLen(len(r.encodedBody)) - padding
+}
+
+// writeStreamed decodes the base64 encoded body as it is written, flushing
+// each chunk to the client, so the decoded body is never held in memory. The
+// status has already been sent, so if the body is not valid base64, the
+// connection is aborted, rather than the client receiving a truncated body.
+func writeStreamed(w http.ResponseWriter, encodedBody string) error {
+	flusher, _ := w.(http.Flusher)
+	decoder := b64.NewDecoder(b64.StdEncoding, strings.NewReader(encodedBody))
+	chunk := make([]byte, streamChunkSize)
+	for {
+		n, err := io.ReadFull(decoder, chunk)
+		if n > 0 {
+			if _, writeErr := w.Write(chunk[:n]); writeErr != nil {
+				return writeErr
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			logrus.Errorf("aborting response - error decoding body: %v", err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+func (r *proxyResponse) getHeader(name string) string {
+	for key, values := range r.MultiValueHeaders {
+		if strings.EqualFold(key, name) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	for key, value := range r.Headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// setHeader replaces any existing values of the named response header,
+// matching the name case-insensitively.
+func (r *proxyResponse) setHeader(name string, value string) {
+	for key := range r.MultiValueHeaders {
+		if strings.EqualFold(key, name) {
+			delete(r.MultiValueHeaders, key)
+		}
+	}
+	for key := range r.Headers {
+		if strings.EqualFold(key, name) {
+			delete(r.Headers, key)
+		}
+	}
+	if r.Headers == nil {
+		r.Headers = make(map[string]string)
+	}
+	r.Headers[name] = value
+}
+
+// clone returns a copy of the response, so the headers of the copy can be
+// modified independently. The body is shared, as it is replaced rather than
+// modified in place.
+func (r *proxyResponse) clone() *proxyResponse {
+	c := *r
+	if r.Headers != nil {
+		c.Headers = make(map[string]string, len(r.Headers))
+		for key, value := range r.Headers {
+			c.Headers[key] = value
+		}
+	}
+	if r.MultiValueHeaders != nil {
+		c.MultiValueHeaders = make(map[string][]string, len(r.MultiValueHeaders))
+		for key, values := range r.MultiValueHeaders {
+			c.MultiValueHeaders[key] = append([]string(nil), values...)
+		}
+	}
+	return &c
+}
+
+// mergeHeaders combines the single and multi-value response headers, keyed
+// by canonical header name, omitting stripped headers. Headers that differ
+// only by case are merged, and repeated values are only included once.
+// Multi-value headers take precedence, to avoid duplicating values present
+// in both.
+func mergeHeaders(resp *proxyResponse) http.Header {
+	merged := make(http.Header)
+	for responseHeaderKey, responseHeaderValues := range resp.MultiValueHeaders {
+		if isStrippedHeader(responseHeaderKey) {
+			continue
+		}
+		canonicalKey := textproto.CanonicalMIMEHeaderKey(responseHeaderKey)
+		for _, responseHeaderValue := range responseHeaderValues {
+			if !containsValue(merged[canonicalKey], responseHeaderValue) {
+				merged[canonicalKey] = append(merged[canonicalKey], responseHeaderValue)
+			}
+		}
+	}
+	for responseHeaderKey, responseHeaderValue := range resp.Headers {
+		if isStrippedHeader(responseHeaderKey) {
+			continue
+		}
+		canonicalKey := textproto.CanonicalMIMEHeaderKey(responseHeaderKey)
+		if _, exists := merged[canonicalKey]; !exists {
+			merged[canonicalKey] = []string{responseHeaderValue}
+		}
+	}
+	return merged
+}
+
+func containsValue(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// isStrippedHeader determines whether a response header should not be
+// returned to the client.
+func isStrippedHeader(name string) bool {
+	return strippedResponseHeaders[http.CanonicalHeaderKey(name)]
+}
+
+// writeError sends an error body with the given status code, in the configured
+// format. The request ID is included so clients can correlate the failure with
+// the gateway logs, and is also available in the request ID response header.
+func writeError(w http.ResponseWriter, statusCode int, message string, requestId string) {
+	if errorFormat == "plain" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(statusCode)
+		_, _ = fmt.Fprintln(w, message)
+		return
+	}
+	body, _ := json.Marshal(errorResponse{
+		Error:     message,
+		RequestID: requestId,
+		Status:    statusCode,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bytes"
+	b64 "encoding/base64"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMultiValueResponseHeaders(t *testing.T) {
+	fake := &fakeInvoker{respond: respondWith(payloadOutput(events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"Content-Type": "text/plain", "X-Single": "one"},
+		MultiValueHeaders: map[string][]string{
+			"Set-Cookie":   {"a=1", "b=2"},
+			"Content-Type": {"text/plain"},
+		},
+	}), nil)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		handler(w, req, newFakeClients(fake))
+	}))
+	defer server.Close()
+	setReady()
+
+	resp, err := http.Get(server.URL + "/fn/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if cookies := resp.Header.Values("Set-Cookie"); !reflect.DeepEqual(cookies, []string{"a=1", "b=2"}) {
+		t.Errorf("expected separate Set-Cookie headers, got %v", cookies)
+	}
+	if contentType := resp.Header.Values("Content-Type"); len(contentType) != 1 {
+		t.Errorf("expected a header in both maps to be sent once, got %v", contentType)
+	}
+	if single := resp.Header.Get("X-Single"); single != "one" {
+		t.Errorf("expected single-value header, got %q", single)
+	}
+}
+
+func TestStripResponseHeaders(t *testing.T) {
+	previous := strippedResponseHeaders
+	strippedResponseHeaders = buildStrippedHeaders([]string{"x-amzn-internal"})
+	t.Cleanup(func() { strippedResponseHeaders = previous })
+
+	fake := &fakeInvoker{respond: respondWith(payloadOutput(events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers: map[string]string{
+			"Connection":        "close",
+			"keep-alive":        "timeout=5",
+			"Transfer-Encoding": "chunked",
+			"X-Amzn-Internal":   "secret",
+			"X-Kept":            "yes",
+		},
+		MultiValueHeaders: map[string][]string{"X-AMZN-INTERNAL": {"secret"}},
+	}), nil)}
+
+	w := serve(newFakeClients(fake), httptest.NewRequest(http.MethodGet, "/fn/", nil))
+
+	for _, name := range []string{"Connection", "Keep-Alive", "Transfer-Encoding", "X-Amzn-Internal"} {
+		if values := w.Header().Values(name); len(values) > 0 {
+			t.Errorf("expected %v to be stripped, got %v", name, values)
+		}
+	}
+	if kept := w.Header().Get("X-Kept"); kept != "yes" {
+		t.Errorf("expected other headers to be returned, got %q", kept)
+	}
+}
+
+func TestCanonicalResponseHeaders(t *testing.T) {
+	tests := []struct {
+		name              string
+		headers           map[string]string
+		multiValueHeaders map[string][]string
+		want              []string
+	}{
+		{
+			name:    "single-value keys differing by case",
+			headers: map[string]string{"x-custom": "one", "X-CUSTOM": "one"},
+			want:    []string{"one"},
+		},
+		{
+			name:              "multi-value keys differing by case",
+			multiValueHeaders: map[string][]string{"x-custom": {"one", "two"}, "X-CUSTOM": {"one", "two"}},
+			want:              []string{"one", "two"},
+		},
+		{
+			name:              "single and multi-value keys differing by case",
+			headers:           map[string]string{"X-CUSTOM": "other"},
+			multiValueHeaders: map[string][]string{"x-custom": {"one"}},
+			want:              []string{"one"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := &fakeInvoker{respond: respondWith(payloadOutput(events.APIGatewayProxyResponse{
+				StatusCode:        http.StatusOK,
+				Headers:           test.headers,
+				MultiValueHeaders: test.multiValueHeaders,
+			}), nil)}
+
+			w := serve(newFakeClients(fake), httptest.NewRequest(http.MethodGet, "/fn/", nil))
+
+			if values := w.Header()["X-Custom"]; !reflect.DeepEqual(values, test.want) {
+				t.Errorf("expected canonical header values %v, got %v", test.want, values)
+			}
+			for name := range w.Header() {
+				if name != "X-Custom" && strings.EqualFold(name, "X-Custom") {
+					t.Errorf("expected a single canonical header, also got %v", name)
+				}
+			}
+		})
+	}
+}
+
+func TestDefaultContentType(t *testing.T) {
+	setString(t, &defaultContentType, "application/json")
+	tests := []struct {
+		name        string
+		headers     map[string]string
+		body        string
+		contentType string
+	}{
+		{name: "missing", body: "{}", contentType: "application/json"},
+		{name: "set by function", headers: map[string]string{"content-type": "text/html"}, body: "<p>hi</p>", contentType: "text/html"},
+		{name: "no body"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeInvoker{respond: respondWith(proxyOutput(http.StatusOK, tt.headers, tt.body), nil)}
+
+			w := serve(newFakeClients(fake), httptest.NewRequest(http.MethodGet, "/fn/", nil))
+
+			if contentType := w.Header().Values("Content-Type"); !reflect.DeepEqual(contentType, nonEmpty(tt.contentType)) {
+				t.Errorf("expected content type %q, got %v", tt.contentType, contentType)
+			}
+		})
+	}
+}
+
+func TestBinaryResponses(t *testing.T) {
+	png := []byte{0x89, 'P', 'N', 'G', 0x00, 0xff}
+	encoded := b64.StdEncoding.EncodeToString(png)
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		flagged     bool
+		detect      bool
+		want        []byte
+		warned      bool
+	}{
+		{name: "flagged", contentType: "image/png", body: encoded, flagged: true, want: png},
+		{name: "unflagged", contentType: "image/png", body: encoded, want: []byte(encoded), warned: true},
+		{name: "unflagged with detection", contentType: "image/png", body: encoded, detect: true, want: png},
+		{name: "unflagged invalid base64 with detection", contentType: "image/png", body: "not base64!", detect: true, want: []byte("not base64!"), warned: true},
+		{name: "unflagged text", contentType: "text/plain", body: encoded, detect: true, want: []byte(encoded)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setBool(t, &detectBase64Response, tt.detect)
+			logger := logrus.StandardLogger()
+			hook := logtest.NewLocal(logger)
+			t.Cleanup(func() { logger.ReplaceHooks(make(logrus.LevelHooks)) })
+			fake := &fakeInvoker{respond: respondWith(payloadOutput(events.APIGatewayProxyResponse{
+				StatusCode:      http.StatusOK,
+				Headers:         map[string]string{"Content-Type": tt.contentType},
+				Body:            tt.body,
+				IsBase64Encoded: tt.flagged,
+			}), nil)}
+
+			w := serve(newFakeClients(fake), httptest.NewRequest(http.MethodGet, "/fn/", nil))
+
+			if !bytes.Equal(w.Body.Bytes(), tt.want) {
+				t.Errorf("expected body %q, got %q", tt.want, w.Body.Bytes())
+			}
+			warned := false
+			for _, entry := range hook.AllEntries() {
+				if entry.Level == logrus.WarnLevel && strings.Contains(entry.Message, "not flagged as base64") {
+					warned = true
+				}
+			}
+			if warned != tt.warned {
+				t.Errorf("expected warning to be %v, got %v", tt.warned, warned)
+			}
+		})
+	}
+}
+
+// nonEmpty returns the value as a slice, or nil if it is empty.
+func nonEmpty(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return []string{value}
+}
+
+func TestMaxResponseSize(t *testing.T) {
+	setInt64(t, &maxResponseSize, 16)
+	tests := []struct {
+		name       string
+		body       string
+		base64     bool
+		statusCode int
+	}{
+		{name: "within limit", body: "0123456789abcdef", statusCode: http.StatusOK},
+		{name: "over limit", body: "0123456789abcdefg", statusCode: http.StatusBadGateway},
+		// the encoded body is 24 bytes, but the limit applies to the decoded body
+		{name: "decoded within limit", body: b64.StdEncoding.EncodeToString([]byte("0123456789abcdef")), base64: true, statusCode: http.StatusOK},
+		{name: "decoded over limit", body: b64.StdEncoding.EncodeToString([]byte("0123456789abcdefg")), base64: true, statusCode: http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeInvoker{respond: respondWith(payloadOutput(events.APIGatewayProxyResponse{
+				StatusCode:      http.StatusOK,
+				Headers:         map[string]string{"Content-Type": "application/octet-stream"},
+				Body:            tt.body,
+				IsBase64Encoded: tt.base64,
+			}), nil)}
+
+			w := serve(newFakeClients(fake), httptest.NewRequest(http.MethodGet, "/fn/", nil))
+
+			if w.Code != tt.statusCode {
+				t.Fatalf("expected %v, got %v: %s", tt.statusCode, w.Code, w.Body)
+			}
+			if tt.statusCode != http.StatusOK && !strings.Contains(w.Body.String(), errResponseTooLarge.Error()) {
+				t.Errorf("expected the response size error, got %s", w.Body)
+			}
+		})
+	}
+}
+
+// flushCountingWriter records the number of times the response is flushed.
+type flushCountingWriter struct {
+	*httptest.ResponseRecorder
+	flushes int
+}
+
+func (w *flushCountingWriter) Flush() {
+	w.flushes++
+	w.ResponseRecorder.Flush()
+}
+
+func TestStreamedResponse(t *testing.T) {
+	setInt(t, &streamThreshold, 1024)
+	binary := make([]byte, 4*streamChunkSize+10)
+	for i := range binary {
+		binary[i] = byte(i)
+	}
+	encoded := b64.StdEncoding.EncodeToString(binary)
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		threshold   int
+		streamed    bool
+	}{
+		{name: "large binary", contentType: "application/octet-stream", body: encoded, threshold: 1024, streamed: true},
+		{name: "below threshold", contentType: "application/octet-stream", body: encoded, threshold: len(encoded)},
+		{name: "disabled", contentType: "application/octet-stream", body: encoded},
+		{name: "text", contentType: "text/plain", body: encoded, threshold: 1024},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setInt(t, &streamThreshold, tt.threshold)
+			fake := &fakeInvoker{respond: respondWith(payloadOutput(events.APIGatewayProxyResponse{
+				StatusCode:      http.StatusOK,
+				Headers:         map[string]string{"Content-Type": tt.contentType},
+				Body:            tt.body,
+				IsBase64Encoded: true,
+			}), nil)}
+			setReady()
+			w := &flushCountingWriter{ResponseRecorder: httptest.NewRecorder()}
+
+			handler(w, httptest.NewRequest(http.MethodGet, "/fn/", nil), newFakeClients(fake))
+
+			if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), binary) {
+				t.Fatalf("expected the decoded body, got %v with %v bytes", w.Code, w.Body.Len())
+			}
+			if streamed := w.flushes > 1; streamed != tt.streamed {
+				t.Errorf("expected streamed to be %v, got %v flushes", tt.streamed, w.flushes)
+			}
+		})
+	}
+}
+
+func TestStreamedResponseNotNeededInMemory(t *testing.T) {
+	setInt(t, &streamThreshold, 1024)
+	setRouteMap(t, `{"/templated":{"function":"fn","responseTemplate":"{{.StatusCode}}"}}`)
+	tests := []struct {
+		name     string
+		path     string
+		header   string
+		streamed bool
+	}{
+		{name: "plain request", path: "/fn/", streamed: true},
+		{name: "idempotent request", path: "/fn/", header: idempotencyKeyHeader, streamed: false},
+		{name: "templated route", path: "/templated", streamed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := idempotencyCache
+			idempotencyCache = newIdempotencyCache(10)
+			t.Cleanup(func() { idempotencyCache = previous })
+			body := b64.StdEncoding.EncodeToString(make([]byte, 4*streamChunkSize))
+			fake := &fakeInvoker{respond: respondWith(payloadOutput(events.APIGatewayProxyResponse{
+				StatusCode:      http.StatusOK,
+				Headers:         map[string]string{"Content-Type": "application/octet-stream"},
+				Body:            body,
+				IsBase64Encoded: true,
+			}), nil)}
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, "key-"+tt.name)
+			}
+			setReady()
+			w := &flushCountingWriter{ResponseRecorder: httptest.NewRecorder()}
+
+			handler(w, req, newFakeClients(fake))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %v: %s", w.Code, w.Body)
+			}
+			if streamed := w.flushes > 1; streamed != tt.streamed {
+				t.Errorf("expected streamed to be %v, got %v flushes", tt.streamed, w.flushes)
+			}
+		})
+	}
+}
+
+func TestStreamedResponseSizeLimit(t *testing.T) {
+	setInt(t, &streamThreshold, 1024)
+	setInt64(t, &maxResponseSize, 4096)
+	tests := []struct {
+		name       string
+		size       int
+		statusCode int
+	}{
+		{name: "at limit", size: 4096, statusCode: http.StatusOK},
+		{name: "at limit with padding", size: 4095, statusCode: http.StatusOK},
+		{name: "over limit", size: 4097, statusCode: http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeInvoker{respond: respondWith(payloadOutput(events.APIGatewayProxyResponse{
+				StatusCode:      http.StatusOK,
+				Headers:         map[string]string{"Content-Type": "application/octet-stream"},
+				Body:            b64.StdEncoding.EncodeToString(make([]byte, tt.size)),
+				IsBase64Encoded: true,
+			}), nil)}
+
+			w := serve(newFakeClients(fake), httptest.NewRequest(http.MethodGet, "/fn/", nil))
+
+			if w.Code != tt.statusCode {
+				t.Fatalf("expected %v, got %v: %s", tt.statusCode, w.Code, w.Body)
+			}
+			if tt.statusCode == http.StatusOK && w.Body.Len() != tt.size {
+				t.Errorf("expected %v bytes, got %v", tt.size, w.Body.Len())
+			}
+		})
+	}
+}
+
+func TestStreamedResponseInvalidBase64(t *testing.T) {
+	setInt(t, &streamThreshold, 1024)
+	body := b64.StdEncoding.EncodeToString(make([]byte, 4*streamChunkSize))
+	body = body[:len(body)/2] + "!" + body[len(body)/2+1:]
+	fake := &fakeInvoker{respond: respondWith(payloadOutput(events.APIGatewayProxyResponse{
+		StatusCode:      http.StatusOK,
+		Headers:         map[string]string{"Content-Type": "application/octet-stream"},
+		Body:            body,
+		IsBase64Encoded: true,
+	}), nil)}
+
+	defer func() {
+		if recovered := recover(); recovered != http.ErrAbortHandler {
+			t.Errorf("expected the response to be aborted, got %v", recovered)
+		}
+	}()
+	serve(newFakeClients(fake), httptest.NewRequest(http.MethodGet, "/fn/", nil))
+}
+
+// discardFlusher discards the response, so benchmarks measure the memory used
+// by the gateway, rather than by recording the response.
+type discardFlusher struct {
+	header http.Header
+}
+
+func (w *discardFlusher) Header() http.Header         { return w.header }
+func (w *discardFlusher) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardFlusher) WriteHeader(int)             {}
+func (w *discardFlusher) Flush()                      {}
+
+// BenchmarkLargeResponse compares the memory used to send a large binary
+// response when it is decoded in memory first, and when it is streamed.
+func BenchmarkLargeResponse(b *testing.B) {
+	logger := logrus.StandardLogger()
+	previousOut := logger.Out
+	b.Cleanup(func() { logger.SetOutput(previousOut) })
+	logger.SetOutput(io.Discard)
+
+	payload := payloadOutput(events.APIGatewayProxyResponse{
+		StatusCode:      http.StatusOK,
+		Headers:         map[string]string{"Content-Type": "application/octet-stream"},
+		Body:            b64.StdEncoding.EncodeToString(make([]byte, 4<<20)),
+		IsBase64Encoded: true,
+	})
+	thresholds := map[string]int{"buffered": 0, "streamed": 1 << 20}
+	for name, threshold := range thresholds {
+		b.Run(name, func(b *testing.B) {
+			setInt(b, &streamThreshold, threshold)
+			clients := newFakeClients(&fakeInvoker{respond: respondWith(payload, nil)})
+			setReady()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				handler(&discardFlusher{header: make(http.Header)}, httptest.NewRequest(http.MethodGet, "/fn/", nil), clients)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/sirupsen/logrus"
+	"math/rand"
+	"net"
+	"time"
+)
+
+const retryBaseDelay = 100 * time.Millisecond
+
+// retryableErrorCodes are the error codes indicating a transient failure. As
+// retries are disabled in the SDK, these include network and response errors,
+// such as connection resets and DNS failures.
+var retryableErrorCodes = map[string]bool{
+	lambda.ErrCodeTooManyRequestsException:  true,
+	lambda.ErrCodeServiceException:          true,
+	lambda.ErrCodeEC2ThrottledException:     true,
+	lambda.ErrCodeResourceNotReadyException: true,
+	request.ErrCodeRequestError:             true,
+	request.ErrCodeSerialization:            true,
+	request.ErrCodeResponseTimeout:          true,
+}
+
+// invokeWithRetry invokes the function, retrying transient errors with
+// exponential backoff and full jitter, up to the configured number of retries.
+func invokeWithRetry(ctx context.Context, log *logrus.Entry, client invoker, input *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+	var attempt int
+	for {
+		result, err := client.InvokeWithContext(ctx, input)
+		if err == nil || attempt >= maxRetries || !isRetryable(err) {
+			return result, err
+		}
+
+		delay := time.Duration(rand.Int63n(int64(retryBaseDelay << attempt)))
+		attempt++
+		log.Warnf("retrying invocation of %v in %v (attempt %d of %d): %v", *input.FunctionName, delay, attempt, maxRetries, err)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+func isRetryable(err error) bool {
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		if retryableErrorCodes[reqErr.Code()] {
+			return true
+		}
+		return reqErr.StatusCode() >= 500
+	}
+	if awsErr, ok := err.(awserr.Error); ok {
+		return retryableErrorCodes[awsErr.Code()]
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// failTimes returns a respond function that fails with the error the given
+// number of times, then succeeds.
+func failTimes(failures int, err error) func(aws.Context, *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+	var attempts int
+	return func(aws.Context, *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+		attempts++
+		if attempts <= failures {
+			return nil, err
+		}
+		return proxyOutput(http.StatusOK, nil, "ok"), nil
+	}
+}
+
+func TestInvokeRetries(t *testing.T) {
+	setInt(t, &maxRetries, 2)
+	throttled := awserr.NewRequestFailure(awserr.New(lambda.ErrCodeTooManyRequestsException, "rate exceeded", nil), http.StatusTooManyRequests, "")
+	notFound := awserr.NewRequestFailure(awserr.New(lambda.ErrCodeResourceNotFoundException, "not found", nil), http.StatusNotFound, "")
+
+	tests := []struct {
+		name        string
+		failures    int
+		err         error
+		statusCode  int
+		invocations int
+	}{
+		{name: "fails twice then succeeds", failures: 2, err: throttled, statusCode: http.StatusOK, invocations: 3},
+		{name: "retries exhausted", failures: 3, err: throttled, statusCode: http.StatusTooManyRequests, invocations: 3},
+		{name: "not retryable", failures: 1, err: notFound, statusCode: http.StatusNotFound, invocations: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeInvoker{respond: failTimes(tt.failures, tt.err)}
+
+			w := serve(newFakeClients(fake), httptest.NewRequest(http.MethodGet, "/fn/", nil))
+
+			if w.Code != tt.statusCode {
+				t.Errorf("expected %v, got %v: %s", tt.statusCode, w.Code, w.Body)
+			}
+			if invocations := len(fake.invocations()); invocations != tt.invocations {
+				t.Errorf("expected %v invocations, got %v", tt.invocations, invocations)
+			}
+		})
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{name: "throttled", err: awserr.New(lambda.ErrCodeTooManyRequestsException, "", nil), retryable: true},
+		{name: "service error", err: awserr.NewRequestFailure(awserr.New("InternalError", "", nil), http.StatusInternalServerError, ""), retryable: true},
+		{name: "request error", err: awserr.New(request.ErrCodeRequestError, "", nil), retryable: true},
+		{name: "network error", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, retryable: true},
+		{name: "client error", err: awserr.NewRequestFailure(awserr.New("InvalidParameterValueException", "", nil), http.StatusBadRequest, ""), retryable: false},
+		{name: "not found", err: awserr.New(lambda.ErrCodeResourceNotFoundException, "", nil), retryable: false},
+		{name: "other error", err: errors.New("boom"), retryable: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if retryable := isRetryable(tt.err); retryable != tt.retryable {
+				t.Errorf("expected retryable to be %v", tt.retryable)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package routing
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"lambdahttpgw/config"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// route maps a path prefix to a function. The prefix may be a template, in
+// which segments such as '{id}' match any value of that segment.
+type route struct {
+	Prefix         string
+	FunctionName   string
+	AllowedMethods []string
+	// RequestTemplate reshapes the request body, if set.
+	RequestTemplate *template.Template
+	// ResponseTemplate reshapes the response body, if set.
+	ResponseTemplate *template.Template
+	segments         []string
+	templated        bool
+}
+
+// Match describes the function resolved for a request path.
+type Match struct {
+	FunctionName string
+	// Path is the request path passed to the function.
+	Path string
+	// Resource is the route template matched, if any.
+	Resource string
+	// PathParameters holds the values of the template parameters.
+	PathParameters map[string]string
+	// AllowedMethods are the HTTP methods accepted by the route, where
+	// empty means all methods.
+	AllowedMethods []string
+	// RequestTemplate reshapes the request body, if set.
+	RequestTemplate *template.Template
+	// ResponseTemplate reshapes the response body, if set.
+	ResponseTemplate *template.Template
+}
+
+// templateFuncs are available to request templates.
+var templateFuncs = template.FuncMap{
+	// json encodes a value as JSON, such as to embed the body as a string
+	"json": func(value interface{}) (string, error) {
+		encoded, err := json.Marshal(value)
+		return string(encoded), err
+	},
+}
+
+// regexRoute maps request paths matching the pattern to a function, where
+// the function name may refer to capture groups.
+type regexRoute struct {
+	pattern      *regexp.Regexp
+	functionName string
+}
+
+var (
+	routes            []route
+	regexRoutes       []regexRoute
+	hostRoutingDomain = config.GetHostRoutingDomain()
+)
+
+// Init loads the configured routes, ordered so that the longest
+// prefix is matched first, replacing any routes loaded previously.
+func Init() {
+	routes, regexRoutes = nil, nil
+	for prefix, target := range config.GetRouteMap() {
+		prefix = "/" + strings.Trim(prefix, "/")
+		var segments []string
+		if prefix != "/" {
+			segments = strings.Split(strings.TrimPrefix(prefix, "/"), "/")
+		}
+		var allowedMethods []string
+		for _, method := range target.Methods {
+			allowedMethods = append(allowedMethods, strings.ToUpper(method))
+		}
+		var requestTemplate, responseTemplate *template.Template
+		if target.RequestTemplate != "" {
+			var err error
+			requestTemplate, err = template.New(prefix).Funcs(templateFuncs).Parse(target.RequestTemplate)
+			if err != nil {
+				logrus.Fatalf("invalid request template for route %v: %v", prefix, err)
+			}
+		}
+		if target.ResponseTemplate != "" {
+			var err error
+			// missing fields are errors, so responses without the expected shape are returned unchanged
+			responseTemplate, err = template.New(prefix).Funcs(templateFuncs).Option("missingkey=error").Parse(target.ResponseTemplate)
+			if err != nil {
+				logrus.Fatalf("invalid response template for route %v: %v", prefix, err)
+			}
+		}
+		routes = append(routes, route{
+			Prefix:           prefix,
+			FunctionName:     target.Function,
+			AllowedMethods:   allowedMethods,
+			RequestTemplate:  requestTemplate,
+			ResponseTemplate: responseTemplate,
+			segments:         segments,
+			templated:        strings.Contains(prefix, "{"),
+		})
+	}
+	// routes with more segments are more specific, as are literal routes
+	// over templates with the same number of segments
+	sort.Slice(routes, func(i, j int) bool {
+		if len(routes[i].segments) != len(routes[j].segments) {
+			return len(routes[i].segments) > len(routes[j].segments)
+		}
+		if routes[i].templated != routes[j].templated {
+			return !routes[i].templated
+		}
+		return routes[i].Prefix < routes[j].Prefix
+	})
+	for _, regexRouteConfig := range config.GetRegexRoutes() {
+		pattern, err := regexp.Compile(regexRouteConfig.Pattern)
+		if err != nil {
+			logrus.Fatalf("invalid regex route pattern %v: %v", regexRouteConfig.Pattern, err)
+		}
+		regexRoutes = append(regexRoutes, regexRoute{pattern: pattern, functionName: regexRouteConfig.Function})
+	}
+	logrus.Debugf("loaded %d routes and %d regex routes", len(routes), len(regexRoutes))
+}
+
+// Resolve determines the function for the given request path, and the
+// path that should be passed to the function.
+//
+// Prefix routes are checked first, followed by regex routes, in order. If no
+// configured route matches, the first path segment is used as the function
+// name, and the remainder as the function path.
+func Resolve(requestPath string) (*Match, error) {
+	requestSegments := strings.Split(strings.TrimPrefix(requestPath, "/"), "/")
+	for _, r := range routes {
+		if match, matched := r.match(requestSegments); matched {
+			return match, nil
+		}
+	}
+	for _, r := range regexRoutes {
+		if match, matched := r.match(requestPath); matched {
+			return match, nil
+		}
+	}
+
+	splitPath := strings.SplitN(strings.TrimPrefix(requestPath, "/"), "/", 2)
+
+	path := "/"
+	if len(splitPath) >= 1 && splitPath[0] != "" {
+		if len(splitPath) >= 2 {
+			path = "/" + splitPath[1]
+		}
+	} else {
+		return nil, fmt.Errorf("path must include function name and request path")
+	}
+	return &Match{FunctionName: splitPath[0], Path: path}, nil
+}
+
+// match checks whether the request path falls under the route prefix, on a
+// path segment boundary, extracting the values of any template parameters.
+// The remainder of the path is passed to the function.
+func (r route) match(requestSegments []string) (*Match, bool) {
+	if len(requestSegments) < len(r.segments) {
+		return nil, false
+	}
+	var pathParameters map[string]string
+	for i, segment := range r.segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if requestSegments[i] == "" {
+				return nil, false
+			}
+			if pathParameters == nil {
+				pathParameters = make(map[string]string)
+			}
+			value, err := url.PathUnescape(requestSegments[i])
+			if err != nil {
+				value = requestSegments[i]
+			}
+			pathParameters[strings.Trim(segment, "{}")] = value
+		} else if requestSegments[i] != segment {
+			return nil, false
+		}
+	}
+
+	match := &Match{
+		FunctionName:     r.FunctionName,
+		Path:             "/" + strings.Join(requestSegments[len(r.segments):], "/"),
+		PathParameters:   pathParameters,
+		AllowedMethods:   r.AllowedMethods,
+		RequestTemplate:  r.RequestTemplate,
+		ResponseTemplate: r.ResponseTemplate,
+	}
+	if r.templated {
+		match.Resource = r.Prefix
+	}
+	return match, true
+}
+
+// match checks whether the pattern matches the start of the request path, on
+// a path segment boundary, expanding capture groups in the function name.
+// The remainder of the path is passed to the function.
+func (r regexRoute) match(requestPath string) (*Match, bool) {
+	loc := r.pattern.FindStringSubmatchIndex(requestPath)
+	if loc == nil || loc[0] != 0 {
+		return nil, false
+	}
+	remainder := requestPath[loc[1]:]
+	if remainder != "" && !strings.HasPrefix(remainder, "/") {
+		return nil, false
+	}
+	functionName := string(r.pattern.ExpandString(nil, r.functionName, requestPath, loc))
+	if functionName == "" {
+		return nil, false
+	}
+	return &Match{FunctionName: functionName, Path: "/" + strings.TrimPrefix(remainder, "/")}, true
+}
+
+// ResolveHost determines the function name from the leftmost subdomain of
+// the request host. If a base domain is configured, it is stripped from the
+// host first, so a request to 'users.api.example.com' with the base
+// domain 'api.example.com' resolves to the 'users' function.
+func ResolveHost(host string) (functionName string, err error) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+
+	if hostRoutingDomain != "" {
+		suffix := "." + strings.ToLower(strings.Trim(hostRoutingDomain, "."))
+		if !strings.HasSuffix(host, suffix) {
+			return "", fmt.Errorf("host %v is not a subdomain of %v", host, hostRoutingDomain)
+		}
+		host = strings.TrimSuffix(host, suffix)
+	}
+
+	functionName = strings.SplitN(host, ".", 2)[0]
+	if functionName == "" {
+		return "", fmt.Errorf("host must include function name")
+	}
+	return functionName, nil
+}
@@ -0,0 +1,148 @@
+package routing
+
+import (
+	"reflect"
+	"testing"
+)
+
+// initRoutes loads the route map for the duration of the test.
+func initRoutes(t *testing.T, routeMap string) {
+	t.Setenv("ROUTE_MAP", routeMap)
+	Init()
+	t.Cleanup(func() { routes, regexRoutes = nil, nil })
+}
+
+func TestResolve(t *testing.T) {
+	initRoutes(t, `{"/users":"user-fn","/users/admin":"admin-fn","/orders/":"order-fn"}`)
+
+	tests := []struct {
+		name         string
+		requestPath  string
+		functionName string
+		path         string
+	}{
+		{name: "prefix", requestPath: "/users/123", functionName: "user-fn", path: "/123"},
+		{name: "exact prefix", requestPath: "/users", functionName: "user-fn", path: "/"},
+		{name: "longest prefix", requestPath: "/users/admin/settings", functionName: "admin-fn", path: "/settings"},
+		{name: "trailing slash in route", requestPath: "/orders/42", functionName: "order-fn", path: "/42"},
+		{name: "segment boundary", requestPath: "/usersettings/1", functionName: "usersettings", path: "/1"},
+		{name: "fallback", requestPath: "/other-fn/a/b", functionName: "other-fn", path: "/a/b"},
+		{name: "fallback without path", requestPath: "/other-fn", functionName: "other-fn", path: "/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, err := Resolve(tt.requestPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if match.FunctionName != tt.functionName || match.Path != tt.path {
+				t.Errorf("expected %v %v, got %v %v", tt.functionName, tt.path, match.FunctionName, match.Path)
+			}
+		})
+	}
+}
+
+func TestResolveWithoutFunction(t *testing.T) {
+	initRoutes(t, "")
+	if _, err := Resolve("/"); err == nil {
+		t.Error("expected an error for a path without a function name")
+	}
+}
+
+func TestResolveHost(t *testing.T) {
+	tests := []struct {
+		name         string
+		domain       string
+		host         string
+		functionName string
+		valid        bool
+	}{
+		{name: "leftmost subdomain", host: "users.api.example.com", functionName: "users", valid: true},
+		{name: "port", host: "users.api.example.com:8080", functionName: "users", valid: true},
+		{name: "case", host: "Users.API.example.com", functionName: "users", valid: true},
+		{name: "base domain", domain: "api.example.com", host: "users.api.example.com", functionName: "users", valid: true},
+		{name: "nested subdomain", domain: "example.com", host: "v2.users.example.com", functionName: "v2", valid: true},
+		{name: "outside base domain", domain: "api.example.com", host: "users.other.com"},
+		{name: "base domain only", domain: "api.example.com", host: "api.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := hostRoutingDomain
+			hostRoutingDomain = tt.domain
+			t.Cleanup(func() { hostRoutingDomain = previous })
+
+			functionName, err := ResolveHost(tt.host)
+			if (err == nil) != tt.valid {
+				t.Fatalf("expected valid to be %v, got error %v", tt.valid, err)
+			}
+			if functionName != tt.functionName {
+				t.Errorf("expected %q, got %q", tt.functionName, functionName)
+			}
+		})
+	}
+}
+
+func TestResolveTemplate(t *testing.T) {
+	initRoutes(t, `{"/users/{id}/orders":"order-fn","/users/{id}":"user-fn","/users/me":"me-fn"}`)
+
+	tests := []struct {
+		name           string
+		requestPath    string
+		functionName   string
+		path           string
+		resource       string
+		pathParameters map[string]string
+	}{
+		{name: "template", requestPath: "/users/123", functionName: "user-fn", path: "/", resource: "/users/{id}", pathParameters: map[string]string{"id": "123"}},
+		{name: "remaining path", requestPath: "/users/123/profile", functionName: "user-fn", path: "/profile", resource: "/users/{id}", pathParameters: map[string]string{"id": "123"}},
+		{name: "longer template", requestPath: "/users/123/orders/9", functionName: "order-fn", path: "/9", resource: "/users/{id}/orders", pathParameters: map[string]string{"id": "123"}},
+		{name: "literal preferred", requestPath: "/users/me", functionName: "me-fn", path: "/"},
+		{name: "encoded parameter", requestPath: "/users/a%20b", functionName: "user-fn", path: "/", resource: "/users/{id}", pathParameters: map[string]string{"id": "a b"}},
+		{name: "empty parameter", requestPath: "/users/", functionName: "users", path: "/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, err := Resolve(tt.requestPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if match.FunctionName != tt.functionName || match.Path != tt.path || match.Resource != tt.resource {
+				t.Errorf("expected %v %v %v, got %v %v %v", tt.functionName, tt.path, tt.resource, match.FunctionName, match.Path, match.Resource)
+			}
+			if !reflect.DeepEqual(match.PathParameters, tt.pathParameters) {
+				t.Errorf("expected path parameters %v, got %v", tt.pathParameters, match.PathParameters)
+			}
+		})
+	}
+}
+
+func TestResolveRegex(t *testing.T) {
+	t.Setenv("REGEX_ROUTES", `[{"pattern":"^/v(\\d+)/users$","function":"user-service-v$1"},{"pattern":"^/v(\\d+)/(\\w+)","function":"${2}-v$1"},{"pattern":"^/api/(?P<name>[a-z]+)","function":"api-$name"}]`)
+	initRoutes(t, `{"/v1/orders":"legacy-orders"}`)
+
+	tests := []struct {
+		name         string
+		requestPath  string
+		functionName string
+		path         string
+	}{
+		{name: "first matching pattern", requestPath: "/v2/users", functionName: "user-service-v2", path: "/"},
+		{name: "anchored pattern", requestPath: "/v2/users/123", functionName: "users-v2", path: "/123"},
+		{name: "multiple capture groups", requestPath: "/v3/orders/42", functionName: "orders-v3", path: "/42"},
+		{name: "named capture group", requestPath: "/api/items", functionName: "api-items", path: "/"},
+		{name: "prefix route takes precedence", requestPath: "/v1/orders/7", functionName: "legacy-orders", path: "/7"},
+		{name: "segment boundary", requestPath: "/api/items2", functionName: "api", path: "/items2"},
+		{name: "not matching", requestPath: "/users/1", functionName: "users", path: "/1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, err := Resolve(tt.requestPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if match.FunctionName != tt.functionName || match.Path != tt.path {
+				t.Errorf("expected %v %v, got %v %v", tt.functionName, tt.path, match.FunctionName, match.Path)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/sirupsen/logrus"
+	"lambdahttpgw/config"
+)
+
+var (
+	// shadowFunction receives a copy of each request, such as to test a new
+	// implementation against production traffic.
+	shadowFunction = config.GetShadowFunction()
+
+	// shadowSlots limits the number of in-flight shadow invocations, so a slow
+	// shadow function cannot exhaust the gateway's resources.
+	shadowSlots   = newInvokeSlots(config.GetShadowMaxConcurrency())
+	shadowTimeout = config.GetShadowTimeout()
+)
+
+// mirrorRequest invokes the shadow function asynchronously with a copy of the
+// request, without waiting for the invocation to be queued. The shadow
+// function response is discarded, and errors are only logged. If the shadow
+// concurrency limit has been reached, the request is not mirrored.
+func mirrorRequest(log *logrus.Entry, clients *clientCache, proxyReq *proxyRequest) {
+	if shadowFunction == "" {
+		return
+	}
+	name, qualifier, err := parseFunctionName(shadowFunction, "")
+	if err != nil {
+		log.Errorf("invalid shadow function: %v", err)
+		return
+	}
+	functionRegion, err := resolveRegion(name, "")
+	if err != nil {
+		log.Errorf("invalid shadow function region: %v", err)
+		return
+	}
+
+	shadowReq := *proxyReq
+	shadowReq.FunctionName = name
+	shadowReq.Qualifier = qualifier
+	shadowReq.Region = functionRegion
+	shadowReq.Role = role{}
+	shadowReq.InvocationType = lambda.InvocationTypeEvent
+
+	if !acquireSlot(shadowSlots) {
+		log.Debugf("not mirroring request to shadow function %v - concurrency limit reached", name)
+		return
+	}
+	go func() {
+		defer releaseSlot(shadowSlots)
+		// the shadow invocation is not tied to the client request, so continues if it completes first
+		ctx, cancel := context.WithTimeout(context.Background(), shadowTimeout)
+		defer cancel()
+		if _, err := invoke(ctx, log, clients.get(functionRegion, role{}), &shadowReq); err != nil {
+			log.Warnf("failed to mirror request to shadow function %v: %v", name, err)
+		}
+	}()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// setShadowFunction mirrors requests to the function for the duration of
+// the test, with at most the given number of in-flight shadow invocations.
+func setShadowFunction(t *testing.T, functionName string, maxConcurrency int) {
+	setString(t, &shadowFunction, functionName)
+	previous := shadowSlots
+	shadowSlots = newInvokeSlots(maxConcurrency)
+	t.Cleanup(func() { shadowSlots = previous })
+}
+
+func TestShadowInvocation(t *testing.T) {
+	setShadowFunction(t, "shadow-fn", 1)
+	shadowed, release := make(chan *lambda.InvokeInput, 10), make(chan struct{})
+	fake := &fakeInvoker{respond: func(_ aws.Context, input *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+		if aws.StringValue(input.FunctionName) == "shadow-fn" {
+			shadowed <- input
+			<-release
+			return &lambda.InvokeOutput{StatusCode: aws.Int64(http.StatusAccepted)}, nil
+		}
+		return proxyOutput(http.StatusOK, map[string]string{"X-From": "primary"}, "primary"), nil
+	}}
+	clients := newFakeClients(fake)
+
+	// the shadow invocation is blocked, so the primary response cannot wait for it
+	w := serve(clients, httptest.NewRequest(http.MethodGet, "/fn/items", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "primary" || w.Header().Get("X-From") != "primary" {
+		t.Errorf("expected the primary response, got %v %q", w.Code, w.Body)
+	}
+	var input *lambda.InvokeInput
+	select {
+	case input = <-shadowed:
+	case <-time.After(time.Second):
+		t.Fatal("expected the shadow function to be invoked")
+	}
+	if invocationType := aws.StringValue(input.InvocationType); invocationType != lambda.InvocationTypeEvent {
+		t.Errorf("expected an event invocation of the shadow function, got %v", invocationType)
+	}
+
+	// the shadow slot is in use, so this request is not mirrored
+	if w = serve(clients, httptest.NewRequest(http.MethodGet, "/fn/items", nil)); w.Code != http.StatusOK {
+		t.Errorf("expected the primary response, got %v", w.Code)
+	}
+	close(release)
+	for deadline := time.Now().Add(time.Second); len(shadowSlots) > 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+
+	var primary, shadow int
+	for _, input := range fake.invocations() {
+		if aws.StringValue(input.FunctionName) == "shadow-fn" {
+			shadow++
+		} else {
+			primary++
+		}
+	}
+	if primary != 2 || shadow != 1 {
+		t.Errorf("expected 2 primary and 1 shadow invocations, got %v and %v", primary, shadow)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetSourceIP(t *testing.T) {
+	tests := []struct {
+		name         string
+		trustProxy   bool
+		hops         int
+		remoteAddr   string
+		forwardedFor []string
+		expected     string
+	}{
+		{name: "direct", remoteAddr: "192.0.2.1:1234", expected: "192.0.2.1"},
+		{name: "direct without port", remoteAddr: "192.0.2.1", expected: "192.0.2.1"},
+		{name: "direct ipv6", remoteAddr: "[2001:db8::1]:1234", expected: "2001:db8::1"},
+		{name: "untrusted chain", remoteAddr: "192.0.2.1:1234", forwardedFor: []string{"198.51.100.1"}, expected: "192.0.2.1"},
+		{name: "single proxy", trustProxy: true, hops: 1, remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"198.51.100.1"}, expected: "198.51.100.1"},
+		{name: "spoofed entry", trustProxy: true, hops: 1, remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"203.0.113.9, 198.51.100.1"}, expected: "198.51.100.1"},
+		{name: "two proxies", trustProxy: true, hops: 2, remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"203.0.113.9, 198.51.100.1, 10.0.0.2"}, expected: "198.51.100.1"},
+		{name: "multiple headers", trustProxy: true, hops: 2, remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"203.0.113.9, 198.51.100.1", "10.0.0.2"}, expected: "198.51.100.1"},
+		{name: "shorter than hops", trustProxy: true, hops: 3, remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"198.51.100.1, 10.0.0.2"}, expected: "198.51.100.1"},
+		{name: "trusted without header", trustProxy: true, hops: 1, remoteAddr: "192.0.2.1:1234", expected: "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTrustProxy(t, tt.trustProxy, tt.hops)
+			req := httptest.NewRequest("GET", "/fn/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+			if actual := getSourceIP(req); actual != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func setTrustProxy(t *testing.T, trusted bool, hops int) {
+	previousTrust, previousHops := trustProxy, trustedProxyHops
+	trustProxy, trustedProxyHops = trusted, hops
+	t.Cleanup(func() {
+		trustProxy, trustedProxyHops = previousTrust, previousHops
+	})
+}
@@ -0,0 +1,69 @@
+package stats
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// histogramGrowth is the ratio between bucket bounds, so percentiles are
+	// accurate to within half of this, relative to the value.
+	histogramGrowth = 1.05
+
+	// histogramBuckets covers durations from 1 microsecond to over an hour.
+	histogramBuckets = 460
+)
+
+var logHistogramGrowth = math.Log(histogramGrowth)
+
+// histogram is a streaming histogram of durations, using exponentially sized
+// buckets, so percentiles can be estimated in constant memory, regardless of
+// the number of durations recorded.
+type histogram struct {
+	counts [histogramBuckets]int64
+	total  int64
+}
+
+func (h *histogram) record(duration time.Duration) {
+	h.counts[bucketIndex(duration)]++
+	h.total++
+}
+
+// merge adds the durations recorded by other to h.
+func (h *histogram) merge(other *histogram) {
+	for index, count := range other.counts {
+		h.counts[index] += count
+	}
+	h.total += other.total
+}
+
+func bucketIndex(duration time.Duration) int {
+	micros := float64(duration.Microseconds())
+	if micros < 1 {
+		return 0
+	}
+	index := int(math.Log(micros) / logHistogramGrowth)
+	if index >= histogramBuckets {
+		return histogramBuckets - 1
+	}
+	return index
+}
+
+// percentileMs estimates the duration, in milliseconds, below which the
+// given fraction of durations fall, such as 0.99 for the 99th percentile.
+func (h *histogram) percentileMs(fraction float64) float64 {
+	if h.total == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(fraction * float64(h.total)))
+	var cumulative int64
+	for index, count := range h.counts {
+		cumulative += count
+		if cumulative >= rank {
+			// the geometric midpoint of the bucket minimises the relative error
+			micros := math.Pow(histogramGrowth, float64(index)+0.5)
+			return math.Round(micros) / 1000
+		}
+	}
+	return 0
+}
@@ -0,0 +1,85 @@
+package stats
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestHistogramPercentiles(t *testing.T) {
+	tests := []struct {
+		name      string
+		durations func(i int) time.Duration
+		count     int
+		expected  map[float64]float64
+	}{
+		{
+			name:      "uniform",
+			durations: func(i int) time.Duration { return time.Duration(i+1) * time.Millisecond },
+			count:     1000,
+			expected:  map[float64]float64{0.5: 500, 0.9: 900, 0.99: 990},
+		},
+		{
+			name:      "constant",
+			durations: func(i int) time.Duration { return 250 * time.Millisecond },
+			count:     100,
+			expected:  map[float64]float64{0.5: 250, 0.9: 250, 0.99: 250},
+		},
+		{
+			name: "long tail",
+			durations: func(i int) time.Duration {
+				if i%100 == 99 {
+					return 5 * time.Second
+				}
+				return 20 * time.Millisecond
+			},
+			count:    10000,
+			expected: map[float64]float64{0.5: 20, 0.9: 20, 0.99: 20, 0.999: 5000},
+		},
+		{
+			name:      "sub-millisecond",
+			durations: func(i int) time.Duration { return time.Duration(100+i) * time.Microsecond },
+			count:     101,
+			expected:  map[float64]float64{0.5: 0.15, 0.99: 0.199},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var h histogram
+			for i := 0; i < tt.count; i++ {
+				h.record(tt.durations(i))
+			}
+			for fraction, expected := range tt.expected {
+				actual := h.percentileMs(fraction)
+				// bucket bounds grow by histogramGrowth, so the midpoint is
+				// within half of the growth of the true value
+				tolerance := expected * (histogramGrowth - 1) / 2
+				if math.Abs(actual-expected) > tolerance+0.001 {
+					t.Errorf("p%v: expected %vms ±%.3f, got %vms", fraction*100, expected, tolerance, actual)
+				}
+			}
+		})
+	}
+}
+
+func TestHistogramEmpty(t *testing.T) {
+	var h histogram
+	if actual := h.percentileMs(0.99); actual != 0 {
+		t.Errorf("expected 0 for empty histogram, got %v", actual)
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	var a, b histogram
+	for i := 0; i < 50; i++ {
+		a.record(10 * time.Millisecond)
+		b.record(100 * time.Millisecond)
+	}
+	a.merge(&b)
+	if a.total != 100 {
+		t.Errorf("expected 100 durations after merge, got %v", a.total)
+	}
+	if p90 := a.percentileMs(0.9); math.Abs(p90-100) > 2.5 {
+		t.Errorf("expected p90 of 100ms after merge, got %v", p90)
+	}
+}
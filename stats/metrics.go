@@ -0,0 +1,63 @@
+package stats
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+	"lambdahttpgw/config"
+	"strconv"
+	"time"
+)
+
+var (
+	requestsTotal  *prometheus.CounterVec
+	requestLatency prometheus.Histogram
+	invokeLatency  *prometheus.HistogramVec
+	funcErrors     *prometheus.CounterVec
+)
+
+// enableMetrics registers the request and invocation metrics,
+// which are recorded regardless of whether the stats recorder is enabled.
+func enableMetrics() {
+	logrus.Debugf("enabling metrics")
+
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Total number of requests (per status code).",
+	}, []string{"code"})
+
+	requestLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Request latency in seconds, including the function invocation.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	invokeLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "functions_invoke_duration_seconds",
+		Help:    "Function invocation latency in seconds (per function).",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"function"})
+
+	funcErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "functions_error_count",
+		Help: "Total number of failed invocations (per function).",
+	}, []string{"function"})
+}
+
+func RecordRequest(statusCode int, duration time.Duration) {
+	if !config.MetricsEnabled {
+		return
+	}
+	requestsTotal.WithLabelValues(strconv.Itoa(statusCode)).Inc()
+	requestLatency.Observe(duration.Seconds())
+}
+
+func RecordInvocation(functionName string, duration time.Duration, err error) {
+	if !config.MetricsEnabled {
+		return
+	}
+	invokeLatency.WithLabelValues(functionName).Observe(duration.Seconds())
+	if err != nil {
+		funcErrors.WithLabelValues(functionName).Inc()
+	}
+}
@@ -5,21 +5,43 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/sirupsen/logrus"
 	"lambdahttpgw/config"
+	"sync"
 	"time"
 )
 
 type Invocation struct {
 	FunctionName string
 	Duration     time.Duration
+	Failed       bool
+}
+
+// counters accumulates the invocations of a function.
+type counters struct {
+	Hits          int64         `json:"hits"`
+	Errors        int64         `json:"errors"`
+	TotalDuration time.Duration `json:"-"`
+	Durations     histogram     `json:"-"`
 }
 
 type statsHolder struct {
-	Hits       int64 `json:"hits"`
-	LastReport int64 `json:"lastReport"`
+	counters
+	// pending holds the invocations not yet sent by the reporter
+	pending counters
+}
+
+// FunctionStats is a point-in-time copy of the stats for a function.
+type FunctionStats struct {
+	Hits          int64   `json:"hits"`
+	Errors        int64   `json:"errors"`
+	AvgDurationMs float64 `json:"avgDurationMs"`
+	P50DurationMs float64 `json:"p50DurationMs"`
+	P90DurationMs float64 `json:"p90DurationMs"`
+	P99DurationMs float64 `json:"p99DurationMs"`
 }
 
 var (
 	functionStats   = map[string]*statsHolder{}
+	statsLock       sync.RWMutex
 	hitCh           chan Invocation
 	activeReqCh     chan int
 	funcInvocations *prometheus.CounterVec
@@ -67,19 +89,55 @@ func enableRecorder() {
 }
 
 func record(invocation Invocation) {
+	statsLock.Lock()
 	holder, exist := functionStats[invocation.FunctionName]
 	if !exist {
-		holder = &statsHolder{
-			Hits:       0,
-			LastReport: 0,
-		}
+		holder = &statsHolder{}
 		functionStats[invocation.FunctionName] = holder
 	}
-	holder.Hits++
+	holder.counters.record(invocation)
+	if config.StatsReporterEnabled {
+		holder.pending.record(invocation)
+	}
+	statsLock.Unlock()
+
 	funcInvocations.WithLabelValues(invocation.FunctionName).Inc()
 	funcDuration.WithLabelValues(invocation.FunctionName).Add(invocation.Duration.Seconds())
 }
 
+func (c *counters) record(invocation Invocation) {
+	if invocation.Failed {
+		c.Errors++
+	} else {
+		c.Hits++
+	}
+	c.TotalDuration += invocation.Duration
+	c.Durations.record(invocation.Duration)
+}
+
+// merge adds the invocations accumulated by other to c.
+func (c *counters) merge(other *counters) {
+	c.Hits += other.Hits
+	c.Errors += other.Errors
+	c.TotalDuration += other.TotalDuration
+	c.Durations.merge(&other.Durations)
+}
+
+func (c *counters) snapshot() FunctionStats {
+	var avgDurationMs float64
+	if invocations := c.Hits + c.Errors; invocations > 0 {
+		avgDurationMs = float64(c.TotalDuration.Milliseconds()) / float64(invocations)
+	}
+	return FunctionStats{
+		Hits:          c.Hits,
+		Errors:        c.Errors,
+		AvgDurationMs: avgDurationMs,
+		P50DurationMs: c.Durations.percentileMs(0.5),
+		P90DurationMs: c.Durations.percentileMs(0.9),
+		P99DurationMs: c.Durations.percentileMs(0.99),
+	}
+}
+
 func RecordHit(invocation Invocation) {
 	if !config.StatsRecorderEnabled {
 		return
@@ -91,10 +149,28 @@ func GetAllStats() map[string]*statsHolder {
 	return functionStats
 }
 
+// GetSnapshot returns a copy of the current stats for each function.
+func GetSnapshot() map[string]FunctionStats {
+	statsLock.RLock()
+	defer statsLock.RUnlock()
+
+	snapshot := make(map[string]FunctionStats, len(functionStats))
+	for funcName, holder := range functionStats {
+		snapshot[funcName] = holder.counters.snapshot()
+	}
+	return snapshot
+}
+
 func IncActiveRequests() {
+	if !config.StatsRecorderEnabled {
+		return
+	}
 	activeReqCh <- 1
 }
 
 func DecActiveRequests() {
+	if !config.StatsRecorderEnabled {
+		return
+	}
 	activeReqCh <- -1
 }
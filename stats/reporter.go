@@ -1,14 +1,17 @@
 package stats
 
 import (
-	"fmt"
+	"bytes"
+	"encoding/json"
 	"github.com/sirupsen/logrus"
 	"lambdahttpgw/config"
 	"net/http"
-	"strings"
 	"time"
 )
 
+// reportClient is used to send stats, so a slow server cannot stall reporting.
+var reportClient = &http.Client{Timeout: 10 * time.Second}
+
 func enableReporter() chan bool {
 	logrus.Debugf("enabling stats reporter to %s", config.StatsUrl)
 
@@ -30,52 +33,58 @@ func enableReporter() chan bool {
 	return done
 }
 
+// reportStats sends the stats accumulated since the last report, then resets
+// them. If the report fails, the stats are kept and included in the next one.
 func reportStats() {
 	logrus.Tracef("checking for pending stats")
 
-	var pending = map[string]*statsHolder{}
-	for funcName, holder := range GetAllStats() {
-		if holder.Hits > holder.LastReport {
-			pending[funcName] = holder
+	var pending = map[string]counters{}
+	statsLock.Lock()
+	for funcName, holder := range functionStats {
+		if holder.pending.Hits+holder.pending.Errors > 0 {
+			pending[funcName] = holder.pending
+			holder.pending = counters{}
 		}
 	}
+	statsLock.Unlock()
 	if len(pending) == 0 {
 		logrus.Tracef("no pending stats to report")
 		return
 	}
 
 	logrus.Debugf("reporting %d pending stats", len(pending))
-	for funcName, holder := range pending {
-		// cache hits to enable background update
-		hits := holder.Hits
-		due := hits - holder.LastReport
-		if due <= 0 {
-			continue
-		}
-		if success := sendStat(funcName, due); success {
-			holder.LastReport = hits
+	report := make(map[string]FunctionStats, len(pending))
+	for funcName, unreported := range pending {
+		report[funcName] = unreported.snapshot()
+	}
+	if success := sendStats(report); !success {
+		statsLock.Lock()
+		for funcName, unreported := range pending {
+			functionStats[funcName].pending.merge(&unreported)
 		}
+		statsLock.Unlock()
+		return
 	}
 	logrus.Debugf("reported %d pending stats", len(pending))
 }
 
-func sendStat(funcName string, amount int64) bool {
-	url := fmt.Sprintf("%s/hits/%s", config.StatsUrl, funcName)
-	reqBody := strings.NewReader(fmt.Sprintf("%d", amount))
-	request, err := http.NewRequest("PUT", url, reqBody)
+func sendStats(report map[string]FunctionStats) bool {
+	url := config.StatsUrl
+	reqBody, err := json.Marshal(report)
 	if err != nil {
-		logrus.Warnf("failed to report stats for %s to %s: %s", funcName, url, err)
+		logrus.Warnf("failed to marshal stats for %s: %s", url, err)
 		return false
 	}
-	response, err := http.DefaultClient.Do(request)
+	response, err := reportClient.Post(url, "application/json", bytes.NewReader(reqBody))
 	if err != nil {
-		logrus.Warnf("failed to report stats for %s to %s: %s", funcName, url, err)
+		logrus.Warnf("failed to report stats to %s: %s", url, err)
 		return false
 	}
+	defer response.Body.Close()
 	if response.StatusCode < 200 || response.StatusCode > 299 {
-		logrus.Warnf("failed to report stats for %s to %s - received status code: %d", funcName, url, response.StatusCode)
+		logrus.Warnf("failed to report stats to %s - received status code: %d", url, response.StatusCode)
 		return false
 	}
-	logrus.Tracef("reported stats for %s to %s - received status code: %d", funcName, url, response.StatusCode)
+	logrus.Tracef("reported stats to %s - received status code: %d", url, response.StatusCode)
 	return true
 }
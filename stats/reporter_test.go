@@ -0,0 +1,118 @@
+package stats
+
+import (
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus"
+	"lambdahttpgw/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// resetStats clears recorded stats and enables recording, without
+// registering the Prometheus collectors, for the duration of the test.
+func resetStats(t *testing.T) {
+	functionStats = map[string]*statsHolder{}
+	funcInvocations = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_invoked"}, []string{"function"})
+	funcDuration = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_duration"}, []string{"function"})
+	reporterEnabled := config.StatsReporterEnabled
+	config.StatsReporterEnabled = true
+	t.Cleanup(func() {
+		config.StatsReporterEnabled = reporterEnabled
+	})
+}
+
+func TestReporterSendsAndResetsStats(t *testing.T) {
+	resetStats(t)
+	reports := make(chan map[string]FunctionStats, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			t.Errorf("expected POST, got %v", req.Method)
+		}
+		if contentType := req.Header.Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("expected JSON content type, got %v", contentType)
+		}
+		var report map[string]FunctionStats
+		if err := json.NewDecoder(req.Body).Decode(&report); err != nil {
+			t.Errorf("invalid report body: %v", err)
+		}
+		reports <- report
+	}))
+	defer server.Close()
+
+	statsUrl := config.StatsUrl
+	config.StatsUrl = server.URL
+	defer func() { config.StatsUrl = statsUrl }()
+	t.Setenv("STATS_REPORT_INTERVAL", "10ms")
+
+	record(Invocation{FunctionName: "fn", Duration: 20 * time.Millisecond})
+	record(Invocation{FunctionName: "fn", Duration: 40 * time.Millisecond, Failed: true})
+
+	done := enableReporter()
+	defer close(done)
+
+	select {
+	case report := <-reports:
+		stats, ok := report["fn"]
+		if !ok {
+			t.Fatalf("expected stats for fn, got %v", report)
+		}
+		if stats.Hits != 1 || stats.Errors != 1 {
+			t.Errorf("expected 1 hit and 1 error, got %+v", stats)
+		}
+		if stats.AvgDurationMs != 30 {
+			t.Errorf("expected average of 30ms, got %v", stats.AvgDurationMs)
+		}
+		if stats.P50DurationMs < 19.5 || stats.P50DurationMs > 20.5 || stats.P99DurationMs < 39 || stats.P99DurationMs > 41 {
+			t.Errorf("expected p50 of 20ms and p99 of 40ms, got %+v", stats)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no report received")
+	}
+
+	record(Invocation{FunctionName: "fn", Duration: 10 * time.Millisecond})
+	select {
+	case report := <-reports:
+		if stats := report["fn"]; stats.Hits != 1 || stats.Errors != 0 {
+			t.Errorf("expected counters to be reset after report, got %+v", stats)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no second report received")
+	}
+
+	if total := GetSnapshot()["fn"]; total.Hits != 2 || total.Errors != 1 {
+		t.Errorf("expected stats endpoint to keep totals, got %+v", total)
+	}
+}
+
+func TestReporterKeepsStatsOnFailure(t *testing.T) {
+	resetStats(t)
+	statusCode := http.StatusInternalServerError
+	var received map[string]FunctionStats
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_ = json.NewDecoder(req.Body).Decode(&received)
+		w.WriteHeader(statusCode)
+	}))
+	defer server.Close()
+
+	statsUrl := config.StatsUrl
+	config.StatsUrl = server.URL
+	defer func() { config.StatsUrl = statsUrl }()
+
+	record(Invocation{FunctionName: "fn", Duration: time.Millisecond})
+	reportStats()
+
+	statusCode = http.StatusOK
+	record(Invocation{FunctionName: "fn", Duration: time.Millisecond})
+	reportStats()
+	if stats := received["fn"]; stats.Hits != 2 {
+		t.Errorf("expected failed report to be retried, got %+v", stats)
+	}
+
+	received = nil
+	reportStats()
+	if received != nil {
+		t.Errorf("expected no report without new invocations, got %v", received)
+	}
+}
@@ -1,11 +1,18 @@
 package stats
 
 import (
+	"encoding/json"
 	"github.com/sirupsen/logrus"
 	"lambdahttpgw/config"
+	"net/http"
 )
 
 func Init() {
+	if config.MetricsEnabled {
+		enableMetrics()
+	} else {
+		logrus.Debugf("metrics are disabled")
+	}
 	if config.StatsRecorderEnabled {
 		enableRecorder()
 	} else {
@@ -17,3 +24,15 @@ func Init() {
 		logrus.Debugf("stats reporting is disabled")
 	}
 }
+
+// Handler returns the recorded stats for each function as JSON.
+func Handler(w http.ResponseWriter, _ *http.Request) {
+	body, err := json.Marshal(GetSnapshot())
+	if err != nil {
+		logrus.Errorf("error marshalling stats: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(body)
+}
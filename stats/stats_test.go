@@ -0,0 +1,47 @@
+package stats
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandlerAggregatesStats(t *testing.T) {
+	resetStats(t)
+	for _, invocation := range []Invocation{
+		{FunctionName: "users", Duration: 10 * time.Millisecond},
+		{FunctionName: "users", Duration: 30 * time.Millisecond},
+		{FunctionName: "users", Duration: 20 * time.Millisecond, Failed: true},
+		{FunctionName: "orders", Duration: 5 * time.Millisecond},
+	} {
+		record(invocation)
+	}
+
+	w := httptest.NewRecorder()
+	Handler(w, httptest.NewRequest(http.MethodGet, "/system/stats", nil))
+
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected 200 JSON, got %v %v", w.Code, w.Header().Get("Content-Type"))
+	}
+	var stats map[string]FunctionStats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		functionName  string
+		hits          int64
+		errors        int64
+		avgDurationMs float64
+	}{
+		{functionName: "users", hits: 2, errors: 1, avgDurationMs: 20},
+		{functionName: "orders", hits: 1, errors: 0, avgDurationMs: 5},
+	}
+	for _, tt := range tests {
+		actual := stats[tt.functionName]
+		if actual.Hits != tt.hits || actual.Errors != tt.errors || actual.AvgDurationMs != tt.avgDurationMs {
+			t.Errorf("expected %v to have %v hits, %v errors and %vms average, got %+v", tt.functionName, tt.hits, tt.errors, tt.avgDurationMs, actual)
+		}
+	}
+}
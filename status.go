@@ -0,0 +1,27 @@
+package main
+
+import (
+	"lambdahttpgw/config"
+)
+
+var (
+	// statusOverrideHeader is the function response header used to select
+	// a status override, where empty disables overrides.
+	statusOverrideHeader = config.GetStatusOverrideHeader()
+
+	// statusOverrides maps values of the status override header to the
+	// status code returned to the client.
+	statusOverrides = config.GetStatusOverrides()
+)
+
+// applyStatusOverride replaces the response status code if the function set
+// the status override header to a configured value, such as to return a 422
+// for a function response with an error envelope.
+func applyStatusOverride(resp *proxyResponse) {
+	if statusOverrideHeader == "" {
+		return
+	}
+	if statusCode, exists := statusOverrides[resp.getHeader(statusOverrideHeader)]; exists {
+		resp.StatusCode = statusCode
+	}
+}
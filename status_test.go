@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatusOverride(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     string
+		appStatus  string
+		wantStatus int
+	}{
+		{name: "disabled", appStatus: "validation_error", wantStatus: http.StatusOK},
+		{name: "override", header: "X-App-Status", appStatus: "validation_error", wantStatus: http.StatusUnprocessableEntity},
+		{name: "unmapped value", header: "X-App-Status", appStatus: "ok", wantStatus: http.StatusOK},
+		{name: "missing header", header: "X-App-Status", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setString(t, &statusOverrideHeader, tt.header)
+			previousOverrides, previousStripped := statusOverrides, strippedResponseHeaders
+			statusOverrides = map[string]int{"validation_error": http.StatusUnprocessableEntity}
+			strippedResponseHeaders = buildStrippedHeaders([]string{tt.header})
+			t.Cleanup(func() { statusOverrides, strippedResponseHeaders = previousOverrides, previousStripped })
+			var headers map[string]string
+			if tt.appStatus != "" {
+				headers = map[string]string{"X-App-Status": tt.appStatus}
+			}
+			fake := &fakeInvoker{respond: respondWith(proxyOutput(http.StatusOK, headers, `{"error":"invalid"}`), nil)}
+
+			w := serve(newFakeClients(fake), httptest.NewRequest(http.MethodGet, "/fn/", nil))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("expected status %v, got %v", tt.wantStatus, w.Code)
+			}
+			if appStatus := w.Header().Get("X-App-Status"); tt.header != "" && appStatus != "" {
+				t.Errorf("expected the status override header not to be returned, got %q", appStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"text/template"
+)
+
+// requestTemplateData is the data available to request templates.
+type requestTemplateData struct {
+	Method         string
+	Path           string
+	Headers        map[string]string
+	Query          map[string]string
+	PathParameters map[string]string
+	Body           string
+}
+
+// responseTemplateData is the data available to response templates.
+type responseTemplateData struct {
+	StatusCode int
+	Headers    map[string]string
+	// Body is the response body parsed as JSON
+	Body interface{}
+	// RawBody is the unparsed response body
+	RawBody string
+}
+
+// renderRequestTemplate reshapes the request body using the route request
+// template, such as to wrap it in an envelope expected by the function.
+func renderRequestTemplate(tmpl *template.Template, proxyReq *proxyRequest) ([]byte, error) {
+	var rendered bytes.Buffer
+	err := tmpl.Execute(&rendered, requestTemplateData{
+		Method:         proxyReq.HTTPMethod,
+		Path:           proxyReq.Path,
+		Headers:        proxyReq.Headers,
+		Query:          proxyReq.QueryStringParameters,
+		PathParameters: proxyReq.PathParameters,
+		Body:           string(proxyReq.Body),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errRequestTemplate, err)
+	}
+	return rendered.Bytes(), nil
+}
+
+// renderResponseTemplate reshapes the JSON response body using the route
+// response template, such as to unwrap an envelope returned by the function.
+// If the body is not JSON, or the template fails, the response is returned
+// unchanged, so errors are only logged.
+func renderResponseTemplate(log *logrus.Entry, tmpl *template.Template, resp *proxyResponse) {
+	var body interface{}
+	if err := json.Unmarshal(resp.Body, &body); err != nil {
+		log.Warnf("not rendering response template - response body is not JSON: %v", err)
+		return
+	}
+	var rendered bytes.Buffer
+	err := tmpl.Execute(&rendered, responseTemplateData{
+		StatusCode: resp.StatusCode,
+		Headers:    resp.Headers,
+		Body:       body,
+		RawBody:    string(resp.Body),
+	})
+	if err != nil {
+		log.Warnf("error rendering response template - returning response unchanged: %v", err)
+		return
+	}
+	resp.Body = rendered.Bytes()
+	if resp.getHeader("Content-Length") != "" {
+		resp.setHeader("Content-Length", strconv.Itoa(len(resp.Body)))
+	}
+}
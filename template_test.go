@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRequestTemplate(t *testing.T) {
+	tests := []struct {
+		name       string
+		routeMap   string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "envelope",
+			routeMap:   `{"/users/{id}":{"function":"user-fn","requestTemplate":"{\"id\":{{json .PathParameters.id}},\"method\":{{json .Method}},\"data\":{{.Body}}}"}}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"id":"123","method":"POST","data":{"name":"test"}}`,
+		},
+		{
+			name:       "pass-through",
+			routeMap:   `{"/users/{id}":"user-fn"}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"name":"test"}`,
+		},
+		{
+			name:       "invalid field",
+			routeMap:   `{"/users/{id}":{"function":"user-fn","requestTemplate":"{{.Missing}}"}}`,
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRouteMap(t, tt.routeMap)
+			fake := &fakeInvoker{}
+			req := httptest.NewRequest(http.MethodPost, "/users/123", strings.NewReader(`{"name":"test"}`))
+			req.Header.Set("Content-Type", "application/json")
+
+			w := serve(newFakeClients(fake), req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %v, got %v", tt.wantStatus, w.Code)
+			}
+			if tt.wantBody == "" {
+				if invocations := len(fake.invocations()); invocations != 0 {
+					t.Errorf("expected the function not to be invoked, got %v invocations", invocations)
+				}
+				return
+			}
+			if body := fake.lastEvent(t).Body; body != tt.wantBody {
+				t.Errorf("expected body %v, got %v", tt.wantBody, body)
+			}
+		})
+	}
+}
+
+func TestResponseTemplate(t *testing.T) {
+	envelope := `{"status":"ok","data":{"id":123,"name":"test"}}`
+	tests := []struct {
+		name          string
+		routeMap      string
+		body          string
+		contentLength bool
+		want          string
+	}{
+		{
+			name:     "unwrap envelope",
+			routeMap: `{"/users":{"function":"user-fn","responseTemplate":"{{json .Body.data}}"}}`,
+			body:     envelope,
+			want:     `{"id":123,"name":"test"}`,
+		},
+		{
+			name:          "content length updated",
+			routeMap:      `{"/users":{"function":"user-fn","responseTemplate":"{{json .Body.data}}"}}`,
+			body:          envelope,
+			contentLength: true,
+			want:          `{"id":123,"name":"test"}`,
+		},
+		{
+			name:     "status and headers",
+			routeMap: `{"/users":{"function":"user-fn","responseTemplate":"{{.StatusCode}} {{index .Headers \"Content-Type\"}}"}}`,
+			body:     envelope,
+			want:     "200 application/json",
+		},
+		{
+			name:     "pass-through",
+			routeMap: `{"/users":"user-fn"}`,
+			body:     envelope,
+			want:     envelope,
+		},
+		{
+			name:     "missing field",
+			routeMap: `{"/users":{"function":"user-fn","responseTemplate":"{{json .Body.missing.id}}"}}`,
+			body:     envelope,
+			want:     envelope,
+		},
+		{
+			name:     "not JSON",
+			routeMap: `{"/users":{"function":"user-fn","responseTemplate":"{{json .Body.data}}"}}`,
+			body:     "plain text",
+			want:     "plain text",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRouteMap(t, tt.routeMap)
+			headers := map[string]string{"Content-Type": "application/json"}
+			if tt.contentLength {
+				headers["Content-Length"] = strconv.Itoa(len(tt.body))
+			}
+			fake := &fakeInvoker{respond: respondWith(proxyOutput(http.StatusOK, headers, tt.body), nil)}
+
+			w := serve(newFakeClients(fake), httptest.NewRequest(http.MethodGet, "/users", nil))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %v: %s", w.Code, w.Body)
+			}
+			if body := w.Body.String(); body != tt.want {
+				t.Errorf("expected body %v, got %v", tt.want, body)
+			}
+			if contentLength := w.Header().Get("Content-Length"); tt.contentLength && contentLength != strconv.Itoa(len(tt.want)) {
+				t.Errorf("expected Content-Length %v, got %v", len(tt.want), contentLength)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"lambdahttpgw/config"
+	"strings"
+)
+
+var (
+	// tenantHeader is the request header identifying the tenant.
+	tenantHeader = config.GetTenantHeader()
+
+	// tenants maps tenant names to the account and functions used for
+	// their requests.
+	tenants = config.GetTenants()
+)
+
+// resolveTenant returns the tenant identified by the request header, or nil
+// if the request does not identify a tenant.
+func resolveTenant(tenantName string) (*config.Tenant, error) {
+	if tenantName == "" {
+		return nil, nil
+	}
+	tenant, exists := tenants[tenantName]
+	if !exists {
+		return nil, fmt.Errorf("%w: %v", errUnknownTenant, tenantName)
+	}
+	return &tenant, nil
+}
+
+// applyTenantPrefix prefixes the function name with the tenant function
+// prefix, ahead of any qualifier. ARNs identify the function fully, so are
+// not prefixed, but must be in the account of the tenant role and have the
+// tenant prefix, so tenants cannot invoke other functions by ARN.
+func applyTenantPrefix(tenant *config.Tenant, functionName string) (string, error) {
+	if tenant == nil {
+		return functionName, nil
+	}
+	var account, name string
+	parts := strings.Split(functionName, ":")
+	switch {
+	case strings.HasPrefix(functionName, "arn:"):
+		if len(parts) < 7 {
+			return "", fmt.Errorf("%w: %v", errFunctionNotAllowed, functionName)
+		}
+		account, name = parts[4], parts[6]
+	case len(parts) >= 3 && parts[1] == "function":
+		account, name = parts[0], parts[2]
+	default:
+		return tenant.FunctionPrefix + functionName, nil
+	}
+	if account == "" || account != tenantAccount(tenant) || !strings.HasPrefix(name, tenant.FunctionPrefix) {
+		return "", fmt.Errorf("%w: %v", errFunctionNotAllowed, functionName)
+	}
+	return functionName, nil
+}
+
+// tenantAccount returns the account of the tenant role, or an empty string
+// if the tenant has no role.
+func tenantAccount(tenant *config.Tenant) string {
+	parts := strings.Split(tenant.RoleARN, ":")
+	if len(parts) < 5 {
+		return ""
+	}
+	return parts[4]
+}
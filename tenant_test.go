@@ -0,0 +1,149 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"lambdahttpgw/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTenantHeader(t *testing.T) {
+	previous := tenants
+	tenants = map[string]config.Tenant{
+		"acme": {Region: "us-east-1", RoleARN: "arn:aws:iam::210987654321:role/acme", FunctionPrefix: "acme-"},
+	}
+	t.Cleanup(func() { tenants = previous })
+	tests := []struct {
+		name         string
+		tenant       string
+		statusCode   int
+		functionName string
+		region       string
+		tenantRole   bool
+	}{
+		{name: "known tenant", tenant: "acme", statusCode: http.StatusOK, functionName: "acme-orders", region: "us-east-1", tenantRole: true},
+		{name: "unknown tenant", tenant: "other", statusCode: http.StatusForbidden},
+		{name: "no tenant", statusCode: http.StatusOK, functionName: "orders", region: region},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeInvoker{}
+			clients := newFakeClients(fake)
+			req := httptest.NewRequest(http.MethodGet, "/orders/", nil)
+			if tt.tenant != "" {
+				req.Header.Set(tenantHeader, tt.tenant)
+			}
+
+			w := serve(clients, req)
+
+			if w.Code != tt.statusCode {
+				t.Fatalf("expected %v, got %v: %s", tt.statusCode, w.Code, w.Body)
+			}
+			if tt.statusCode != http.StatusOK {
+				if len(fake.invocations()) > 0 {
+					t.Error("expected the function not to be invoked")
+				}
+				return
+			}
+			if functionName := aws.StringValue(fake.invocations()[0].FunctionName); functionName != tt.functionName {
+				t.Errorf("expected function %v, got %v", tt.functionName, functionName)
+			}
+			cfg := fake.configs[0]
+			if clientRegion := aws.StringValue(cfg.Region); clientRegion != tt.region {
+				t.Errorf("expected a client for region %v, got %v", tt.region, clientRegion)
+			}
+			if usesTenantRole := cfg.Credentials != clients.credentials(role{}); usesTenantRole != tt.tenantRole {
+				t.Errorf("expected tenant role credentials to be %v", tt.tenantRole)
+			}
+		})
+	}
+}
+
+func TestApplyTenantPrefix(t *testing.T) {
+	tenant := &config.Tenant{RoleARN: "arn:aws:iam::210987654321:role/acme", FunctionPrefix: "acme-"}
+	tests := []struct {
+		name         string
+		tenant       *config.Tenant
+		functionName string
+		prefixed     string
+		wantErr      bool
+	}{
+		{name: "no tenant", functionName: "arn:aws:lambda:us-east-1:123456789012:function:orders", prefixed: "arn:aws:lambda:us-east-1:123456789012:function:orders"},
+		{name: "name", tenant: tenant, functionName: "orders", prefixed: "acme-orders"},
+		{name: "qualified name", tenant: tenant, functionName: "orders:prod", prefixed: "acme-orders:prod"},
+		{name: "tenant ARN", tenant: tenant, functionName: "arn:aws:lambda:us-east-1:210987654321:function:acme-orders", prefixed: "arn:aws:lambda:us-east-1:210987654321:function:acme-orders"},
+		{name: "qualified tenant ARN", tenant: tenant, functionName: "arn:aws:lambda:us-east-1:210987654321:function:acme-orders:prod", prefixed: "arn:aws:lambda:us-east-1:210987654321:function:acme-orders:prod"},
+		{name: "ARN without tenant prefix", tenant: tenant, functionName: "arn:aws:lambda:us-east-1:210987654321:function:orders", wantErr: true},
+		{name: "ARN in other account", tenant: tenant, functionName: "arn:aws:lambda:us-east-1:123456789012:function:acme-orders", wantErr: true},
+		{name: "truncated ARN", tenant: tenant, functionName: "arn:aws:lambda", wantErr: true},
+		{name: "tenant partial ARN", tenant: tenant, functionName: "210987654321:function:acme-orders", prefixed: "210987654321:function:acme-orders"},
+		{name: "partial ARN in other account", tenant: tenant, functionName: "123456789012:function:acme-orders", wantErr: true},
+		{name: "ARN for tenant without role", tenant: &config.Tenant{FunctionPrefix: "acme-"}, functionName: "arn:aws:lambda:us-east-1:210987654321:function:acme-orders", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefixed, err := applyTenantPrefix(tt.tenant, tt.functionName)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error to be %v, got %v", tt.wantErr, err)
+			}
+			if prefixed != tt.prefixed {
+				t.Errorf("expected function %q, got %q", tt.prefixed, prefixed)
+			}
+		})
+	}
+}
+
+func TestTenantFunctionHeader(t *testing.T) {
+	previous := tenants
+	tenants = map[string]config.Tenant{
+		"acme": {Region: "us-east-1", RoleARN: "arn:aws:iam::210987654321:role/acme", FunctionPrefix: "acme-"},
+	}
+	t.Cleanup(func() { tenants = previous })
+	tests := []struct {
+		name         string
+		function     string
+		statusCode   int
+		functionName string
+		qualifier    string
+	}{
+		{name: "qualified name", function: "orders:prod", statusCode: http.StatusOK, functionName: "acme-orders", qualifier: "prod"},
+		{
+			name:         "tenant ARN",
+			function:     "arn:aws:lambda:us-east-1:210987654321:function:acme-orders",
+			statusCode:   http.StatusOK,
+			functionName: "arn:aws:lambda:us-east-1:210987654321:function:acme-orders",
+		},
+		{name: "ARN in other account", function: "arn:aws:lambda:us-east-1:123456789012:function:acme-orders", statusCode: http.StatusForbidden},
+		{name: "ARN without tenant prefix", function: "arn:aws:lambda:us-east-1:210987654321:function:orders", statusCode: http.StatusForbidden},
+		{name: "ARN in other region", function: "arn:aws:lambda:us-west-2:210987654321:function:acme-orders", statusCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeInvoker{}
+			req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+			req.Header.Set(tenantHeader, "acme")
+			req.Header.Set(functionHeader, tt.function)
+
+			w := serve(newFakeClients(fake), req)
+
+			if w.Code != tt.statusCode {
+				t.Fatalf("expected %v, got %v: %s", tt.statusCode, w.Code, w.Body)
+			}
+			if tt.statusCode != http.StatusOK {
+				if len(fake.invocations()) > 0 {
+					t.Error("expected the function not to be invoked")
+				}
+				return
+			}
+			input := fake.invocations()[0]
+			if functionName := aws.StringValue(input.FunctionName); functionName != tt.functionName {
+				t.Errorf("expected function %v, got %v", tt.functionName, functionName)
+			}
+			if qualifier := aws.StringValue(input.Qualifier); qualifier != tt.qualifier {
+				t.Errorf("expected qualifier %q, got %q", tt.qualifier, qualifier)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+	"lambdahttpgw/config"
+	"net/http"
+)
+
+var (
+	tracingEnabled = config.GetOtelEnabled()
+	tracer         = otel.Tracer("lambdahttpgw")
+)
+
+// initTracing configures the OTLP span exporter, using the standard
+// OTEL_EXPORTER_OTLP_* environment variables. It returns a function
+// that flushes pending spans on shutdown.
+func initTracing() (shutdown func(ctx context.Context) error) {
+	if !tracingEnabled {
+		logrus.Debugf("tracing is disabled")
+		return func(ctx context.Context) error { return nil }
+	}
+	logrus.Debugf("enabling tracing")
+
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		logrus.Fatalf("error creating trace exporter: %v", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String("lambdahttpgw"),
+			semconv.ServiceVersionKey.String(version),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return provider.Shutdown
+}
+
+// traced starts a span for each request handled by next. The trace context
+// is injected into the request headers, so it is propagated to the function.
+func traced(next http.HandlerFunc) http.HandlerFunc {
+	if !tracingEnabled {
+		return next
+	}
+	return func(w http.ResponseWriter, req *http.Request) {
+		propagator := otel.GetTextMapPropagator()
+		ctx := propagator.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+		ctx, span := tracer.Start(ctx, "HTTP "+req.Method, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+
+		propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+		recorder := &statusRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		next(recorder, req.WithContext(ctx))
+
+		span.SetAttributes(
+			semconv.HTTPMethodKey.String(req.Method),
+			semconv.HTTPStatusCodeKey.Int(recorder.statusCode),
+		)
+		if recorder.statusCode >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(recorder.statusCode))
+		}
+	}
+}
+
+// setSpanFunction records the invoked function on the current span, if any.
+func setSpanFunction(ctx context.Context, functionName string) {
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("faas.invoked_name", functionName))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// enableTracing records spans in memory for the duration of the test.
+func enableTracing(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previousTracer, previousPropagator := tracer, otel.GetTextMapPropagator()
+	tracer = provider.Tracer("test")
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	setBool(t, &tracingEnabled, true)
+	t.Cleanup(func() {
+		tracer = previousTracer
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+	return exporter
+}
+
+func TestTracing(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		status     codes.Code
+	}{
+		{name: "success", statusCode: http.StatusOK, status: codes.Unset},
+		{name: "server error", statusCode: http.StatusServiceUnavailable, status: codes.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter := enableTracing(t)
+			fake := &fakeInvoker{respond: respondWith(proxyOutput(tt.statusCode, nil, ""), nil)}
+			clients := newFakeClients(fake)
+			setReady()
+			w := httptest.NewRecorder()
+			traced(func(w http.ResponseWriter, req *http.Request) {
+				handler(w, req, clients)
+			})(w, httptest.NewRequest(http.MethodGet, "/traced-fn/", nil))
+
+			spans := exporter.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("expected 1 span, got %v", len(spans))
+			}
+			span := spans[0]
+			if span.Name != "HTTP GET" || span.SpanKind != trace.SpanKindServer || span.Status.Code != tt.status {
+				t.Errorf("unexpected span %v %v %v", span.Name, span.SpanKind, span.Status.Code)
+			}
+			attributes := make(map[attribute.Key]attribute.Value)
+			for _, kv := range span.Attributes {
+				attributes[kv.Key] = kv.Value
+			}
+			if name := attributes["faas.invoked_name"].AsString(); name != "traced-fn" {
+				t.Errorf("expected function name attribute, got %q", name)
+			}
+			if statusCode := attributes["http.status_code"].AsInt64(); statusCode != int64(tt.statusCode) {
+				t.Errorf("expected status code attribute %v, got %v", tt.statusCode, statusCode)
+			}
+
+			traceparent := fake.lastEvent(t).Headers["Traceparent"]
+			if !strings.Contains(traceparent, span.SpanContext.TraceID().String()) {
+				t.Errorf("expected the trace context to be propagated to the function, got %q", traceparent)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/sirupsen/logrus"
+	"lambdahttpgw/config"
+	"time"
+)
+
+// warmupPayload is the event sent to functions by the warmup pinger, which
+// functions can detect to return early.
+var warmupPayload = []byte(`{"warmup":true}`)
+
+// startWarmup periodically invokes the configured functions asynchronously
+// with the warmup event, to reduce cold starts for subsequent requests, until
+// the context is done.
+func startWarmup(ctx context.Context, clients *clientCache) {
+	functions := config.GetWarmupFunctions()
+	if len(functions) == 0 {
+		return
+	}
+	interval := config.GetWarmupInterval()
+	logrus.Infof("warming up %d functions every %v", len(functions), interval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			for _, functionName := range functions {
+				warmup(clients, functionName)
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// warmup sends the warmup event to the function, logging any failure.
+func warmup(clients *clientCache, functionName string) {
+	name, qualifier, err := parseFunctionName(functionName, "")
+	if err != nil {
+		logrus.Errorf("invalid warmup function: %v", err)
+		return
+	}
+	functionRegion, err := resolveRegion(name, "")
+	if err != nil {
+		logrus.Errorf("invalid warmup function region: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), invokeTimeout)
+	defer cancel()
+
+	input := &lambda.InvokeInput{
+		FunctionName:   aws.String(name),
+		InvocationType: aws.String(lambda.InvocationTypeEvent),
+		Payload:        warmupPayload,
+	}
+	if qualifier != "" {
+		input.Qualifier = aws.String(qualifier)
+	}
+	if _, err := clients.get(functionRegion, role{}).InvokeWithContext(ctx, input); err != nil {
+		logrus.Warnf("failed to warm up function %v: %v", functionName, err)
+		return
+	}
+	logrus.Debugf("sent warmup event to function %v", functionName)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"testing"
+	"time"
+)
+
+func TestWarmup(t *testing.T) {
+	t.Setenv("WARMUP_FUNCTIONS", "orders,users:live")
+	t.Setenv("WARMUP_INTERVAL", "10ms")
+	fake := &fakeInvoker{}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	startWarmup(ctx, newFakeClients(fake))
+
+	// each function is invoked on start, then again after the interval
+	counts := make(map[string]int)
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		counts = make(map[string]int)
+		for _, input := range fake.invocations() {
+			counts[aws.StringValue(input.FunctionName)]++
+		}
+		if counts["orders"] >= 2 && counts["users"] >= 2 {
+			break
+		}
+	}
+	if counts["orders"] < 2 || counts["users"] < 2 {
+		t.Fatalf("expected each function to be warmed up repeatedly, got %v", counts)
+	}
+	for _, input := range fake.invocations() {
+		if invocationType := aws.StringValue(input.InvocationType); invocationType != lambda.InvocationTypeEvent {
+			t.Errorf("expected an event invocation, got %v", invocationType)
+		}
+		if payload := string(input.Payload); payload != `{"warmup":true}` {
+			t.Errorf("expected the warmup event, got %v", payload)
+		}
+		if aws.StringValue(input.FunctionName) == "users" && aws.StringValue(input.Qualifier) != "live" {
+			t.Errorf("expected the qualifier to be set, got %v", aws.StringValue(input.Qualifier))
+		}
+	}
+
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	stopped := len(fake.invocations())
+	time.Sleep(50 * time.Millisecond)
+	if invocations := len(fake.invocations()); invocations != stopped {
+		t.Errorf("expected warmup to stop when the context is done, got %v more invocations", invocations-stopped)
+	}
+}